@@ -37,6 +37,11 @@ func main() {
 		logger.Fatal("Failed to create server", zap.Error(err))
 	}
 
+	// Reload services, authentication and resilience settings without a restart
+	if err := srv.WatchConfig(*configPath); err != nil {
+		logger.Fatal("Failed to watch configuration", zap.Error(err))
+	}
+
 	// Start the server in a goroutine
 	go func() {
 		if err := srv.Start(); err != nil {
@@ -0,0 +1,183 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Supported load balancing strategies
+const (
+	StrategyRoundRobin         = "round_robin"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyRandom             = "random"
+	StrategyLeastRequests      = "least_requests"
+	StrategyPowerOfTwo         = "power_of_two"
+	StrategyRingHash           = "ring_hash"
+)
+
+// Balancer selects one target out of a list of candidates
+type Balancer interface {
+	// Next returns the target that should receive the next request.
+	// The candidate list is never empty.
+	Next(targets []*Target) *Target
+}
+
+// Target represents a single upstream endpoint of a service
+type Target struct {
+	// ID is a stable identifier derived from the URL that is safe to expose to clients
+	ID  string
+	URL string
+	// weight, priority and group are reported by the target source and may
+	// change while the target keeps its state
+	weight   atomic.Int64
+	priority atomic.Int64
+	group    atomic.Pointer[string]
+	inflight atomic.Int64
+	healthy  atomic.Bool
+	// ejectedUntil holds the unix nano time until which the target is ejected
+	ejectedUntil atomic.Int64
+	// warmupSince holds the unix nano time from which the target ramps up to its full weight
+	warmupSince atomic.Int64
+}
+
+// NewTarget creates a new target with the given weight
+func NewTarget(url string, weight int) *Target {
+	sum := sha256.Sum256([]byte(url))
+	t := &Target{
+		ID:  hex.EncodeToString(sum[:8]),
+		URL: url,
+	}
+	t.setWeight(weight)
+	t.setGroup(DefaultGroup)
+	// Targets are assumed healthy until a health check says otherwise
+	t.healthy.Store(true)
+	return t
+}
+
+// Weight returns the load balancing weight of the target
+func (t *Target) Weight() int {
+	return int(t.weight.Load())
+}
+
+// setWeight changes the load balancing weight of the target
+func (t *Target) setWeight(weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	t.weight.Store(int64(weight))
+}
+
+// Priority returns the failover tier of the target, 0 being the primary tier
+func (t *Target) Priority() int {
+	return int(t.priority.Load())
+}
+
+// setPriority moves the target to another failover tier
+func (t *Target) setPriority(priority int) {
+	t.priority.Store(int64(priority))
+}
+
+// Group returns the target group used for traffic splitting
+func (t *Target) Group() string {
+	return *t.group.Load()
+}
+
+// setGroup moves the target to another target group, the default one when empty
+func (t *Target) setGroup(group string) {
+	if group == "" {
+		group = DefaultGroup
+	}
+	t.group.Store(&group)
+}
+
+// Acquire marks the start of a request to the target
+func (t *Target) Acquire() {
+	t.inflight.Add(1)
+}
+
+// Release marks the end of a request to the target
+func (t *Target) Release() {
+	t.inflight.Add(-1)
+}
+
+// Inflight returns the number of outstanding requests to the target
+func (t *Target) Inflight() int64 {
+	return t.inflight.Load()
+}
+
+// Healthy reports whether the target passes its health checks
+func (t *Target) Healthy() bool {
+	return t.healthy.Load()
+}
+
+// SetHealthy updates the health state of the target and reports whether it changed.
+// A target that becomes healthy again starts warming up.
+func (t *Target) SetHealthy(healthy bool) bool {
+	changed := t.healthy.Swap(healthy) != healthy
+	if changed && healthy {
+		t.StartWarmup(time.Now())
+	}
+	return changed
+}
+
+// Eject removes the target from selection until the given time, after which it warms up
+func (t *Target) Eject(until time.Time) {
+	t.ejectedUntil.Store(until.UnixNano())
+	t.StartWarmup(until)
+}
+
+// StartWarmup makes the target ramp up to its full weight from the given time
+func (t *Target) StartWarmup(since time.Time) {
+	t.warmupSince.Store(since.UnixNano())
+}
+
+// warmup returns the fraction of its weight the target currently receives
+// when ramping linearly from minFactor to 1 over the window
+func (t *Target) warmup(window time.Duration, minFactor float64) float64 {
+	since := t.warmupSince.Load()
+	if window <= 0 || since == 0 {
+		return 1
+	}
+
+	elapsed := time.Duration(time.Now().UnixNano() - since)
+	switch {
+	case elapsed >= window:
+		return 1
+	case elapsed <= 0:
+		return minFactor
+	}
+	return minFactor + (1-minFactor)*float64(elapsed)/float64(window)
+}
+
+// Ejected reports whether the target is currently ejected by outlier detection
+func (t *Target) Ejected() bool {
+	return time.Now().UnixNano() < t.ejectedUntil.Load()
+}
+
+// Available reports whether the target may receive traffic
+func (t *Target) Available() bool {
+	return t.Healthy() && !t.Ejected()
+}
+
+// New creates a balancer for the given strategy
+func New(strategy string) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return NewRoundRobin(), nil
+	case StrategyWeightedRoundRobin:
+		return NewWeightedRoundRobin(), nil
+	case StrategyRandom:
+		return NewRandom(), nil
+	case StrategyLeastRequests:
+		return NewLeastRequests(), nil
+	case StrategyPowerOfTwo:
+		return NewPowerOfTwo(), nil
+	case StrategyRingHash:
+		return NewRingHash(), nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+}
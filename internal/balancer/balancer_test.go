@@ -0,0 +1,184 @@
+package balancer
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// newTargets creates a target per weight, named a, b, c and so on
+func newTargets(weights ...int) []*Target {
+	targets := make([]*Target, 0, len(weights))
+	for i, weight := range weights {
+		targets = append(targets, NewTarget("http://"+string(rune('a'+i))+":8080", weight))
+	}
+	return targets
+}
+
+// share returns the fraction of picks each target received
+func share(b Balancer, targets []*Target, picks int) []float64 {
+	counts := make(map[*Target]int)
+	for i := 0; i < picks; i++ {
+		counts[b.Next(targets)]++
+	}
+	shares := make([]float64, 0, len(targets))
+	for _, t := range targets {
+		shares = append(shares, float64(counts[t])/float64(picks))
+	}
+	return shares
+}
+
+func TestBalancerShares(t *testing.T) {
+	tests := []struct {
+		strategy string
+		weights  []int
+		want     []float64
+		// tolerance is the allowed difference of random strategies
+		tolerance float64
+	}{
+		{strategy: StrategyRoundRobin, weights: []int{3, 1}, want: []float64{0.5, 0.5}},
+		{strategy: StrategyWeightedRoundRobin, weights: []int{3, 1}, want: []float64{0.75, 0.25}},
+		{strategy: StrategyWeightedRoundRobin, weights: []int{5, 1, 1, 1}, want: []float64{0.625, 0.125, 0.125, 0.125}},
+		{strategy: StrategyRandom, weights: []int{3, 1}, want: []float64{0.75, 0.25}, tolerance: 0.03},
+		{strategy: StrategyLeastRequests, weights: []int{1, 1}, want: []float64{0.5, 0.5}, tolerance: 0.03},
+		{strategy: StrategyPowerOfTwo, weights: []int{1, 1, 1}, want: []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}, tolerance: 0.03},
+		{strategy: StrategyRingHash, weights: []int{1, 1}, want: []float64{0.5, 0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy+"/"+strconv.Itoa(len(tt.weights)), func(t *testing.T) {
+			b, err := New(tt.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := share(b, newTargets(tt.weights...), 8000)
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > tt.tolerance {
+					t.Errorf("shares = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	targets := newTargets(5, 1, 1)
+	b := NewWeightedRoundRobin()
+
+	// Picks of the heavy target are spread between the others
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	for i, w := range want {
+		if got := b.Next(targets); got != targets[w] {
+			t.Fatalf("pick %d = %s, want %s", i, got.URL, targets[w].URL)
+		}
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	tests := []struct {
+		name     string
+		weights  []int
+		inflight []int
+		want     int
+	}{
+		{name: "fewest requests", weights: []int{1, 1, 1}, inflight: []int{3, 1, 2}, want: 1},
+		{name: "relative to weight", weights: []int{1, 4}, inflight: []int{1, 3}, want: 1},
+		{name: "idle light target", weights: []int{1, 4}, inflight: []int{0, 4}, want: 0},
+	}
+
+	for _, tt := range tests {
+		for _, strategy := range []string{StrategyLeastRequests, StrategyPowerOfTwo} {
+			t.Run(strategy+"/"+tt.name, func(t *testing.T) {
+				targets := newTargets(tt.weights...)
+				for i, n := range tt.inflight {
+					for j := 0; j < n; j++ {
+						targets[i].Acquire()
+					}
+				}
+				b, err := New(strategy)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Power of two only compares two targets, so it gets a pair
+				// holding the least loaded one
+				candidates := targets
+				if strategy == StrategyPowerOfTwo {
+					other := (tt.want + 1) % len(targets)
+					candidates = []*Target{targets[tt.want], targets[other]}
+				}
+				for i := 0; i < 20; i++ {
+					if got := b.Next(candidates); got != targets[tt.want] {
+						t.Fatalf("picked %s, want %s", got.URL, targets[tt.want].URL)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRingHashMovesOnlyKeysOfRemovedTarget(t *testing.T) {
+	targets := newTargets(1, 1, 1, 1)
+	b := NewRingHash()
+
+	owners := make(map[string]*Target)
+	for i := 0; i < 1000; i++ {
+		key := "user-" + strconv.Itoa(i)
+		owners[key] = b.NextForKey(key, targets)
+		if again := b.NextForKey(key, targets); again != owners[key] {
+			t.Fatalf("key %s moved from %s to %s", key, owners[key].URL, again.URL)
+		}
+	}
+
+	removed := targets[1]
+	rest := []*Target{targets[0], targets[2], targets[3]}
+	for key, owner := range owners {
+		got := b.NextForKey(key, rest)
+		if owner != removed && got != owner {
+			t.Errorf("key %s moved from %s to %s", key, owner.URL, got.URL)
+		}
+	}
+}
+
+func TestRingHashKeyShares(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		want    []float64
+	}{
+		{name: "equal weights", weights: []int{1, 1, 1, 1}, want: []float64{0.25, 0.25, 0.25, 0.25}},
+		{name: "weighted", weights: []int{3, 1}, want: []float64{0.75, 0.25}},
+		{name: "single target", weights: []int{2}, want: []float64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := newTargets(tt.weights...)
+			b, other := NewRingHash(), NewRingHash()
+
+			counts := make(map[*Target]int)
+			const keys = 20000
+			for i := 0; i < keys; i++ {
+				key := "user-" + strconv.Itoa(i)
+				got := b.NextForKey(key, targets)
+				// Every gateway instance maps a key onto the same target
+				if again := other.NextForKey(key, targets); again != got {
+					t.Fatalf("key %s on %s and %s", key, got.URL, again.URL)
+				}
+				counts[got]++
+			}
+			for i, target := range targets {
+				if got := float64(counts[target]) / keys; math.Abs(got-tt.want[i]) > 0.05 {
+					t.Errorf("share of %s = %.3f, want %.3f", target.URL, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewUnknownStrategy(t *testing.T) {
+	if _, err := New("fastest"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,65 @@
+package balancer
+
+import (
+	"math/rand/v2"
+)
+
+// LeastRequests picks the target with the fewest outstanding requests
+type LeastRequests struct{}
+
+// NewLeastRequests creates a new least-outstanding-requests balancer
+func NewLeastRequests() *LeastRequests {
+	return &LeastRequests{}
+}
+
+// Next returns the least loaded target, breaking ties at random
+func (b *LeastRequests) Next(targets []*Target) *Target {
+	var best *Target
+	ties := 0
+	for _, t := range targets {
+		switch {
+		case best == nil || less(t, best):
+			best = t
+			ties = 1
+		case !less(best, t):
+			// Reservoir sampling keeps tie breaking uniform
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = t
+			}
+		}
+	}
+	return best
+}
+
+// PowerOfTwo samples two random targets and picks the less loaded one
+type PowerOfTwo struct{}
+
+// NewPowerOfTwo creates a new power-of-two-choices balancer
+func NewPowerOfTwo() *PowerOfTwo {
+	return &PowerOfTwo{}
+}
+
+// Next returns the less loaded of two randomly chosen targets
+func (b *PowerOfTwo) Next(targets []*Target) *Target {
+	if len(targets) == 1 {
+		return targets[0]
+	}
+
+	i := rand.IntN(len(targets))
+	j := rand.IntN(len(targets) - 1)
+	if j >= i {
+		j++
+	}
+
+	if less(targets[j], targets[i]) {
+		return targets[j]
+	}
+	return targets[i]
+}
+
+// less reports whether a is less loaded than b relative to their weights
+func less(a, b *Target) bool {
+	// Compare inflight/weight without dividing
+	return (a.Inflight()+1)*int64(b.Weight()) < (b.Inflight()+1)*int64(a.Weight())
+}
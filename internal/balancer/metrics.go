@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"api-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WeightCollector reports the effective weight of every target when scraped,
+// so warming targets are visible without a background updater
+type WeightCollector struct {
+	pools func() map[string]*Pool
+	desc  *prometheus.Desc
+}
+
+// NewWeightCollector creates a collector for the targets of the pools. The
+// pools are looked up on every scrape since routes can change at runtime.
+func NewWeightCollector(pools func() map[string]*Pool) *WeightCollector {
+	return &WeightCollector{
+		pools: pools,
+		desc:  metrics.NewUpstreamEffectiveWeightDesc(),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *WeightCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *WeightCollector) Collect(ch chan<- prometheus.Metric) {
+	for service, pool := range c.pools() {
+		for _, t := range pool.Targets() {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, pool.EffectiveWeight(t), service, t.URL)
+		}
+	}
+}
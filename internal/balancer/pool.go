@@ -0,0 +1,440 @@
+package balancer
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gofiber/fiber/v2"
+)
+
+// StaticSource is the source name of targets listed directly in the config
+const StaticSource = "static"
+
+// defaultSlowStartMinWeightPercent is the share of its weight a warming target starts with
+const defaultSlowStartMinWeightPercent = 10
+
+// defaultFailoverMinHealthyPercent is the healthy share of a tier below which traffic spills to the next tier
+const defaultFailoverMinHealthyPercent = 70
+
+// discoverySchemes are target schemes that are resolved by a discovery provider
+var discoverySchemes = []string{"dns+srv://", "dns+a://", "k8s://"}
+
+// Endpoint describes a target reported by a target source
+type Endpoint struct {
+	URL      string
+	Weight   int
+	Priority int
+	Group    string
+}
+
+// Pool holds the targets of a single service and the balancer used to pick them
+type Pool struct {
+	service  string
+	balancer Balancer
+	// slowStart is the warm-up window of new and recovered targets, 0 when disabled
+	slowStart time.Duration
+	minWeight float64
+	// minHealthy is the available share of a tier's weight below which lower tiers take traffic too
+	minHealthy float64
+	// split divides traffic between target groups, nil when the service has a single group
+	split *trafficSplit
+
+	mu      sync.RWMutex
+	sources map[string][]*Target
+	targets []*Target
+	// generation counts the updates of the targets
+	generation uint64
+}
+
+// NewPool creates a target pool for the service
+func NewPool(svc config.ServiceConfig) (*Pool, error) {
+	b, err := New(svc.LoadBalancing)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+	}
+
+	if len(svc.Weights) > 0 && len(svc.Weights) != len(svc.Targets) {
+		return nil, fmt.Errorf("service %s: %d weights configured for %d targets",
+			svc.Name, len(svc.Weights), len(svc.Targets))
+	}
+
+	if len(svc.Priorities) > 0 && len(svc.Priorities) != len(svc.Targets) {
+		return nil, fmt.Errorf("service %s: %d priorities configured for %d targets",
+			svc.Name, len(svc.Priorities), len(svc.Targets))
+	}
+	for _, priority := range svc.Priorities {
+		if priority < 0 {
+			return nil, fmt.Errorf("service %s: target priorities must not be negative", svc.Name)
+		}
+	}
+
+	if len(svc.TargetGroups) > 0 && len(svc.TargetGroups) != len(svc.Targets) {
+		return nil, fmt.Errorf("service %s: %d target groups configured for %d targets",
+			svc.Name, len(svc.TargetGroups), len(svc.Targets))
+	}
+	var split *trafficSplit
+	if len(svc.TrafficSplit) > 0 {
+		split, err = newTrafficSplit(svc.TrafficSplit)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		for i := range svc.Targets {
+			if group := TargetGroup(svc, i); !split.has(group) {
+				return nil, fmt.Errorf("service %s: target group %s has no traffic split weight", svc.Name, group)
+			}
+		}
+	}
+
+	minHealthy := svc.Failover.MinHealthyPercent
+	if minHealthy == 0 {
+		minHealthy = defaultFailoverMinHealthyPercent
+	}
+	if minHealthy < 0 || minHealthy > 100 {
+		return nil, fmt.Errorf("service %s: failover min_healthy_percent must be between 0 and 100", svc.Name)
+	}
+
+	ss := svc.SlowStart
+	if ss.Window < 0 {
+		return nil, fmt.Errorf("service %s: slow start window must not be negative", svc.Name)
+	}
+	if ss.MinWeightPercent == 0 {
+		ss.MinWeightPercent = defaultSlowStartMinWeightPercent
+	}
+	if ss.MinWeightPercent < 0 || ss.MinWeightPercent > 100 {
+		return nil, fmt.Errorf("service %s: slow start min_weight_percent must be between 0 and 100", svc.Name)
+	}
+
+	pool := &Pool{
+		service:    svc.Name,
+		balancer:   b,
+		slowStart:  time.Duration(ss.Window) * time.Second,
+		minWeight:  float64(ss.MinWeightPercent) / 100,
+		minHealthy: float64(minHealthy) / 100,
+		split:      split,
+		sources:    make(map[string][]*Target),
+	}
+
+	// Targets served by discovery providers are added once they are resolved
+	endpoints := make([]Endpoint, 0, len(svc.Targets))
+	for i, url := range svc.Targets {
+		if IsDiscoveryTarget(url) {
+			continue
+		}
+		endpoints = append(endpoints, Endpoint{
+			URL:      url,
+			Weight:   TargetWeight(svc, i),
+			Priority: TargetPriority(svc, i),
+			Group:    TargetGroup(svc, i),
+		})
+	}
+	pool.Update(StaticSource, endpoints)
+
+	return pool, nil
+}
+
+// IsDiscoveryTarget reports whether a configured target must be resolved by a discovery provider
+func IsDiscoveryTarget(target string) bool {
+	for _, scheme := range discoverySchemes {
+		if strings.HasPrefix(target, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetWeight returns the configured weight of the i-th target of a service
+func TargetWeight(svc config.ServiceConfig, i int) int {
+	if i < len(svc.Weights) {
+		return svc.Weights[i]
+	}
+	return 1
+}
+
+// TargetPriority returns the configured priority tier of the i-th target of a service
+func TargetPriority(svc config.ServiceConfig, i int) int {
+	if i < len(svc.Priorities) {
+		return svc.Priorities[i]
+	}
+	return 0
+}
+
+// TargetGroup returns the configured target group of the i-th target of a service
+func TargetGroup(svc config.ServiceConfig, i int) string {
+	if i < len(svc.TargetGroups) && svc.TargetGroups[i] != "" {
+		return svc.TargetGroups[i]
+	}
+	return DefaultGroup
+}
+
+// Update replaces the endpoints reported by a source and returns the URLs that
+// were added to and removed from the pool. Targets that remain keep their state
+// and take the weight, priority and group of their endpoint.
+func (p *Pool) Update(source string, endpoints []Endpoint) (added, removed []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*Target, len(p.targets))
+	for _, t := range p.targets {
+		existing[t.URL] = t
+	}
+
+	targets := make([]*Target, 0, len(endpoints))
+	seen := make(map[string]struct{}, len(endpoints))
+	for _, ep := range endpoints {
+		if _, dup := seen[ep.URL]; dup {
+			continue
+		}
+		seen[ep.URL] = struct{}{}
+
+		t, ok := existing[ep.URL]
+		if !ok {
+			t = NewTarget(ep.URL, ep.Weight)
+			// Targets joining a pool that already serves traffic start cold
+			if len(existing) > 0 {
+				t.StartWarmup(time.Now())
+			}
+		}
+		t.setWeight(ep.Weight)
+		t.setPriority(ep.Priority)
+		t.setGroup(ep.Group)
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		delete(p.sources, source)
+	} else {
+		p.sources[source] = targets
+	}
+
+	// Rebuild the merged target list in a stable order
+	merged := make(map[string]*Target)
+	for _, sourceTargets := range p.sources {
+		for _, t := range sourceTargets {
+			merged[t.URL] = t
+		}
+	}
+	p.targets = make([]*Target, 0, len(merged))
+	for _, t := range merged {
+		p.targets = append(p.targets, t)
+	}
+	sort.Slice(p.targets, func(i, j int) bool {
+		return p.targets[i].URL < p.targets[j].URL
+	})
+	p.generation++
+
+	for url := range merged {
+		if _, ok := existing[url]; !ok {
+			added = append(added, url)
+		}
+	}
+	for url := range existing {
+		if _, ok := merged[url]; !ok {
+			removed = append(removed, url)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
+
+// Pick selects a healthy target for the next request
+func (p *Pool) Pick() (*Target, error) {
+	return p.PickForKey("")
+}
+
+// PickForKey selects a healthy target for a request with the given hash key.
+// The key is ignored unless the pool uses a key-aware balancer.
+func (p *Pool) PickForKey(key string) (*Target, error) {
+	if len(p.Targets()) == 0 {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "no targets available for service "+p.service)
+	}
+
+	candidates := p.Available()
+	if p.split != nil {
+		candidates = p.split.filter(key, candidates)
+	}
+	if len(candidates) == 0 {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "no healthy targets available for service "+p.service)
+	}
+
+	target := p.next(key, candidates)
+	if p.slowStart <= 0 {
+		return target, nil
+	}
+
+	// A warming target only accepts its current share of the requests it is
+	// picked for, the others are balanced over the remaining candidates
+	for len(candidates) > 1 {
+		factor := target.warmup(p.slowStart, p.minWeight)
+		if factor >= 1 || admit(key, target, factor) {
+			break
+		}
+		candidates = without(candidates, target)
+		target = p.next(key, candidates)
+	}
+	return target, nil
+}
+
+// next asks the balancer for a target out of the candidates
+func (p *Pool) next(key string, candidates []*Target) *Target {
+	if keyed, ok := p.balancer.(KeyedBalancer); ok && key != "" {
+		return keyed.NextForKey(key, candidates)
+	}
+	return p.balancer.Next(candidates)
+}
+
+// admit decides whether a warming target takes a request. Keyed requests are
+// admitted by their hash, so the same keys move to the target as it warms up.
+func admit(key string, target *Target, factor float64) bool {
+	if key != "" {
+		return float64(xxhash.Sum64String(target.ID+"/"+key)) < factor*math.MaxUint64
+	}
+	return rand.Float64() < factor
+}
+
+// without returns the candidates except the given target
+func without(candidates []*Target, target *Target) []*Target {
+	rest := make([]*Target, 0, len(candidates)-1)
+	for _, t := range candidates {
+		if t != target {
+			rest = append(rest, t)
+		}
+	}
+	return rest
+}
+
+// EffectiveWeight returns the weight of the target reduced by its warm-up
+func (p *Pool) EffectiveWeight(t *Target) float64 {
+	return float64(t.Weight()) * t.warmup(p.slowStart, p.minWeight)
+}
+
+// Available returns the targets of the active priority tiers that may currently
+// receive traffic. Tiers are used in order, and the next tier only takes traffic
+// while the available weight of the tiers before it is below the failover threshold.
+func (p *Pool) Available() []*Target {
+	targets := p.Targets()
+
+	tiers := make(map[int][]*Target)
+	priorities := make([]int, 0, 1)
+	for _, t := range targets {
+		priority := t.Priority()
+		if _, ok := tiers[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		tiers[priority] = append(tiers[priority], t)
+	}
+	sort.Ints(priorities)
+
+	available := make([]*Target, 0, len(targets))
+	for _, priority := range priorities {
+		total, healthy := 0, 0
+		for _, t := range tiers[priority] {
+			weight := t.Weight()
+			total += weight
+			if t.Available() {
+				healthy += weight
+				available = append(available, t)
+			}
+		}
+		if float64(healthy) >= p.minHealthy*float64(total) {
+			break
+		}
+	}
+	return available
+}
+
+// Active reports whether the target may receive traffic and belongs to an
+// active priority tier and a target group that currently takes traffic
+func (p *Pool) Active(target *Target) bool {
+	if !target.Available() {
+		return false
+	}
+	if p.split != nil && !p.groupActive(target.Group()) {
+		return false
+	}
+	for _, t := range p.Available() {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// groupActive reports whether the traffic split sends requests to the group
+func (p *Pool) groupActive(group string) bool {
+	for _, g := range p.split.weights() {
+		if g.Group == group {
+			return g.Weight > 0
+		}
+	}
+	return false
+}
+
+// Split returns the current traffic split, or nil when the service has none
+func (p *Pool) Split() []GroupWeight {
+	if p.split == nil {
+		return nil
+	}
+	return p.split.weights()
+}
+
+// SetSplit changes the weights of the target groups at runtime
+func (p *Pool) SetSplit(groups []GroupWeight) error {
+	if p.split == nil {
+		return fmt.Errorf("service %s has no traffic split", p.service)
+	}
+	return p.split.set(groups)
+}
+
+// Lookup returns the target with the given ID
+func (p *Pool) Lookup(id string) (*Target, bool) {
+	for _, t := range p.Targets() {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Endpoints returns the endpoints currently reported by a source
+func (p *Pool) Endpoints(source string) []Endpoint {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	targets := p.sources[source]
+	endpoints := make([]Endpoint, 0, len(targets))
+	for _, t := range targets {
+		endpoints = append(endpoints, Endpoint{URL: t.URL, Weight: t.Weight(), Priority: t.Priority(), Group: t.Group()})
+	}
+	return endpoints
+}
+
+// Generation returns a number that changes whenever the targets are updated,
+// so state kept per target can be pruned when targets leave the pool
+func (p *Pool) Generation() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.generation
+}
+
+// Service returns the name of the service the pool belongs to
+func (p *Pool) Service() string {
+	return p.service
+}
+
+// Targets returns all targets of the pool
+func (p *Pool) Targets() []*Target {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.targets
+}
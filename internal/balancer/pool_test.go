@@ -0,0 +1,272 @@
+package balancer
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+)
+
+func TestPoolUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoints   []Endpoint
+		wantAdded   []string
+		wantRemoved []string
+		// want is the weight, priority and group of every target afterwards
+		want []Endpoint
+	}{
+		{
+			name:      "unchanged",
+			endpoints: []Endpoint{{URL: "http://a:8080", Weight: 1}, {URL: "http://b:8080", Weight: 1}},
+			want: []Endpoint{
+				{URL: "http://a:8080", Weight: 1, Group: DefaultGroup},
+				{URL: "http://b:8080", Weight: 1, Group: DefaultGroup},
+			},
+		},
+		{
+			name: "weight, priority and group changed",
+			endpoints: []Endpoint{
+				{URL: "http://a:8080", Weight: 3, Priority: 1, Group: "canary"},
+				{URL: "http://b:8080", Weight: 1},
+			},
+			want: []Endpoint{
+				{URL: "http://a:8080", Weight: 3, Priority: 1, Group: "canary"},
+				{URL: "http://b:8080", Weight: 1, Group: DefaultGroup},
+			},
+		},
+		{
+			name:        "target replaced",
+			endpoints:   []Endpoint{{URL: "http://a:8080", Weight: 0}, {URL: "http://c:8080", Weight: 2}, {URL: "http://c:8080", Weight: 5}},
+			wantAdded:   []string{"http://c:8080"},
+			wantRemoved: []string{"http://b:8080"},
+			want: []Endpoint{
+				{URL: "http://a:8080", Weight: 1, Group: DefaultGroup},
+				{URL: "http://c:8080", Weight: 2, Group: DefaultGroup},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewPool(config.ServiceConfig{Name: "api", Targets: []string{"http://a:8080", "http://b:8080"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := pool.Targets()[0]
+			a.SetHealthy(false)
+
+			added, removed := pool.Update(StaticSource, tt.endpoints)
+			if !reflect.DeepEqual(added, tt.wantAdded) || !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("added, removed = %v, %v, want %v, %v", added, removed, tt.wantAdded, tt.wantRemoved)
+			}
+			if got := pool.Endpoints(StaticSource); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpoints = %+v, want %+v", got, tt.want)
+			}
+
+			// The kept target is changed in place and keeps its health state
+			if pool.Targets()[0] != a {
+				t.Fatal("target was replaced")
+			}
+			if a.Healthy() {
+				t.Error("target lost its health state")
+			}
+		})
+	}
+}
+
+func TestTargetWarmup(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  time.Duration
+		elapsed time.Duration
+		// started is false for targets that never warmed up
+		started bool
+		want    float64
+	}{
+		{name: "never started", window: time.Minute, want: 1},
+		{name: "slow start disabled", elapsed: time.Second, started: true, want: 1},
+		{name: "just started", window: time.Minute, started: true, want: 0.1},
+		{name: "not started yet", window: time.Minute, elapsed: -time.Minute, started: true, want: 0.1},
+		{name: "half way", window: time.Minute, elapsed: 30 * time.Second, started: true, want: 0.55},
+		{name: "window passed", window: time.Minute, elapsed: 2 * time.Minute, started: true, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := NewTarget("http://a:8080", 1)
+			if tt.started {
+				target.StartWarmup(time.Now().Add(-tt.elapsed))
+			}
+			if got := target.warmup(tt.window, 0.1); math.Abs(got-tt.want) > 0.01 {
+				t.Errorf("warmup = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolSlowStart(t *testing.T) {
+	tests := []struct {
+		name string
+		// change makes target b of a serving pool warm up, or not
+		change func(pool *Pool, b *Target)
+		// want is the expected share of picks of target b, half of the random
+		// picks times the 10% a target admits when it starts warming up
+		want float64
+	}{
+		{
+			name:   "initial targets",
+			change: func(pool *Pool, b *Target) {},
+			want:   0.5,
+		},
+		{
+			name: "target added",
+			change: func(pool *Pool, b *Target) {
+				pool.Update(StaticSource, []Endpoint{{URL: "http://a:8080", Weight: 1}})
+				pool.Update(StaticSource, []Endpoint{{URL: "http://a:8080", Weight: 1}, {URL: "http://b:8080", Weight: 1}})
+			},
+			want: 0.05,
+		},
+		{
+			name: "target recovered",
+			change: func(pool *Pool, b *Target) {
+				b.SetHealthy(false)
+				b.SetHealthy(true)
+			},
+			want: 0.05,
+		},
+		{
+			name: "ejection ended",
+			change: func(pool *Pool, b *Target) {
+				b.Eject(time.Now())
+			},
+			want: 0.05,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := NewPool(config.ServiceConfig{
+				Name:          "api",
+				Targets:       []string{"http://a:8080", "http://b:8080"},
+				LoadBalancing: StrategyRandom,
+				SlowStart:     config.SlowStartConfig{Window: 3600},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(pool, pool.Targets()[1])
+			b := pool.Targets()[1]
+
+			picks := 0
+			for i := 0; i < 10000; i++ {
+				target, err := pool.Pick()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if target == b {
+					picks++
+				}
+			}
+			if got := float64(picks) / 10000; math.Abs(got-tt.want) > 0.02 {
+				t.Errorf("share of b = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		weights    []int
+		minHealthy int
+		// down lists the targets that fail their health checks
+		down []string
+		want []string
+	}{
+		{
+			name:       "primary tier healthy",
+			priorities: []int{0, 0, 1},
+			want:       []string{"http://a:8080", "http://b:8080"},
+		},
+		{
+			name:       "primary tier above threshold",
+			priorities: []int{0, 0, 0, 0, 1},
+			down:       []string{"http://a:8080"},
+			want:       []string{"http://b:8080", "http://c:8080", "http://d:8080"},
+		},
+		{
+			name:       "primary tier below threshold",
+			priorities: []int{0, 0, 1},
+			down:       []string{"http://a:8080"},
+			want:       []string{"http://b:8080", "http://c:8080"},
+		},
+		{
+			name:       "threshold weighs targets",
+			priorities: []int{0, 0, 1},
+			weights:    []int{1, 9, 1},
+			down:       []string{"http://a:8080"},
+			want:       []string{"http://b:8080"},
+		},
+		{
+			name:       "spills over several tiers",
+			priorities: []int{0, 1, 2, 3},
+			down:       []string{"http://a:8080", "http://b:8080"},
+			want:       []string{"http://c:8080"},
+		},
+		{
+			name:       "tiers in priority order",
+			priorities: []int{2, 5, 0},
+			down:       []string{"http://c:8080"},
+			want:       []string{"http://a:8080"},
+		},
+		{
+			name:       "custom threshold",
+			priorities: []int{0, 0, 1},
+			minHealthy: 40,
+			down:       []string{"http://a:8080"},
+			want:       []string{"http://b:8080"},
+		},
+		{
+			name:       "all tiers down",
+			priorities: []int{0, 1},
+			down:       []string{"http://a:8080", "http://b:8080"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := config.ServiceConfig{
+				Name:       "api",
+				Priorities: tt.priorities,
+				Weights:    tt.weights,
+				Failover:   config.FailoverConfig{MinHealthyPercent: tt.minHealthy},
+			}
+			for i := range tt.priorities {
+				svc.Targets = append(svc.Targets, "http://"+string(rune('a'+i))+":8080")
+			}
+			pool, err := NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, url := range tt.down {
+				for _, target := range pool.Targets() {
+					if target.URL == url {
+						target.SetHealthy(false)
+					}
+				}
+			}
+
+			var got []string
+			for _, target := range pool.Available() {
+				got = append(got, target.URL)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("available = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package balancer
+
+import (
+	"math/rand/v2"
+)
+
+// Random picks a target at random, proportionally to its weight
+type Random struct{}
+
+// NewRandom creates a new random balancer
+func NewRandom() *Random {
+	return &Random{}
+}
+
+// Next returns a weighted random target
+func (b *Random) Next(targets []*Target) *Target {
+	total := 0
+	for _, t := range targets {
+		total += t.Weight()
+	}
+
+	n := rand.IntN(total)
+	for _, t := range targets {
+		n -= t.Weight()
+		if n < 0 {
+			return t
+		}
+	}
+	return targets[len(targets)-1]
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// replicasPerWeight is the number of points each unit of weight gets on the ring
+const replicasPerWeight = 160
+
+// maxCachedRings bounds the rings kept for different candidate sets, such as
+// the sets without a warming target that declined a request
+const maxCachedRings = 8
+
+// KeyedBalancer selects a target based on a request key
+type KeyedBalancer interface {
+	Balancer
+	// NextForKey returns the target that owns the key.
+	// The candidate list is never empty.
+	NextForKey(key string, targets []*Target) *Target
+}
+
+// ringPoint is a single virtual node on the hash ring
+type ringPoint struct {
+	hash   uint64
+	target *Target
+}
+
+// RingHash maps request keys onto a consistent hash ring, so that adding or
+// removing a target only moves the keys owned by that target
+type RingHash struct {
+	mu       sync.Mutex
+	rings    map[string][]ringPoint
+	fallback *RoundRobin
+}
+
+// NewRingHash creates a new ring hash balancer
+func NewRingHash() *RingHash {
+	return &RingHash{
+		rings:    make(map[string][]ringPoint),
+		fallback: NewRoundRobin(),
+	}
+}
+
+// Next is used for requests without a hash key
+func (b *RingHash) Next(targets []*Target) *Target {
+	return b.fallback.Next(targets)
+}
+
+// NextForKey returns the first target clockwise from the hash of the key
+func (b *RingHash) NextForKey(key string, targets []*Target) *Target {
+	ring := b.ringFor(targets)
+	hash := xxhash.Sum64String(key)
+
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= hash
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].target
+}
+
+// ringFor returns the ring for the candidate set, building it on first use
+func (b *RingHash) ringFor(targets []*Target) []ringPoint {
+	ids := make([]string, 0, len(targets))
+	for _, t := range targets {
+		ids = append(ids, t.ID+":"+strconv.Itoa(t.Weight()))
+	}
+	key := strings.Join(ids, ",")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ring, ok := b.rings[key]; ok {
+		return ring
+	}
+
+	ring := make([]ringPoint, 0, len(targets)*replicasPerWeight)
+	for _, t := range targets {
+		// Points are derived from the target ID so every gateway instance builds the same ring
+		for i := 0; i < t.Weight()*replicasPerWeight; i++ {
+			ring = append(ring, ringPoint{
+				hash:   xxhash.Sum64String(t.ID + "-" + strconv.Itoa(i)),
+				target: t,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
+	// Sets of targets that left the pool are dropped together with the rest
+	if len(b.rings) >= maxCachedRings {
+		clear(b.rings)
+	}
+	b.rings[key] = ring
+	return ring
+}
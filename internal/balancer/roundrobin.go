@@ -0,0 +1,68 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// RoundRobin cycles through targets in order
+type RoundRobin struct {
+	counter atomic.Uint64
+}
+
+// NewRoundRobin creates a new round-robin balancer
+func NewRoundRobin() *RoundRobin {
+	return &RoundRobin{}
+}
+
+// Next returns the next target in rotation
+func (b *RoundRobin) Next(targets []*Target) *Target {
+	n := b.counter.Add(1) - 1
+	return targets[n%uint64(len(targets))]
+}
+
+// WeightedRoundRobin implements smooth weighted round-robin, which spreads
+// picks of heavier targets evenly instead of sending them in bursts
+type WeightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+// NewWeightedRoundRobin creates a new weighted round-robin balancer
+func NewWeightedRoundRobin() *WeightedRoundRobin {
+	return &WeightedRoundRobin{
+		current: make(map[*Target]int),
+	}
+}
+
+// Next returns the target with the highest current weight
+func (b *WeightedRoundRobin) Next(targets []*Target) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Target
+	total := 0
+	for _, t := range targets {
+		b.current[t] += t.Weight()
+		total += t.Weight()
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+
+	// Forget targets that are no longer candidates
+	if len(b.current) > len(targets) {
+		live := make(map[*Target]struct{}, len(targets))
+		for _, t := range targets {
+			live[t] = struct{}{}
+		}
+		for t := range b.current {
+			if _, ok := live[t]; !ok {
+				delete(b.current, t)
+			}
+		}
+	}
+
+	return best
+}
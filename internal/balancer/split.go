@@ -0,0 +1,177 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+
+	"api-gateway/internal/config"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// DefaultGroup is the target group of targets without a configured group
+const DefaultGroup = "default"
+
+// GroupWeight is the share of traffic sent to a target group
+type GroupWeight struct {
+	Group  string `json:"group"`
+	Weight int    `json:"weight"`
+}
+
+// trafficSplit divides the requests of a service between target groups
+type trafficSplit struct {
+	mu     sync.RWMutex
+	groups []GroupWeight
+}
+
+// newTrafficSplit creates the split of a service from its configuration
+func newTrafficSplit(cfg []config.TrafficSplitConfig) (*trafficSplit, error) {
+	groups := make([]GroupWeight, 0, len(cfg))
+	for _, split := range cfg {
+		groups = append(groups, GroupWeight{Group: split.Group, Weight: split.Weight})
+	}
+
+	s := &trafficSplit{}
+	if err := s.set(groups); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// set replaces the group weights after validating them
+func (s *trafficSplit) set(groups []GroupWeight) error {
+	seen := make(map[string]struct{}, len(groups))
+	total := 0
+	for _, g := range groups {
+		if g.Group == "" {
+			return fmt.Errorf("traffic split group has no name")
+		}
+		if _, ok := seen[g.Group]; ok {
+			return fmt.Errorf("traffic split group %s is listed twice", g.Group)
+		}
+		seen[g.Group] = struct{}{}
+		if g.Weight < 0 {
+			return fmt.Errorf("traffic split group %s has a negative weight", g.Group)
+		}
+		total += g.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("traffic split weights must not all be zero")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Runtime changes may only adjust the weights of the configured groups
+	if s.groups != nil {
+		if len(groups) != len(s.groups) {
+			return fmt.Errorf("traffic split must list the groups %v", s.names())
+		}
+		for _, g := range s.groups {
+			if _, ok := seen[g.Group]; !ok {
+				return fmt.Errorf("traffic split must list the groups %v", s.names())
+			}
+		}
+		// Keep the configured order so sticky users keep their bucket
+		weights := make(map[string]int, len(groups))
+		for _, g := range groups {
+			weights[g.Group] = g.Weight
+		}
+		ordered := make([]GroupWeight, len(s.groups))
+		for i, g := range s.groups {
+			ordered[i] = GroupWeight{Group: g.Group, Weight: weights[g.Group]}
+		}
+		groups = ordered
+	}
+
+	s.groups = groups
+	return nil
+}
+
+// names returns the group names in configured order, the caller holds the lock
+func (s *trafficSplit) names() []string {
+	names := make([]string, 0, len(s.groups))
+	for _, g := range s.groups {
+		names = append(names, g.Group)
+	}
+	return names
+}
+
+// weights returns a copy of the current group weights
+func (s *trafficSplit) weights() []GroupWeight {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]GroupWeight(nil), s.groups...)
+}
+
+// has reports whether the group is part of the split
+func (s *trafficSplit) has(group string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, g := range s.groups {
+		if g.Group == group {
+			return true
+		}
+	}
+	return false
+}
+
+// pick selects the group of a request. Requests with a key always land in the
+// same bucket, so a user stays in its group while the weights stay the same,
+// and moves only when its bucket changes hands.
+func (s *trafficSplit) pick(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := 0
+	for _, g := range s.groups {
+		total += g.Weight
+	}
+
+	var n int
+	if key != "" {
+		// Salted so the bucket does not correlate with the ring hash position
+		n = int(xxhash.Sum64String("split/"+key) % uint64(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for _, g := range s.groups {
+		n -= g.Weight
+		if n < 0 {
+			return g.Group
+		}
+	}
+	return s.groups[len(s.groups)-1].Group
+}
+
+// filter narrows the candidates to the picked group. When that group has no
+// candidates, the candidates of all groups that currently take traffic are used.
+func (s *trafficSplit) filter(key string, candidates []*Target) []*Target {
+	group := s.pick(key)
+
+	filtered := make([]*Target, 0, len(candidates))
+	for _, t := range candidates {
+		if t.Group() == group {
+			filtered = append(filtered, t)
+		}
+	}
+	if len(filtered) > 0 {
+		return filtered
+	}
+
+	active := make(map[string]struct{})
+	for _, g := range s.weights() {
+		if g.Weight > 0 {
+			active[g.Group] = struct{}{}
+		}
+	}
+	for _, t := range candidates {
+		if _, ok := active[t.Group()]; ok {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
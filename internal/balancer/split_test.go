@@ -0,0 +1,211 @@
+package balancer
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+// splitConfig returns the configuration of a split with the weights of the groups
+func splitConfig(groups ...GroupWeight) []config.TrafficSplitConfig {
+	cfg := make([]config.TrafficSplitConfig, 0, len(groups))
+	for _, g := range groups {
+		cfg = append(cfg, config.TrafficSplitConfig{Group: g.Group, Weight: g.Weight})
+	}
+	return cfg
+}
+
+func TestTrafficSplitStickiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []GroupWeight
+		after  []GroupWeight
+		// moved is the group users may only leave, everyone else stays put
+		moved string
+	}{
+		{
+			name:   "canary weight raised",
+			before: []GroupWeight{{Group: "stable", Weight: 90}, {Group: "canary", Weight: 10}},
+			after:  []GroupWeight{{Group: "stable", Weight: 75}, {Group: "canary", Weight: 25}},
+			moved:  "stable",
+		},
+		{
+			name:   "canary weight lowered",
+			before: []GroupWeight{{Group: "stable", Weight: 50}, {Group: "canary", Weight: 50}},
+			after:  []GroupWeight{{Group: "stable", Weight: 95}, {Group: "canary", Weight: 5}},
+			moved:  "canary",
+		},
+		{
+			name:   "weights listed in another order",
+			before: []GroupWeight{{Group: "stable", Weight: 90}, {Group: "canary", Weight: 10}},
+			after:  []GroupWeight{{Group: "canary", Weight: 25}, {Group: "stable", Weight: 75}},
+			moved:  "stable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newTrafficSplit(splitConfig(tt.before...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			groups := make(map[string]string)
+			for i := 0; i < 1000; i++ {
+				key := "user-" + strconv.Itoa(i)
+				groups[key] = s.pick(key)
+				if again := s.pick(key); again != groups[key] {
+					t.Fatalf("user %s moved from %s to %s", key, groups[key], again)
+				}
+			}
+
+			if err := s.set(tt.after); err != nil {
+				t.Fatal(err)
+			}
+			moved := 0
+			for key, group := range groups {
+				got := s.pick(key)
+				if got == group {
+					continue
+				}
+				moved++
+				if group != tt.moved {
+					t.Errorf("user %s moved from %s to %s", key, group, got)
+				}
+			}
+			if moved == 0 {
+				t.Error("no user moved")
+			}
+		})
+	}
+}
+
+func TestTrafficSplitShares(t *testing.T) {
+	s, err := newTrafficSplit(splitConfig(GroupWeight{Group: "stable", Weight: 80}, GroupWeight{Group: "canary", Weight: 20}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests with and without a key follow the weights
+	for _, keyed := range []bool{false, true} {
+		canary := 0
+		for i := 0; i < 10000; i++ {
+			key := ""
+			if keyed {
+				key = "user-" + strconv.Itoa(i)
+			}
+			if s.pick(key) == "canary" {
+				canary++
+			}
+		}
+		if got := float64(canary) / 10000; math.Abs(got-0.2) > 0.02 {
+			t.Errorf("keyed %v: canary share = %v, want 0.2", keyed, got)
+		}
+	}
+}
+
+func TestTrafficSplitSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []GroupWeight
+		want    []GroupWeight
+		wantErr bool
+	}{
+		{
+			name:   "weights changed",
+			groups: []GroupWeight{{Group: "stable", Weight: 0}, {Group: "canary", Weight: 100}},
+			want:   []GroupWeight{{Group: "stable", Weight: 0}, {Group: "canary", Weight: 100}},
+		},
+		{
+			name:   "configured order kept",
+			groups: []GroupWeight{{Group: "canary", Weight: 30}, {Group: "stable", Weight: 70}},
+			want:   []GroupWeight{{Group: "stable", Weight: 70}, {Group: "canary", Weight: 30}},
+		},
+		{name: "group missing", groups: []GroupWeight{{Group: "stable", Weight: 100}}, wantErr: true},
+		{name: "unknown group", groups: []GroupWeight{{Group: "stable", Weight: 50}, {Group: "beta", Weight: 50}}, wantErr: true},
+		{name: "group listed twice", groups: []GroupWeight{{Group: "stable", Weight: 50}, {Group: "stable", Weight: 50}}, wantErr: true},
+		{name: "negative weight", groups: []GroupWeight{{Group: "stable", Weight: 110}, {Group: "canary", Weight: -10}}, wantErr: true},
+		{name: "all weights zero", groups: []GroupWeight{{Group: "stable"}, {Group: "canary"}}, wantErr: true},
+		{name: "unnamed group", groups: []GroupWeight{{Group: "stable", Weight: 50}, {Weight: 50}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initial := []GroupWeight{{Group: "stable", Weight: 90}, {Group: "canary", Weight: 10}}
+			s, err := newTrafficSplit(splitConfig(initial...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.set(tt.groups)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			// A rejected change leaves the weights untouched
+			want := tt.want
+			if tt.wantErr {
+				want = initial
+			}
+			if got := s.weights(); !reflect.DeepEqual(got, want) {
+				t.Errorf("weights = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestTrafficSplitFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups []GroupWeight
+		// candidates are the groups of the available targets
+		candidates []string
+		want       []string
+	}{
+		{
+			name:       "picked group",
+			groups:     []GroupWeight{{Group: "stable", Weight: 100}, {Group: "canary", Weight: 0}},
+			candidates: []string{"stable", "canary"},
+			want:       []string{"stable"},
+		},
+		{
+			name:       "picked group without targets",
+			groups:     []GroupWeight{{Group: "stable", Weight: 0}, {Group: "canary", Weight: 100}, {Group: "beta", Weight: 0}},
+			candidates: []string{"stable", "beta"},
+		},
+		{
+			name:       "falls back to groups taking traffic",
+			groups:     []GroupWeight{{Group: "stable", Weight: 0}, {Group: "canary", Weight: 50}, {Group: "beta", Weight: 50}},
+			candidates: []string{"stable", "beta"},
+			want:       []string{"beta"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newTrafficSplit(splitConfig(tt.groups...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			candidates := make([]*Target, 0, len(tt.candidates))
+			for i, group := range tt.candidates {
+				target := NewTarget("http://"+strconv.Itoa(i)+":8080", 1)
+				target.setGroup(group)
+				candidates = append(candidates, target)
+			}
+
+			// Every request of the test is handled alike, whichever bucket it lands in
+			for i := 0; i < 20; i++ {
+				var got []string
+				for _, target := range s.filter("user-"+strconv.Itoa(i), candidates) {
+					got = append(got, target.Group())
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("groups = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -9,6 +9,10 @@ type Config struct {
 	Logging    LoggingConfig    `mapstructure:"logging"`
 	Metrics    MetricsConfig    `mapstructure:"metrics"`
 	Tracing    TracingConfig    `mapstructure:"tracing"`
+	Discovery  DiscoveryConfig  `mapstructure:"discovery"`
+	Admin      AdminConfig      `mapstructure:"admin"`
+	// Middleware is the default pipeline of every service route
+	Middleware []MiddlewareConfig `mapstructure:"middleware"`
 	Services   []ServiceConfig  `mapstructure:"services"`
 }
 
@@ -19,6 +23,10 @@ type ServerConfig struct {
 	WriteTimeout    int    `mapstructure:"write_timeout"`
 	ShutdownTimeout int    `mapstructure:"shutdown_timeout"`
 	TrustedProxies  []string `mapstructure:"trusted_proxies"`
+	// DefaultHost routes requests whose host matches no service hosts
+	DefaultHost     string   `mapstructure:"default_host"`
+	// GRPCPort is the port of the gRPC listener, which is disabled when 0
+	GRPCPort        int      `mapstructure:"grpc_port"`
 }
 
 // ProxyConfig contains proxy-related configuration
@@ -73,21 +81,290 @@ type TracingConfig struct {
 	JaegerEndpoint string `mapstructure:"jaeger_endpoint"`
 }
 
+// MiddlewareConfig is a named middleware of a route pipeline. Settings that
+// are not set fall back to the security configuration.
+type MiddlewareConfig struct {
+	// Name is one of jwt, api_key, rate_limit, cors, headers, security_headers or cache
+	Name string `mapstructure:"name"`
+	// Disable removes a default middleware from a service pipeline
+	Disable bool `mapstructure:"disable"`
+
+	// jwt
+	Secret string `mapstructure:"secret" secret:"true"`
+	// api_key
+	Keys []string `mapstructure:"keys" secret:"true"`
+	// rate_limit, requests per client IP and window in seconds
+	Max    int `mapstructure:"max"`
+	Window int `mapstructure:"window"`
+	// cors
+	AllowOrigins     []string `mapstructure:"allow_origins"`
+	AllowMethods     []string `mapstructure:"allow_methods"`
+	AllowHeaders     []string `mapstructure:"allow_headers"`
+	ExposeHeaders    []string `mapstructure:"expose_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	MaxAge           int      `mapstructure:"max_age"`
+	// headers
+	RequestHeaders        map[string]string `mapstructure:"request_headers"`
+	ResponseHeaders       map[string]string `mapstructure:"response_headers"`
+	RemoveRequestHeaders  []string          `mapstructure:"remove_request_headers"`
+	RemoveResponseHeaders []string          `mapstructure:"remove_response_headers"`
+	// cache, TTL in seconds
+	TTL int `mapstructure:"ttl"`
+}
+
+// AdminConfig contains configuration of the runtime admin API
+type AdminConfig struct {
+	Enable bool   `mapstructure:"enable"`
+	Token  string `mapstructure:"token"`
+}
+
+// DiscoveryConfig contains service discovery configuration
+type DiscoveryConfig struct {
+	DNS        DNSDiscoveryConfig        `mapstructure:"dns"`
+	File       FileDiscoveryConfig       `mapstructure:"file"`
+	Kubernetes KubernetesDiscoveryConfig `mapstructure:"kubernetes"`
+}
+
+// DNSDiscoveryConfig contains configuration for dns+srv:// and dns+a:// targets
+type DNSDiscoveryConfig struct {
+	MinRefresh int `mapstructure:"min_refresh"`
+	MaxRefresh int `mapstructure:"max_refresh"`
+	Timeout    int `mapstructure:"timeout"`
+}
+
+// FileDiscoveryConfig contains configuration for target lists read from a watched file
+type FileDiscoveryConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+// KubernetesDiscoveryConfig contains configuration for k8s:// EndpointSlice targets
+type KubernetesDiscoveryConfig struct {
+	// Kubeconfig is only needed when running outside the cluster
+	Kubeconfig string `mapstructure:"kubeconfig"`
+	Resync     int    `mapstructure:"resync"`
+	Timeout    int    `mapstructure:"timeout"`
+}
+
 // ServiceConfig contains service-related configuration
 type ServiceConfig struct {
 	Name           string            `mapstructure:"name"`
+	Hosts          []string          `mapstructure:"hosts"`
 	BasePath       string            `mapstructure:"base_path"`
+	Match          RouteMatchConfig  `mapstructure:"match"`
+	// GRPC makes the service a gRPC route instead of an HTTP one
+	GRPC           GRPCRouteConfig   `mapstructure:"grpc"`
+	// Priority overrides the route precedence, higher values are matched first
+	Priority       int               `mapstructure:"priority"`
 	Targets        []string          `mapstructure:"targets"`
+	Weights        []int             `mapstructure:"weights"`
+	Priorities     []int             `mapstructure:"priorities"`
+	TargetGroups   []string          `mapstructure:"target_groups"`
+	TrafficSplit   []TrafficSplitConfig `mapstructure:"traffic_split"`
+	Canary         CanaryConfig      `mapstructure:"canary"`
+	Mirror         MirrorConfig      `mapstructure:"mirror"`
+	Failover       FailoverConfig    `mapstructure:"failover"`
+	LoadBalancing  string            `mapstructure:"load_balancing"`
+	HashKey        HashKeyConfig     `mapstructure:"hash_key"`
 	StripBasePath  bool              `mapstructure:"strip_base_path"`
+	Rewrite        RewriteConfig     `mapstructure:"rewrite"`
+	// Response and Redirect answer requests without an upstream
+	Response       DirectResponseConfig `mapstructure:"response"`
+	Redirect       RedirectConfig    `mapstructure:"redirect"`
+	Maintenance    MaintenanceConfig `mapstructure:"maintenance"`
+	// Middleware overrides entries of the default pipeline by name and appends new ones
+	Middleware     []MiddlewareConfig `mapstructure:"middleware"`
 	EnableWebSocket bool             `mapstructure:"enable_websocket"`
+	WebSocket      WebSocketConfig   `mapstructure:"websocket"`
+	TLS            UpstreamTLSConfig `mapstructure:"tls"`
 	EnableStickySession bool         `mapstructure:"enable_sticky_session"`
+	StickySession  StickySessionConfig `mapstructure:"sticky_session"`
 	Headers        map[string]string `mapstructure:"headers"`
 	HealthCheck    HealthCheckConfig `mapstructure:"health_check"`
+	OutlierDetection OutlierDetectionConfig `mapstructure:"outlier_detection"`
+	SlowStart      SlowStartConfig   `mapstructure:"slow_start"`
+}
+
+// RouteMatchConfig contains request predicates a route must match besides its base path
+type RouteMatchConfig struct {
+	Methods []string          `mapstructure:"methods"`
+	Headers []MatchRuleConfig `mapstructure:"headers"`
+	Query   []MatchRuleConfig `mapstructure:"query"`
+	Cookies []MatchRuleConfig `mapstructure:"cookies"`
+}
+
+// MatchRuleConfig matches a named header, query parameter or cookie.
+// Without value or regex the name only has to be present.
+type MatchRuleConfig struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+	Regex string `mapstructure:"regex"`
+}
+
+// GRPCRouteConfig routes gRPC calls to a service by their service and method
+type GRPCRouteConfig struct {
+	// Services are fully qualified gRPC service names, such as wallet.v1.Wallet
+	Services []string `mapstructure:"services"`
+	// Methods are single methods as Service/Method, taking precedence over Services
+	Methods []string `mapstructure:"methods"`
+}
+
+// WebSocketConfig describes how WebSocket connections are proxied to the upstream
+type WebSocketConfig struct {
+	// Protocol is raw, socket.io or graphql-ws
+	Protocol string `mapstructure:"protocol"`
+	// Path is a template for the upstream path with ${path} as the rewritten
+	// path, ${host}, ${service} and the captures of the rewrite regex as variables
+	Path string `mapstructure:"path"`
+}
+
+// UpstreamTLSConfig contains TLS settings for connections to the targets of a service.
+// Certificate files are reloaded when they change.
+type UpstreamTLSConfig struct {
+	// CAFile is a PEM bundle that replaces the system roots for verifying targets
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the client certificate presented for mutual TLS
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ServerName overrides the SNI and the name the target certificate is verified against
+	ServerName string `mapstructure:"server_name"`
+	// MinVersion is 1.0, 1.1, 1.2 or 1.3
+	MinVersion   string   `mapstructure:"min_version"`
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	// InsecureSkipVerify disables verification of target certificates
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+// RewriteConfig describes how the request path and host are rewritten for the upstream
+type RewriteConfig struct {
+	// Prefix replaces the base path of the request
+	Prefix string `mapstructure:"prefix"`
+	// Regex is matched against the full request path and replaced by
+	// Replacement, which may refer to captures as $1 or ${name}
+	Regex       string `mapstructure:"regex"`
+	Replacement string `mapstructure:"replacement"`
+	// Host is a template for the upstream Host header with ${host}, ${service}
+	// and the captures of Regex as variables
+	Host string `mapstructure:"host"`
+}
+
+// DirectResponseConfig is a fixed response served instead of proxying
+type DirectResponseConfig struct {
+	// Status enables the direct response
+	Status      int               `mapstructure:"status"`
+	Body        string            `mapstructure:"body"`
+	ContentType string            `mapstructure:"content_type"`
+	Headers     map[string]string `mapstructure:"headers"`
+}
+
+// RedirectConfig redirects requests instead of proxying them
+type RedirectConfig struct {
+	// URL is a template for the Location header with ${path} (the rewritten
+	// path), ${host}, ${service} and the captures of the rewrite regex
+	URL        string `mapstructure:"url"`
+	Status     int    `mapstructure:"status"`
+	StripQuery bool   `mapstructure:"strip_query"`
+}
+
+// MaintenanceConfig takes a service offline with a 503 response
+type MaintenanceConfig struct {
+	Enable bool `mapstructure:"enable"`
+	// Windows are scheduled maintenance periods
+	Windows []MaintenanceWindowConfig `mapstructure:"windows"`
+	// RetryAfter in seconds is sent outside of windows, which use their end
+	RetryAfter  int    `mapstructure:"retry_after"`
+	Body        string `mapstructure:"body"`
+	ContentType string `mapstructure:"content_type"`
+}
+
+// MaintenanceWindowConfig is a maintenance period with RFC 3339 start and end times
+type MaintenanceWindowConfig struct {
+	Start string `mapstructure:"start"`
+	End   string `mapstructure:"end"`
+}
+
+// TrafficSplitConfig is the share of a service's traffic sent to one target group
+type TrafficSplitConfig struct {
+	Group  string `mapstructure:"group"`
+	Weight int    `mapstructure:"weight"`
+}
+
+// CanaryConfig contains the progressive delivery of a canary target group
+type CanaryConfig struct {
+	Enable      bool   `mapstructure:"enable"`
+	StableGroup string `mapstructure:"stable_group"`
+	CanaryGroup string `mapstructure:"canary_group"`
+	// Steps are the canary weights in percent, each held for one interval
+	// before the canary is promoted to all traffic
+	Steps []int `mapstructure:"steps"`
+	// Interval is the analysis window in seconds
+	Interval int `mapstructure:"interval"`
+	// MinRequests is the number of requests per group needed to judge a window
+	MinRequests int `mapstructure:"min_requests"`
+	// MaxErrorRateDelta is how many percentage points the canary error rate
+	// may exceed the stable error rate
+	MaxErrorRateDelta float64 `mapstructure:"max_error_rate_delta"`
+	// MaxLatencyRatio is the allowed ratio of canary to stable p99 latency
+	MaxLatencyRatio float64 `mapstructure:"max_latency_ratio"`
+}
+
+// MirrorConfig sends copies of a share of the requests to a shadow service
+type MirrorConfig struct {
+	// Target is the URL of the shadow service, mirroring is off without it
+	Target string `mapstructure:"target"`
+	// Percent is the share of requests that are mirrored
+	Percent float64 `mapstructure:"percent"`
+	// Timeout in seconds for shadow requests, defaults to the proxy timeout
+	Timeout int `mapstructure:"timeout"`
+	// MaxInflight bounds concurrent shadow requests, requests beyond it are not mirrored
+	MaxInflight int `mapstructure:"max_inflight"`
+}
+
+// FailoverConfig controls when traffic spills from one priority tier to the next
+type FailoverConfig struct {
+	// MinHealthyPercent is the share of a tier's weight that must be available
+	// for the tier to take all traffic on its own
+	MinHealthyPercent int `mapstructure:"min_healthy_percent"`
 }
 
 // HealthCheckConfig contains health check configuration
 type HealthCheckConfig struct {
-	Path     string `mapstructure:"path"`
-	Interval int    `mapstructure:"interval"`
-	Timeout  int    `mapstructure:"timeout"`
+	Path               string `mapstructure:"path"`
+	Interval           int    `mapstructure:"interval"`
+	Timeout            int    `mapstructure:"timeout"`
+	HealthyThreshold   int    `mapstructure:"healthy_threshold"`
+	UnhealthyThreshold int    `mapstructure:"unhealthy_threshold"`
+}
+
+// HashKeyConfig describes which request attribute consistent hashing is keyed on
+type HashKeyConfig struct {
+	// Source is one of header, query, cookie, claim or ip
+	Source string `mapstructure:"source"`
+	Name   string `mapstructure:"name"`
+}
+
+// StickySessionConfig contains session affinity configuration
+type StickySessionConfig struct {
+	CookieName string `mapstructure:"cookie_name"`
+	Header     string `mapstructure:"header"`
+	QueryParam string `mapstructure:"query_param"`
+	TTL        int    `mapstructure:"ttl"`
+	Secret     string `mapstructure:"secret" secret:"true"`
+}
+
+// OutlierDetectionConfig contains passive outlier detection configuration
+type OutlierDetectionConfig struct {
+	Enable                   bool `mapstructure:"enable"`
+	Consecutive5xx           int  `mapstructure:"consecutive_5xx"`
+	ConsecutiveGatewayErrors int  `mapstructure:"consecutive_gateway_errors"`
+	BaseEjectionTime         int  `mapstructure:"base_ejection_time"`
+	MaxEjectionTime          int  `mapstructure:"max_ejection_time"`
+	MaxEjectionPercent       int  `mapstructure:"max_ejection_percent"`
+}
+
+// SlowStartConfig contains the warm-up ramp for new and recovered targets
+type SlowStartConfig struct {
+	// Window is the ramp duration in seconds, 0 disables slow start
+	Window int `mapstructure:"window"`
+	// MinWeightPercent is the share of the full weight a target starts with
+	MinWeightPercent int `mapstructure:"min_weight_percent"`
 }
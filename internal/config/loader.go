@@ -77,4 +77,14 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("tracing.enable", true)
 	v.SetDefault("tracing.jaeger_endpoint", "jaeger.tracing.svc.cluster.local:4318")
 	v.SetDefault("tracing.service_name", "api-gateway")
+
+	// Discovery defaults
+	v.SetDefault("discovery.dns.min_refresh", 5)
+	v.SetDefault("discovery.dns.max_refresh", 300)
+	v.SetDefault("discovery.dns.timeout", 5)
+	v.SetDefault("discovery.kubernetes.resync", 300)
+	v.SetDefault("discovery.kubernetes.timeout", 10)
+
+	// Admin defaults
+	v.SetDefault("admin.enable", false)
 }
@@ -0,0 +1,296 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Watcher keeps the endpoints of one discovery source up to date
+type Watcher interface {
+	// Run refreshes the endpoints until stop is closed
+	Run(stop <-chan struct{})
+}
+
+// syncWatcher is a watcher whose first sync, such as a DNS lookup or the
+// initial list of EndpointSlices, may take a while
+type syncWatcher interface {
+	Watcher
+	// Sync fetches the endpoints once, before Run is called
+	Sync()
+}
+
+// pendingWatcher is a watcher that was created but not started yet
+type pendingWatcher struct {
+	watcher syncWatcher
+	stop    chan struct{}
+}
+
+// Manager starts discovery watchers for the targets of every service
+type Manager struct {
+	config *config.Config
+	logger *logging.Logger
+	stop   chan struct{}
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	pools map[string]*balancer.Pool
+	// watchers holds the stop channel of the watchers of each service
+	watchers map[string]serviceWatchers
+	// files holds the last endpoints read from the discovery file per service,
+	// so pools created later still receive them
+	files map[string][]balancer.Endpoint
+
+	// Clients are created on first use unless given, and shared by all watchers
+	resolver Resolver
+	client   kubernetes.Interface
+}
+
+// serviceWatchers are the running watchers of a service pool
+type serviceWatchers struct {
+	pool *balancer.Pool
+	stop chan struct{}
+}
+
+// NewManager creates a new discovery manager and starts watching all discovery
+// targets. DNS targets are looked up with the resolver, or with the system
+// nameservers when it is nil.
+func NewManager(cfg *config.Config, logger *logging.Logger, pools map[string]*balancer.Pool, resolver Resolver) (*Manager, error) {
+	m := &Manager{
+		config:   cfg,
+		logger:   logger,
+		stop:     make(chan struct{}),
+		pools:    make(map[string]*balancer.Pool),
+		watchers: make(map[string]serviceWatchers),
+		files:    make(map[string][]balancer.Endpoint),
+		resolver: resolver,
+	}
+
+	m.mu.Lock()
+	pending, err := m.update(cfg.Services, pools)
+	m.mu.Unlock()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	// Sync up front so the services have targets before the first request
+	var synced sync.WaitGroup
+	for _, p := range pending {
+		synced.Add(1)
+		go func(w syncWatcher) {
+			defer synced.Done()
+			w.Sync()
+		}(p.watcher)
+	}
+	synced.Wait()
+	for _, p := range pending {
+		m.start(p.watcher, p.stop)
+	}
+
+	// Target lists maintained by deploy tooling in a separate file
+	if path := cfg.Discovery.File.Path; path != "" {
+		watcher, err := NewFileWatcher(path, logger, func(service string, endpoints []balancer.Endpoint) bool {
+			return m.applyFile(service, endpoints)
+		})
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.start(watcher, m.stop)
+	}
+
+	return m, nil
+}
+
+// Update switches discovery to a new set of services and pools. Watchers of
+// pools that are still in use keep running, new pools get their own watchers
+// and the file targets read so far. Nothing changes when a watcher cannot be
+// created. New watchers sync in the background, and until then a new pool
+// serves the discovered targets of the pool it replaces.
+func (m *Manager) Update(services []config.ServiceConfig, pools map[string]*balancer.Pool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending, err := m.update(services, pools)
+	if err != nil {
+		return err
+	}
+	for _, p := range pending {
+		m.startSync(p.watcher, p.stop)
+	}
+	return nil
+}
+
+// update switches to the services and pools and returns the watchers of new
+// pools, which the caller starts
+func (m *Manager) update(services []config.ServiceConfig, pools map[string]*balancer.Pool) ([]pendingWatcher, error) {
+	watchers := make(map[string]serviceWatchers, len(services))
+	var pending []pendingWatcher
+	for _, svc := range services {
+		pool, ok := pools[svc.Name]
+		if !ok {
+			continue
+		}
+		if current, ok := m.watchers[svc.Name]; ok && current.pool == pool {
+			watchers[svc.Name] = current
+			continue
+		}
+
+		created, err := m.watch(svc, pool, m.pools[svc.Name])
+		if err != nil {
+			return nil, err
+		}
+		stop := make(chan struct{})
+		for _, w := range created {
+			pending = append(pending, pendingWatcher{watcher: w, stop: stop})
+		}
+		watchers[svc.Name] = serviceWatchers{pool: pool, stop: stop}
+	}
+
+	// Stop the watchers of pools that are no longer used
+	for service, current := range m.watchers {
+		if next, ok := watchers[service]; !ok || next.pool != current.pool {
+			close(current.stop)
+		}
+	}
+	m.watchers = watchers
+
+	for service, pool := range pools {
+		if m.pools[service] != pool {
+			if endpoints, ok := m.files[service]; ok {
+				m.apply(pool, service, FileSource, endpoints)
+			}
+		}
+	}
+	m.pools = pools
+
+	return pending, nil
+}
+
+// watch creates a watcher for every discovery target of a service. The pool
+// starts with the targets the previous pool of the service discovered.
+func (m *Manager) watch(svc config.ServiceConfig, pool, previous *balancer.Pool) ([]syncWatcher, error) {
+	cfg := m.config
+	var watchers []syncWatcher
+	for i, target := range svc.Targets {
+		if !balancer.IsDiscoveryTarget(target) {
+			continue
+		}
+
+		service, source := svc.Name, target
+		priority, group := balancer.TargetPriority(svc, i), balancer.TargetGroup(svc, i)
+		update := func(endpoints []balancer.Endpoint) {
+			// Resolved endpoints share the group of the discovery target, and
+			// their priority tiers, such as SRV priorities, start at its tier
+			for j := range endpoints {
+				endpoints[j].Priority += priority
+				endpoints[j].Group = group
+			}
+			m.apply(pool, service, source, endpoints)
+		}
+		if previous != nil {
+			if endpoints := previous.Endpoints(source); len(endpoints) > 0 {
+				m.apply(pool, service, source, endpoints)
+			}
+		}
+
+		var watcher syncWatcher
+		var err error
+		if strings.HasPrefix(target, "k8s://") {
+			// The Kubernetes client is only created when a k8s target exists
+			if m.client == nil {
+				if m.client, err = NewKubernetesClient(cfg.Discovery.Kubernetes); err != nil {
+					return nil, err
+				}
+			}
+			watcher, err = NewKubernetesWatcher(target, balancer.TargetWeight(svc, i), m.client, cfg.Discovery.Kubernetes, m.logger, update)
+		} else {
+			// The system resolver is only created when a DNS target exists
+			if m.resolver == nil {
+				if m.resolver, err = NewResolver(); err != nil {
+					return nil, fmt.Errorf("failed to create DNS resolver: %w", err)
+				}
+			}
+			watcher, err = NewDNSWatcher(target, balancer.TargetWeight(svc, i), m.resolver, cfg.Discovery.DNS, m.logger, update)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		watchers = append(watchers, watcher)
+	}
+	return watchers, nil
+}
+
+// Close stops all watchers
+func (m *Manager) Close() {
+	m.mu.Lock()
+	for _, w := range m.watchers {
+		close(w.stop)
+	}
+	m.watchers = nil
+	m.mu.Unlock()
+
+	close(m.stop)
+	m.wg.Wait()
+}
+
+// start runs a watcher in the background until stop is closed
+func (m *Manager) start(w Watcher, stop <-chan struct{}) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		w.Run(stop)
+	}()
+}
+
+// startSync syncs a watcher in the background and then runs it until stop is closed
+func (m *Manager) startSync(w syncWatcher, stop <-chan struct{}) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		w.Sync()
+		w.Run(stop)
+	}()
+}
+
+// applyFile records the file endpoints of a service and applies them when
+// the service exists
+func (m *Manager) applyFile(service string, endpoints []balancer.Endpoint) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if endpoints == nil {
+		delete(m.files, service)
+	} else {
+		m.files[service] = endpoints
+	}
+
+	pool, ok := m.pools[service]
+	if !ok {
+		return false
+	}
+	m.apply(pool, service, FileSource, endpoints)
+	return true
+}
+
+// apply replaces the endpoints of a source in a service pool
+func (m *Manager) apply(pool *balancer.Pool, service, source string, endpoints []balancer.Endpoint) {
+	added, removed := pool.Update(source, endpoints)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	m.logger.Info("Service targets updated by discovery",
+		zap.String("service", service),
+		zap.String("source", source),
+		zap.Strings("added", added),
+		zap.Strings("removed", removed),
+		zap.Int("targets", len(pool.Targets())))
+}
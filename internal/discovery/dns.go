@@ -0,0 +1,356 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// resolvConfPath is the resolver configuration used by the system resolver
+const resolvConfPath = "/etc/resolv.conf"
+
+// errNotFound is returned when a name has no records of the requested type
+var errNotFound = errors.New("no such record")
+
+// Resolver looks up DNS records together with the TTL of the answer
+type Resolver interface {
+	// LookupSRV returns the SRV records of a name
+	LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error)
+	// LookupHost returns the IPv4 and IPv6 addresses of a host
+	LookupHost(ctx context.Context, host string) ([]string, time.Duration, error)
+}
+
+// DNSWatcher re-resolves a dns+srv:// or dns+a:// target whenever its records expire
+type DNSWatcher struct {
+	target   string
+	kind     string
+	name     string
+	port     string
+	scheme   string
+	path     string
+	weight   int
+	resolver Resolver
+	config   config.DNSDiscoveryConfig
+	logger   *logging.Logger
+	update   func([]balancer.Endpoint)
+	next     time.Duration
+}
+
+// NewDNSWatcher creates a watcher for a DNS discovery target. Targets have the form dns+srv://_http._tcp.name or dns+a://host:port, and
+// accept a scheme query parameter for the upstream scheme (http by default).
+func NewDNSWatcher(target string, weight int, resolver Resolver, cfg config.DNSDiscoveryConfig, logger *logging.Logger, update func([]balancer.Endpoint)) (*DNSWatcher, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery target %q: %w", target, err)
+	}
+
+	w := &DNSWatcher{
+		target:   target,
+		name:     u.Hostname(),
+		port:     u.Port(),
+		scheme:   u.Query().Get("scheme"),
+		path:     u.Path,
+		weight:   weight,
+		resolver: resolver,
+		config:   cfg,
+		logger:   logger,
+		update:   update,
+	}
+	if w.scheme == "" {
+		w.scheme = "http"
+	}
+
+	switch u.Scheme {
+	case "dns+srv":
+		w.kind = "srv"
+	case "dns+a":
+		w.kind = "a"
+		if w.port == "" {
+			w.port = "80"
+			if w.scheme == "https" {
+				w.port = "443"
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported discovery target %q", target)
+	}
+	if w.name == "" {
+		return nil, fmt.Errorf("discovery target %q has no name to resolve", target)
+	}
+
+	return w, nil
+}
+
+// Sync resolves the target once and schedules the next refresh
+func (w *DNSWatcher) Sync() {
+	w.next = w.refresh()
+}
+
+// Run resolves the target on a TTL-driven schedule until stop is closed
+func (w *DNSWatcher) Run(stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(w.next)
+		select {
+		case <-timer.C:
+			w.next = w.refresh()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// refresh resolves the target, publishes the endpoints and returns the delay until the next refresh
+func (w *DNSWatcher) refresh() time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.config.Timeout)*time.Second)
+	defer cancel()
+
+	endpoints, ttl, err := w.resolve(ctx)
+	if err == nil && len(endpoints) == 0 {
+		err = fmt.Errorf("%s: %w", w.name, errNotFound)
+	}
+	if err != nil {
+		// Keep serving the last known endpoints until the name resolves again,
+		// so a lookup that returns nothing does not empty the pool
+		w.logger.Warn("Failed to resolve discovery target",
+			zap.String("target", w.target),
+			zap.Error(err))
+		return w.clamp(0)
+	}
+
+	w.update(endpoints)
+	return w.clamp(ttl)
+}
+
+// resolve looks up the endpoints of the target and the minimum TTL of the records
+func (w *DNSWatcher) resolve(ctx context.Context) ([]balancer.Endpoint, time.Duration, error) {
+	if w.kind == "a" {
+		return w.resolveHost(ctx, w.name, w.port)
+	}
+
+	records, ttl, err := w.resolver.LookupSRV(ctx, w.name)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var endpoints []balancer.Endpoint
+	var lastErr error
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		hostEndpoints, hostTTL, err := w.resolveHost(ctx, host, strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			// The other hosts of the record set are still served
+			w.logger.Warn("Failed to resolve SRV target host",
+				zap.String("target", w.target),
+				zap.String("host", host),
+				zap.Error(err))
+			lastErr = err
+			continue
+		}
+
+		// SRV priorities map onto failover tiers and SRV weights, when set,
+		// replace the weight of the target
+		for i := range hostEndpoints {
+			hostEndpoints[i].Priority = int(srv.Priority)
+			if srv.Weight > 0 {
+				hostEndpoints[i].Weight = int(srv.Weight)
+			}
+		}
+		endpoints = append(endpoints, hostEndpoints...)
+		ttl = minTTL(ttl, hostTTL)
+	}
+	if len(endpoints) == 0 && lastErr != nil {
+		return nil, 0, lastErr
+	}
+	// Hosts that failed are retried at the shortest refresh interval
+	if lastErr != nil {
+		ttl = 0
+	}
+	return endpoints, ttl, nil
+}
+
+// resolveHost turns the addresses of a host into endpoints
+func (w *DNSWatcher) resolveHost(ctx context.Context, host, port string) ([]balancer.Endpoint, time.Duration, error) {
+	addrs, ttl, err := w.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	endpoints := make([]balancer.Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		endpoints = append(endpoints, balancer.Endpoint{
+			URL:    fmt.Sprintf("%s://%s%s", w.scheme, net.JoinHostPort(addr, port), w.path),
+			Weight: w.weight,
+		})
+	}
+	return endpoints, ttl, nil
+}
+
+// clamp keeps the refresh interval within the configured bounds
+func (w *DNSWatcher) clamp(ttl time.Duration) time.Duration {
+	minRefresh := time.Duration(w.config.MinRefresh) * time.Second
+	maxRefresh := time.Duration(w.config.MaxRefresh) * time.Second
+	if ttl <= 0 {
+		return minRefresh
+	}
+	if ttl < minRefresh {
+		return minRefresh
+	}
+	if maxRefresh > 0 && ttl > maxRefresh {
+		return maxRefresh
+	}
+	return ttl
+}
+
+// dnsResolver queries the nameservers from resolv.conf directly so record TTLs are visible
+type dnsResolver struct {
+	conf *dns.ClientConfig
+	udp  *dns.Client
+	tcp  *dns.Client
+}
+
+// NewResolver creates a resolver that uses the system nameservers
+func NewResolver() (Resolver, error) {
+	conf, err := dns.ClientConfigFromFile(resolvConfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+	}
+
+	return &dnsResolver{
+		conf: conf,
+		udp:  &dns.Client{Net: "udp"},
+		tcp:  &dns.Client{Net: "tcp"},
+	}, nil
+}
+
+// LookupSRV returns the SRV records of a name
+func (r *dnsResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	answers, ttl, err := r.query(ctx, name, dns.TypeSRV)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]*net.SRV, 0, len(answers))
+	for _, rr := range answers {
+		if srv, ok := rr.(*dns.SRV); ok {
+			records = append(records, &net.SRV{
+				Target:   srv.Target,
+				Port:     srv.Port,
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Target < records[j].Target
+	})
+	return records, ttl, nil
+}
+
+// LookupHost returns the IPv4 and IPv6 addresses of a host
+func (r *dnsResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+
+	var addrs []string
+	var ttl time.Duration
+	var lastErr error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		answers, answerTTL, err := r.query(ctx, host, qtype)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, rr := range answers {
+			switch record := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, record.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, record.AAAA.String())
+			}
+		}
+		ttl = minTTL(ttl, answerTTL)
+	}
+
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no addresses found for %s", host)
+		}
+		return nil, 0, lastErr
+	}
+	sort.Strings(addrs)
+	return addrs, ttl, nil
+}
+
+// query sends a question through the search list and nameservers until one answers
+func (r *dnsResolver) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, time.Duration, error) {
+	lastErr := fmt.Errorf("no nameservers configured")
+	for _, fqdn := range r.conf.NameList(name) {
+		for _, server := range r.conf.Servers {
+			msg := new(dns.Msg)
+			msg.SetQuestion(fqdn, qtype)
+
+			addr := net.JoinHostPort(server, r.conf.Port)
+			in, _, err := r.udp.ExchangeContext(ctx, msg, addr)
+			if err == nil && in.Truncated {
+				// Large record sets do not fit into a UDP response
+				in, _, err = r.tcp.ExchangeContext(ctx, msg, addr)
+			}
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			if in.Rcode == dns.RcodeNameError {
+				lastErr = fmt.Errorf("%s: %w", fqdn, errNotFound)
+				break
+			}
+			if in.Rcode != dns.RcodeSuccess {
+				lastErr = fmt.Errorf("%s: %s", fqdn, dns.RcodeToString[in.Rcode])
+				continue
+			}
+
+			var answers []dns.RR
+			var ttl time.Duration
+			for _, rr := range in.Answer {
+				if rr.Header().Rrtype != qtype {
+					continue
+				}
+				answers = append(answers, rr)
+				ttl = minTTL(ttl, time.Duration(rr.Header().Ttl)*time.Second)
+			}
+			if len(answers) > 0 {
+				return answers, ttl, nil
+			}
+
+			lastErr = fmt.Errorf("%s: %w", fqdn, errNotFound)
+			break
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// minTTL returns the smaller of two TTLs, treating zero as unset
+func minTTL(a, b time.Duration) time.Duration {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,309 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+)
+
+// answer is the result of a fake lookup
+type answer struct {
+	records []string
+	srv     []*net.SRV
+	ttl     time.Duration
+	err     error
+}
+
+// fakeResolver answers lookups from a table that tests can change
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts map[string]answer
+	srv   map[string]answer
+	// block holds host lookups until it is closed
+	block chan struct{}
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{hosts: make(map[string]answer), srv: make(map[string]answer)}
+}
+
+func (r *fakeResolver) setHost(host string, a answer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = a
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.srv[name]
+	if !ok {
+		return nil, 0, errNotFound
+	}
+	return a.srv, a.ttl, a.err
+}
+
+func (r *fakeResolver) LookupHost(_ context.Context, host string) ([]string, time.Duration, error) {
+	r.mu.Lock()
+	block := r.block
+	r.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.hosts[host]
+	if !ok {
+		return nil, 0, errNotFound
+	}
+	return a.records, a.ttl, a.err
+}
+
+var testDNSConfig = config.DNSDiscoveryConfig{MinRefresh: 5, MaxRefresh: 60, Timeout: 1}
+
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+// targetURLs returns the URLs of the targets of a pool
+func targetURLs(pool *balancer.Pool) []string {
+	var urls []string
+	for _, t := range pool.Targets() {
+		urls = append(urls, t.URL)
+	}
+	return urls
+}
+
+func TestDNSWatcherRefreshInterval(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer answer
+		want   time.Duration
+	}{
+		{name: "ttl within bounds", answer: answer{records: []string{"10.0.0.1"}, ttl: 30 * time.Second}, want: 30 * time.Second},
+		{name: "ttl below min refresh", answer: answer{records: []string{"10.0.0.1"}, ttl: time.Second}, want: 5 * time.Second},
+		{name: "ttl above max refresh", answer: answer{records: []string{"10.0.0.1"}, ttl: time.Hour}, want: 60 * time.Second},
+		{name: "no ttl", answer: answer{records: []string{"10.0.0.1"}}, want: 5 * time.Second},
+		{name: "failed lookup", answer: answer{err: errors.New("timeout")}, want: 5 * time.Second},
+		{name: "empty answer", answer: answer{ttl: 30 * time.Second}, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newFakeResolver()
+			resolver.setHost("api.internal", tt.answer)
+			w, err := NewDNSWatcher("dns+a://api.internal:8080", 1, resolver, testDNSConfig, testLogger(t), func([]balancer.Endpoint) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.refresh(); got != tt.want {
+				t.Errorf("refresh interval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSWatcherKeepsLastGoodTargets(t *testing.T) {
+	steps := []struct {
+		name   string
+		answer answer
+		want   []string
+	}{
+		{name: "resolved", answer: answer{records: []string{"10.0.0.1", "10.0.0.2"}}, want: []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}},
+		{name: "failed lookup", answer: answer{err: errors.New("timeout")}, want: []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}},
+		{name: "empty answer", answer: answer{}, want: []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}},
+		{name: "target removed", answer: answer{records: []string{"10.0.0.2"}}, want: []string{"http://10.0.0.2:8080"}},
+		{name: "target added", answer: answer{records: []string{"10.0.0.2", "10.0.0.3"}}, want: []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"}},
+	}
+
+	const target = "dns+a://api.internal:8080"
+	pool, err := balancer.NewPool(config.ServiceConfig{Name: "api", Targets: []string{target}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := newFakeResolver()
+	resolver.setHost("api.internal", steps[0].answer)
+	w, err := NewDNSWatcher(target, 1, resolver, testDNSConfig, testLogger(t), func(endpoints []balancer.Endpoint) {
+		pool.Update(target, endpoints)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step depends on the targets left by the previous ones
+	for _, step := range steps {
+		resolver.setHost("api.internal", step.answer)
+		w.refresh()
+		if got := targetURLs(pool); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: targets = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestDNSWatcherSRV(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*net.SRV
+		// b is the address answer of the second host
+		b        answer
+		wantNext time.Duration
+		want     []balancer.Endpoint
+	}{
+		{
+			name:    "shortest TTL",
+			records: []*net.SRV{{Target: "a.api.internal.", Port: 8080}, {Target: "b.api.internal.", Port: 9090}},
+			b:       answer{records: []string{"10.0.0.2"}, ttl: 20 * time.Second},
+			// The refresh follows the shortest TTL of the SRV and address records
+			wantNext: 10 * time.Second,
+			want: []balancer.Endpoint{
+				{URL: "https://10.0.0.1:8080/v1", Weight: 3},
+				{URL: "https://10.0.0.2:9090/v1", Weight: 3},
+			},
+		},
+		{
+			name: "priority and weight",
+			records: []*net.SRV{
+				{Target: "a.api.internal.", Port: 8080, Priority: 0, Weight: 5},
+				{Target: "b.api.internal.", Port: 9090, Priority: 1},
+			},
+			b:        answer{records: []string{"10.0.0.2"}, ttl: 20 * time.Second},
+			wantNext: 10 * time.Second,
+			want: []balancer.Endpoint{
+				{URL: "https://10.0.0.1:8080/v1", Weight: 5},
+				{URL: "https://10.0.0.2:9090/v1", Weight: 3, Priority: 1},
+			},
+		},
+		{
+			name:     "host that fails to resolve",
+			records:  []*net.SRV{{Target: "a.api.internal.", Port: 8080}, {Target: "b.api.internal.", Port: 9090}},
+			b:        answer{err: errNotFound},
+			wantNext: 5 * time.Second,
+			want:     []balancer.Endpoint{{URL: "https://10.0.0.1:8080/v1", Weight: 3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newFakeResolver()
+			resolver.srv["_http._tcp.api.internal"] = answer{srv: tt.records, ttl: 30 * time.Second}
+			resolver.setHost("a.api.internal", answer{records: []string{"10.0.0.1"}, ttl: 10 * time.Second})
+			resolver.setHost("b.api.internal", tt.b)
+
+			var endpoints []balancer.Endpoint
+			w, err := NewDNSWatcher("dns+srv://_http._tcp.api.internal/v1?scheme=https", 3, resolver, testDNSConfig, testLogger(t), func(eps []balancer.Endpoint) {
+				endpoints = eps
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if next := w.refresh(); next != tt.wantNext {
+				t.Errorf("refresh interval = %v, want %v", next, tt.wantNext)
+			}
+			if !reflect.DeepEqual(endpoints, tt.want) {
+				t.Errorf("endpoints = %+v, want %+v", endpoints, tt.want)
+			}
+		})
+	}
+}
+
+func TestManagerUsesResolver(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.setHost("api.internal", answer{records: []string{"10.0.0.1"}, ttl: 30 * time.Second})
+
+	svc := config.ServiceConfig{Name: "api", Targets: []string{"dns+a://api.internal:8080", "http://static:8080"}}
+	pool, err := balancer.NewPool(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Services: []config.ServiceConfig{svc}}
+	cfg.Discovery.DNS = testDNSConfig
+
+	m, err := NewManager(cfg, testLogger(t), map[string]*balancer.Pool{svc.Name: pool}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	want := []string{"http://10.0.0.1:8080", "http://static:8080"}
+	if got := targetURLs(pool); !reflect.DeepEqual(got, want) {
+		t.Errorf("targets = %v, want %v", got, want)
+	}
+}
+
+func TestManagerUpdateSyncsInBackground(t *testing.T) {
+	resolver := newFakeResolver()
+	resolver.setHost("api.internal", answer{records: []string{"10.0.0.1"}, ttl: 30 * time.Second})
+
+	svc := config.ServiceConfig{Name: "api", Targets: []string{"dns+a://api.internal:8080"}}
+	pool, err := balancer.NewPool(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Services: []config.ServiceConfig{svc}}
+	cfg.Discovery.DNS = testDNSConfig
+
+	m, err := NewManager(cfg, testLogger(t), map[string]*balancer.Pool{svc.Name: pool}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// A changed service gets a new pool while the name does not resolve yet
+	block := make(chan struct{})
+	resolver.mu.Lock()
+	resolver.block = block
+	resolver.hosts["api.internal"] = answer{records: []string{"10.0.0.2"}, ttl: 30 * time.Second}
+	resolver.mu.Unlock()
+
+	svc.Weights = []int{2}
+	next, err := balancer.NewPool(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := make(chan error, 1)
+	go func() {
+		updated <- m.Update([]config.ServiceConfig{svc}, map[string]*balancer.Pool{svc.Name: next})
+	}()
+	select {
+	case err := <-updated:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Update waited for the lookup")
+	}
+
+	// The new pool serves the previous targets until the lookup completes
+	if got, want := targetURLs(next), []string{"http://10.0.0.1:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("targets before sync = %v, want %v", got, want)
+	}
+
+	close(block)
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := targetURLs(next)
+		if reflect.DeepEqual(got, []string{"http://10.0.0.2:8080"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("targets after sync = %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,208 @@
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/proxy"
+	"api-gateway/pkg/logging"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSource is the pool source name of targets read from the discovery file
+const FileSource = "file"
+
+// fileDebounce groups the burst of events produced by a single file write
+const fileDebounce = 100 * time.Millisecond
+
+// fileTargets is the format of the discovery file. YAML is a superset of
+// JSON, so both formats are accepted:
+//
+//	services:
+//	  ice-age-royal-api:
+//	    - url: "http://10.0.1.5:8080"
+//	      weight: 2
+//	    - url: "http://10.0.1.6:8080"
+//	    - url: "http://10.1.1.5:8080"
+//	      priority: 1
+//	    - url: "http://10.0.2.7:8080"
+//	      group: "canary"
+type fileTargets struct {
+	Services map[string][]struct {
+		URL      string `yaml:"url"`
+		Weight   int    `yaml:"weight"`
+		Priority int    `yaml:"priority"`
+		Group    string `yaml:"group"`
+	} `yaml:"services"`
+}
+
+// FileWatcher applies target lists from a file whenever the file changes
+type FileWatcher struct {
+	path    string
+	logger  *logging.Logger
+	watcher *fsnotify.Watcher
+	update  func(service string, endpoints []balancer.Endpoint) bool
+	content []byte
+	// services holds the services listed in the applied file
+	services map[string]struct{}
+}
+
+// NewFileWatcher creates a watcher for the discovery file and applies its current content.
+// update is called for every service in the file and reports whether the service is known.
+func NewFileWatcher(path string, logger *logging.Logger, update func(service string, endpoints []balancer.Endpoint) bool) (*FileWatcher, error) {
+	w := &FileWatcher{
+		path:     path,
+		logger:   logger,
+		update:   update,
+		services: make(map[string]struct{}),
+	}
+
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	// Watch the directory so files replaced by rename, such as mounted
+	// Kubernetes ConfigMaps, keep being picked up
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+	w.watcher = watcher
+
+	return w, nil
+}
+
+// Run reloads the file on changes until stop is closed
+func (w *FileWatcher) Run(stop <-chan struct{}) {
+	defer w.watcher.Close()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			debounce = time.After(fileDebounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Warn("Discovery file watcher error",
+				zap.String("path", w.path),
+				zap.Error(err))
+		case <-debounce:
+			debounce = nil
+			if err := w.reload(); err != nil {
+				// Keep the previous targets until the file is valid again
+				w.logger.Error("Failed to reload discovery file",
+					zap.String("path", w.path),
+					zap.Error(err))
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reload reads and validates the whole file before applying any of it
+func (w *FileWatcher) reload() error {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to read discovery file: %w", err)
+	}
+	if w.content != nil && bytes.Equal(content, w.content) {
+		return nil
+	}
+
+	var file fileTargets
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("failed to parse discovery file: %w", err)
+	}
+
+	updates := make(map[string][]balancer.Endpoint, len(file.Services))
+	for service, targets := range file.Services {
+		endpoints := make([]balancer.Endpoint, 0, len(targets))
+		for i, target := range targets {
+			if target.URL == "" {
+				return fmt.Errorf("service %s: target %d has no url", service, i)
+			}
+			if balancer.IsDiscoveryTarget(target.URL) {
+				return fmt.Errorf("service %s: discovery target %q is not allowed in the discovery file", service, target.URL)
+			}
+			if _, err := proxy.ParseUpstream(target.URL); err != nil {
+				return fmt.Errorf("service %s: %w", service, err)
+			}
+			if target.Weight < 0 {
+				return fmt.Errorf("service %s: target %q has a negative weight", service, target.URL)
+			}
+			if target.Priority < 0 {
+				return fmt.Errorf("service %s: target %q has a negative priority", service, target.URL)
+			}
+			endpoints = append(endpoints, balancer.Endpoint{
+				URL:      target.URL,
+				Weight:   target.Weight,
+				Priority: target.Priority,
+				Group:    target.Group,
+			})
+		}
+		updates[service] = endpoints
+	}
+
+	// Services dropped from the file lose their file targets, including
+	// services that were unknown when they were listed
+	for service := range w.services {
+		if _, ok := updates[service]; !ok {
+			updates[service] = nil
+		}
+	}
+
+	names := make([]string, 0, len(updates))
+	for service := range updates {
+		names = append(names, service)
+	}
+	sort.Strings(names)
+
+	known := 0
+	for _, service := range names {
+		if !w.update(service, updates[service]) {
+			if _, ok := file.Services[service]; ok {
+				w.logger.Warn("Discovery file references unknown service",
+					zap.String("path", w.path),
+					zap.String("service", service))
+			}
+			continue
+		}
+		if _, ok := file.Services[service]; ok {
+			known++
+		}
+	}
+
+	services := make(map[string]struct{}, len(file.Services))
+	for service := range file.Services {
+		services[service] = struct{}{}
+	}
+	w.content = content
+	w.services = services
+
+	w.logger.Info("Discovery file applied",
+		zap.String("path", w.path),
+		zap.Int("services", known))
+
+	return nil
+}
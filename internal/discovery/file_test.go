@@ -0,0 +1,130 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"api-gateway/internal/balancer"
+)
+
+// writeFile replaces the content of the discovery file
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileWatcherValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []balancer.Endpoint
+		wantErr bool
+	}{
+		{
+			name: "targets",
+			content: `services:
+  api:
+    - url: "http://10.0.1.5:8080"
+      weight: 2
+    - url: "unix:///run/api.sock"
+      priority: 1
+      group: "canary"`,
+			want: []balancer.Endpoint{
+				{URL: "http://10.0.1.5:8080", Weight: 2},
+				{URL: "unix:///run/api.sock", Priority: 1, Group: "canary"},
+			},
+		},
+		{name: "invalid yaml", content: "services: [", wantErr: true},
+		{name: "missing url", content: "services:\n  api:\n    - weight: 1", wantErr: true},
+		{name: "discovery target", content: "services:\n  api:\n    - url: \"dns+a://api.internal:8080\"", wantErr: true},
+		{name: "missing host", content: "services:\n  api:\n    - url: \"10.0.1.5:8080\"", wantErr: true},
+		{name: "unix target without socket", content: "services:\n  api:\n    - url: \"unix://api.sock\"", wantErr: true},
+		{name: "negative weight", content: "services:\n  api:\n    - url: \"http://a:8080\"\n      weight: -1", wantErr: true},
+		{name: "negative priority", content: "services:\n  api:\n    - url: \"http://a:8080\"\n      priority: -1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yaml")
+			writeFile(t, path, tt.content)
+
+			var got []balancer.Endpoint
+			w, err := NewFileWatcher(path, testLogger(t), func(service string, endpoints []balancer.Endpoint) bool {
+				got = endpoints
+				return true
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer w.watcher.Close()
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("endpoints = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWatcherDroppedServices(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		// want lists the services that get no file targets on the reload
+		want []string
+	}{
+		{
+			name:   "known service dropped",
+			before: "services:\n  api:\n    - url: \"http://a:8080\"\n  web:\n    - url: \"http://b:8080\"",
+			after:  "services:\n  web:\n    - url: \"http://b:8080\"",
+			want:   []string{"api"},
+		},
+		{
+			name:   "unknown service dropped",
+			before: "services:\n  api:\n    - url: \"http://a:8080\"\n  games:\n    - url: \"http://c:8080\"",
+			after:  "services:\n  api:\n    - url: \"http://a:8080\"",
+			want:   []string{"games"},
+		},
+		{
+			name:   "file emptied",
+			before: "services:\n  api:\n    - url: \"http://a:8080\"\n  games:\n    - url: \"http://c:8080\"",
+			after:  "services: {}",
+			want:   []string{"api", "games"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.yaml")
+			writeFile(t, path, tt.before)
+
+			var dropped []string
+			w, err := NewFileWatcher(path, testLogger(t), func(service string, endpoints []balancer.Endpoint) bool {
+				if endpoints == nil {
+					dropped = append(dropped, service)
+				}
+				return service != "games"
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.watcher.Close()
+
+			writeFile(t, path, tt.after)
+			if err := w.reload(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dropped, tt.want) {
+				t.Errorf("dropped = %v, want %v", dropped, tt.want)
+			}
+		})
+	}
+}
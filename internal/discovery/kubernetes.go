@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"go.uber.org/zap"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// NewKubernetesClient creates a Kubernetes client from the configured kubeconfig,
+// or from the service account of the pod when no kubeconfig is set
+func NewKubernetesClient(cfg config.KubernetesDiscoveryConfig) (kubernetes.Interface, error) {
+	var restConfig *rest.Config
+	var err error
+	if cfg.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", cfg.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Kubernetes client config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// KubernetesWatcher feeds the ready pod addresses of a Service's EndpointSlices into a pool
+type KubernetesWatcher struct {
+	target    string
+	namespace string
+	service   string
+	port      string
+	scheme    string
+	path      string
+	weight    int
+	timeout   time.Duration
+	logger    *logging.Logger
+	update    func([]balancer.Endpoint)
+	factory   informers.SharedInformerFactory
+	lister    discoverylisters.EndpointSliceLister
+	informer  cache.SharedIndexInformer
+	stop      chan struct{}
+	mu        sync.Mutex
+}
+
+// NewKubernetesWatcher creates a watcher for a target of the form
+// k8s://service.namespace:port, where port is the name or number of the
+// endpoint port
+func NewKubernetesWatcher(target string, weight int, client kubernetes.Interface, cfg config.KubernetesDiscoveryConfig, logger *logging.Logger, update func([]balancer.Endpoint)) (*KubernetesWatcher, error) {
+	u, port, err := parseKubernetesTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	service, namespace, ok := strings.Cut(u.Hostname(), ".")
+	if !ok || service == "" || namespace == "" {
+		return nil, fmt.Errorf("discovery target %q must name a service as service.namespace", target)
+	}
+
+	w := &KubernetesWatcher{
+		target:    target,
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		scheme:    u.Query().Get("scheme"),
+		path:      u.Path,
+		weight:    weight,
+		logger:    logger,
+		update:    update,
+		stop:      make(chan struct{}),
+	}
+	if w.scheme == "" {
+		w.scheme = "http"
+	}
+
+	// Only watch the slices that belong to the service
+	w.timeout = time.Duration(cfg.Timeout) * time.Second
+	w.factory = informers.NewSharedInformerFactoryWithOptions(client,
+		time.Duration(cfg.Resync)*time.Second,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = discoveryv1.LabelServiceName + "=" + service
+		}),
+	)
+	slices := w.factory.Discovery().V1().EndpointSlices()
+	w.lister = slices.Lister()
+	w.informer = slices.Informer()
+	if _, err := w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { w.sync() },
+		UpdateFunc: func(interface{}, interface{}) { w.sync() },
+		DeleteFunc: func(interface{}) { w.sync() },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to watch EndpointSlices of %s: %w", target, err)
+	}
+
+	return w, nil
+}
+
+// Sync starts watching and waits for the initial list of EndpointSlices
+func (w *KubernetesWatcher) Sync() {
+	w.factory.Start(w.stop)
+
+	synced := make(chan struct{})
+	timer := time.AfterFunc(w.timeout, func() { close(synced) })
+	if !cache.WaitForCacheSync(synced, w.informer.HasSynced) {
+		w.logger.Warn("EndpointSlices not synced yet, targets will be added once available",
+			zap.String("target", w.target),
+			zap.Duration("timeout", w.timeout))
+	}
+	timer.Stop()
+}
+
+// parseKubernetesTarget splits the port off a k8s:// target, since port
+// names are not valid URL ports
+func parseKubernetesTarget(target string) (*url.URL, string, error) {
+	rest, ok := strings.CutPrefix(target, "k8s://")
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported discovery target %q", target)
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	host, port, _ := strings.Cut(rest[:end], ":")
+
+	u, err := url.Parse("k8s://" + host + rest[end:])
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid discovery target %q: %w", target, err)
+	}
+	return u, port, nil
+}
+
+// Run keeps watching until stop is closed
+func (w *KubernetesWatcher) Run(stop <-chan struct{}) {
+	<-stop
+	close(w.stop)
+	w.factory.Shutdown()
+}
+
+// sync recomputes the endpoints from all slices of the service
+func (w *KubernetesWatcher) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	slices, err := w.lister.EndpointSlices(w.namespace).List(labels.Everything())
+	if err != nil {
+		w.logger.Error("Failed to list EndpointSlices",
+			zap.String("target", w.target),
+			zap.Error(err))
+		return
+	}
+
+	var endpoints []balancer.Endpoint
+	for _, slice := range slices {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		port, ok := w.slicePort(slice)
+		if !ok {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if !isServing(ep) || len(ep.Addresses) == 0 {
+				continue
+			}
+			endpoints = append(endpoints, balancer.Endpoint{
+				URL:    fmt.Sprintf("%s://%s%s", w.scheme, net.JoinHostPort(ep.Addresses[0], strconv.Itoa(int(port))), w.path),
+				Weight: w.weight,
+			})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].URL < endpoints[j].URL
+	})
+
+	w.update(endpoints)
+}
+
+// slicePort returns the port of the slice that matches the target
+func (w *KubernetesWatcher) slicePort(slice *discoveryv1.EndpointSlice) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		switch {
+		case w.port == "" && len(slice.Ports) == 1:
+			return *p.Port, true
+		case p.Name != nil && *p.Name == w.port:
+			return *p.Port, true
+		case strconv.Itoa(int(*p.Port)) == w.port:
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
+
+// isServing reports whether an endpoint is ready and not shutting down
+func isServing(ep discoveryv1.Endpoint) bool {
+	// A nil ready condition is interpreted as ready by the EndpointSlice API
+	if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+		return false
+	}
+	if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,267 @@
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testKubernetesConfig = config.KubernetesDiscoveryConfig{Timeout: 5}
+
+// endpointSlice builds an IPv4 EndpointSlice of a service in the games namespace
+func endpointSlice(name, service string, ports []discoveryv1.EndpointPort, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "games",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: service},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       ports,
+		Endpoints:   endpoints,
+	}
+}
+
+// endpointPort builds a named EndpointSlice port
+func endpointPort(name string, port int32) discoveryv1.EndpointPort {
+	return discoveryv1.EndpointPort{Name: &name, Port: &port}
+}
+
+// endpoint builds an endpoint with its ready and terminating conditions
+func endpoint(address string, ready, terminating *bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{address},
+		Conditions: discoveryv1.EndpointConditions{Ready: ready, Terminating: terminating},
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// endpointRecorder keeps the endpoints of the last update of a watcher
+type endpointRecorder struct {
+	mu        sync.Mutex
+	endpoints []string
+}
+
+func (r *endpointRecorder) update(endpoints []balancer.Endpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.endpoints = nil
+	for _, ep := range endpoints {
+		r.endpoints = append(r.endpoints, ep.URL)
+	}
+}
+
+// wait returns once the last update has the wanted endpoints
+func (r *endpointRecorder) wait(t *testing.T, want []string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		got := r.endpoints
+		r.mu.Unlock()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("endpoints = %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startKubernetesWatcher syncs and runs a watcher until the test ends
+func startKubernetesWatcher(t *testing.T, target string, client *fake.Clientset, recorder *endpointRecorder) {
+	t.Helper()
+
+	w, err := NewKubernetesWatcher(target, 1, client, testKubernetesConfig, testLogger(t), recorder.update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Sync()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(stop)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+}
+
+func TestKubernetesWatcherEndpointConditions(t *testing.T) {
+	slice := endpointSlice("api-abc", "api", []discoveryv1.EndpointPort{endpointPort("http", 8080)},
+		endpoint("10.0.0.1", nil, nil),
+		endpoint("10.0.0.2", boolPtr(true), boolPtr(false)),
+		endpoint("10.0.0.3", boolPtr(false), nil),
+		endpoint("10.0.0.4", boolPtr(true), boolPtr(true)),
+		discoveryv1.Endpoint{},
+	)
+	other := endpointSlice("web-abc", "web", []discoveryv1.EndpointPort{endpointPort("http", 8080)},
+		endpoint("10.0.1.1", nil, nil),
+	)
+	fqdn := endpointSlice("api-fqdn", "api", []discoveryv1.EndpointPort{endpointPort("http", 8080)},
+		endpoint("api.example.com", nil, nil),
+	)
+	fqdn.AddressType = discoveryv1.AddressTypeFQDN
+
+	recorder := &endpointRecorder{}
+	startKubernetesWatcher(t, "k8s://api.games:http", fake.NewSimpleClientset(slice, other, fqdn), recorder)
+
+	// Unready, terminating and address-less endpoints, FQDN slices and other
+	// services are skipped
+	recorder.wait(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+}
+
+func TestKubernetesWatcherPorts(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		ports  []discoveryv1.EndpointPort
+		want   []string
+	}{
+		{
+			name:   "port by name",
+			target: "k8s://api.games:grpc",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8080), endpointPort("grpc", 9090)},
+			want:   []string{"http://10.0.0.1:9090"},
+		},
+		{
+			name:   "port by number",
+			target: "k8s://api.games:8080",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8080), endpointPort("grpc", 9090)},
+			want:   []string{"http://10.0.0.1:8080"},
+		},
+		{
+			name:   "single port",
+			target: "k8s://api.games",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8080)},
+			want:   []string{"http://10.0.0.1:8080"},
+		},
+		{
+			name:   "omitted port with several ports",
+			target: "k8s://api.games",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8080), endpointPort("grpc", 9090)},
+		},
+		{
+			name:   "unknown port",
+			target: "k8s://api.games:metrics",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8080)},
+		},
+		{
+			name:   "scheme and path",
+			target: "k8s://api.games:http/v1?scheme=https",
+			ports:  []discoveryv1.EndpointPort{endpointPort("http", 8443)},
+			want:   []string{"https://10.0.0.1:8443/v1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slice := endpointSlice("api-abc", "api", tt.ports, endpoint("10.0.0.1", nil, nil))
+			recorder := &endpointRecorder{}
+			startKubernetesWatcher(t, tt.target, fake.NewSimpleClientset(slice), recorder)
+			recorder.wait(t, tt.want)
+		})
+	}
+}
+
+func TestKubernetesWatcherSliceChanges(t *testing.T) {
+	ports := []discoveryv1.EndpointPort{endpointPort("http", 8080)}
+	client := fake.NewSimpleClientset(
+		endpointSlice("api-abc", "api", ports, endpoint("10.0.0.1", nil, nil)),
+		endpointSlice("api-def", "api", ports, endpoint("10.0.0.2", nil, nil)),
+	)
+	recorder := &endpointRecorder{}
+	startKubernetesWatcher(t, "k8s://api.games:http", client, recorder)
+	recorder.wait(t, []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"})
+
+	slices := client.DiscoveryV1().EndpointSlices("games")
+	steps := []struct {
+		name   string
+		change func() error
+		want   []string
+	}{
+		{
+			name: "endpoint terminating",
+			change: func() error {
+				_, err := slices.Update(context.Background(), endpointSlice("api-abc", "api", ports, endpoint("10.0.0.1", nil, boolPtr(true))), metav1.UpdateOptions{})
+				return err
+			},
+			want: []string{"http://10.0.0.2:8080"},
+		},
+		{
+			name: "slice added",
+			change: func() error {
+				_, err := slices.Create(context.Background(), endpointSlice("api-ghi", "api", ports, endpoint("10.0.0.3", nil, nil)), metav1.CreateOptions{})
+				return err
+			},
+			want: []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"},
+		},
+		{
+			name: "slice deleted",
+			change: func() error {
+				return slices.Delete(context.Background(), "api-def", metav1.DeleteOptions{})
+			},
+			want: []string{"http://10.0.0.3:8080"},
+		},
+		{
+			name: "all slices deleted",
+			change: func() error {
+				for _, name := range []string{"api-abc", "api-ghi"} {
+					if err := slices.Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	}
+
+	// Each step depends on the slices left by the previous ones
+	for _, step := range steps {
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		recorder.wait(t, step.want)
+	}
+}
+
+func TestNewKubernetesWatcherTargets(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr bool
+	}{
+		{target: "k8s://api.games"},
+		{target: "k8s://api.games:http"},
+		{target: "k8s://api.games:8080/v1?scheme=https"},
+		{target: "k8s://api", wantErr: true},
+		{target: "k8s://.games:http", wantErr: true},
+		{target: "dns+a://api.games:8080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			_, err := NewKubernetesWatcher(tt.target, 1, fake.NewSimpleClientset(), testKubernetesConfig, testLogger(t), func([]balancer.Endpoint) {})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,509 @@
+package health
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCanaryStableGroup       = "stable"
+	defaultCanaryGroup             = "canary"
+	defaultCanaryInterval          = 60
+	defaultCanaryMinRequests       = 50
+	defaultCanaryMaxErrorRateDelta = 1.0
+	defaultCanaryMaxLatencyRatio   = 1.5
+
+	// maxLatencySamples bounds the latencies kept per group and window
+	maxLatencySamples = 10000
+	// maxCanaryDecisions is the number of decisions kept per service
+	maxCanaryDecisions = 100
+)
+
+var defaultCanarySteps = []int{5, 10, 25, 50}
+
+// Canary phases
+const (
+	CanaryProgressing = "progressing"
+	CanaryPromoted    = "promoted"
+	CanaryRolledBack  = "rolled_back"
+)
+
+// Canary decision actions
+const (
+	CanaryActionStart    = "start"
+	CanaryActionHold     = "hold"
+	CanaryActionAdvance  = "advance"
+	CanaryActionPromote  = "promote"
+	CanaryActionRollback = "rollback"
+)
+
+// WindowStats summarizes the traffic of a target group in one analysis window
+type WindowStats struct {
+	Requests  int     `json:"requests"`
+	ErrorRate float64 `json:"error_rate_percent"`
+	P99       float64 `json:"p99_ms"`
+}
+
+// CanaryDecision is a single step of the canary analysis
+type CanaryDecision struct {
+	Time         time.Time    `json:"time"`
+	Action       string       `json:"action"`
+	CanaryWeight int          `json:"canary_weight"`
+	Reason       string       `json:"reason"`
+	Stable       *WindowStats `json:"stable,omitempty"`
+	Canary       *WindowStats `json:"canary,omitempty"`
+}
+
+// CanaryStatus is the progress of the canary of a service
+type CanaryStatus struct {
+	Service      string           `json:"service"`
+	Phase        string           `json:"phase"`
+	CanaryWeight int              `json:"canary_weight"`
+	Decisions    []CanaryDecision `json:"decisions"`
+}
+
+// groupWindow collects request outcomes of a target group
+type groupWindow struct {
+	requests  int
+	errors    int
+	latencies []time.Duration
+}
+
+// add records a request, keeping a uniform sample of the latencies
+func (w *groupWindow) add(outcome Outcome, latency time.Duration) {
+	w.requests++
+	if outcome != OutcomeSuccess {
+		w.errors++
+	}
+	if len(w.latencies) < maxLatencySamples {
+		w.latencies = append(w.latencies, latency)
+	} else if i := rand.IntN(w.requests); i < maxLatencySamples {
+		w.latencies[i] = latency
+	}
+}
+
+// stats returns the error rate and p99 latency of the window
+func (w *groupWindow) stats() *WindowStats {
+	stats := &WindowStats{Requests: w.requests}
+	if w.requests == 0 {
+		return stats
+	}
+	stats.ErrorRate = float64(w.errors) * 100 / float64(w.requests)
+
+	sorted := slices.Clone(w.latencies)
+	slices.Sort(sorted)
+	idx := int(math.Ceil(float64(len(sorted))*0.99)) - 1
+	stats.P99 = float64(sorted[max(idx, 0)]) / float64(time.Millisecond)
+	return stats
+}
+
+// canaryState is the analysis of a single service
+type canaryState struct {
+	pool      *balancer.Pool
+	settings  config.CanaryConfig
+	restart   chan struct{}
+	stop      chan struct{}
+	mu        sync.Mutex
+	phase     string
+	step      int
+	weight    int
+	stable    groupWindow
+	canary    groupWindow
+	decisions []CanaryDecision
+}
+
+// CanaryController moves traffic from a stable to a canary target group step
+// by step, and rolls the canary back when it performs worse than stable
+type CanaryController struct {
+	logger    *logging.Logger
+	decisions *prometheus.CounterVec
+	weights   *prometheus.GaugeVec
+	wg        sync.WaitGroup
+
+	mu     sync.RWMutex
+	states map[string]*canaryState
+}
+
+// NewCanaryController creates a canary controller and starts the analysis of
+// services that enable it
+func NewCanaryController(cfg *config.Config, logger *logging.Logger, pools map[string]*balancer.Pool) (*CanaryController, error) {
+	c := &CanaryController{
+		logger:    logger,
+		decisions: metrics.NewCanaryDecisions(),
+		weights:   metrics.NewCanaryWeight(),
+		states:    make(map[string]*canaryState),
+	}
+
+	if err := c.Update(cfg.Services, pools); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update switches the analysis to a new set of services and pools. Canaries
+// with unchanged settings keep their progress, even when promoted or rolled
+// back, and carry it over to a replaced pool. New and changed canaries start
+// at the first step, and nothing changes when a service is invalid.
+func (c *CanaryController) Update(services []config.ServiceConfig, pools map[string]*balancer.Pool) error {
+	settings := make(map[string]config.CanaryConfig)
+	for _, svc := range services {
+		if !svc.Canary.Enable {
+			continue
+		}
+		pool, ok := pools[svc.Name]
+		if !ok {
+			continue
+		}
+
+		cc := withCanaryDefaults(svc.Canary)
+		if err := validateCanary(cc, pool); err != nil {
+			return fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		settings[svc.Name] = cc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	states := make(map[string]*canaryState, len(settings))
+	for service, cc := range settings {
+		pool := pools[service]
+		if current, ok := c.states[service]; ok && reflect.DeepEqual(current.settings, cc) {
+			if current.pool != pool {
+				c.move(service, current, pool)
+			}
+			states[service] = current
+			continue
+		}
+
+		state := &canaryState{
+			pool:     pool,
+			settings: cc,
+			restart:  make(chan struct{}, 1),
+			stop:     make(chan struct{}),
+		}
+		states[service] = state
+		c.begin(service, state, "canary analysis started")
+		c.wg.Add(1)
+		go c.run(service, state)
+	}
+
+	// Stop the analysis of canaries that were removed or replaced
+	for service, current := range c.states {
+		if states[service] != current {
+			close(current.stop)
+			if _, ok := states[service]; !ok {
+				c.weights.DeleteLabelValues(service)
+			}
+		}
+	}
+	c.states = states
+
+	return nil
+}
+
+// state returns the analysis of a service
+func (c *CanaryController) state(service string) (*canaryState, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state, ok := c.states[service]
+	return state, ok
+}
+
+// Collectors returns the Prometheus collectors of the controller
+func (c *CanaryController) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.decisions, c.weights}
+}
+
+// Close stops the analysis
+func (c *CanaryController) Close() {
+	c.mu.Lock()
+	for _, state := range c.states {
+		close(state.stop)
+	}
+	c.states = nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+// Report records the outcome and latency of a request to a target group of the service
+func (c *CanaryController) Report(service, group string, outcome Outcome, latency time.Duration) {
+	state, ok := c.state(service)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.phase != CanaryProgressing {
+		return
+	}
+	switch group {
+	case state.settings.StableGroup:
+		state.stable.add(outcome, latency)
+	case state.settings.CanaryGroup:
+		state.canary.add(outcome, latency)
+	}
+}
+
+// Status returns the progress and decisions of the canary of a service
+func (c *CanaryController) Status(service string) (CanaryStatus, bool) {
+	state, ok := c.state(service)
+	if !ok {
+		return CanaryStatus{}, false
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	return CanaryStatus{
+		Service:      service,
+		Phase:        state.phase,
+		CanaryWeight: state.weight,
+		Decisions:    slices.Clone(state.decisions),
+	}, true
+}
+
+// Restart starts the canary of a service again from its first step
+func (c *CanaryController) Restart(service string) bool {
+	state, ok := c.state(service)
+	if !ok {
+		return false
+	}
+
+	c.begin(service, state, "canary analysis restarted")
+	select {
+	case state.restart <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run judges the canary of a service after every interval
+func (c *CanaryController) run(service string, state *canaryState) {
+	defer c.wg.Done()
+
+	interval := time.Duration(state.settings.Interval) * time.Second
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-state.stop:
+			return
+		case <-state.restart:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timer.C:
+			c.analyze(service, state)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// move hands a canary over to the new pool of its service at its current weight
+func (c *CanaryController) move(service string, state *canaryState, pool *balancer.Pool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.pool = pool
+	if err := pool.SetSplit(canarySplit(state.settings, state.weight)); err != nil {
+		c.logger.Error("Failed to change canary weight",
+			zap.String("service", service),
+			zap.Int("canary_weight", state.weight),
+			zap.Error(err))
+	}
+}
+
+// begin moves a canary to its first step
+func (c *CanaryController) begin(service string, state *canaryState, reason string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.phase = CanaryProgressing
+	state.step = 0
+	c.apply(service, state, state.settings.Steps[0], CanaryActionStart, reason, nil, nil)
+}
+
+// analyze compares the canary with the stable group over the last window and
+// decides whether to hold, step forward or roll back
+func (c *CanaryController) analyze(service string, state *canaryState) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.phase != CanaryProgressing {
+		return
+	}
+
+	settings := state.settings
+	stable, canary := state.stable.stats(), state.canary.stats()
+
+	// Keep collecting until both groups have enough traffic to compare
+	if canary.Requests < settings.MinRequests || stable.Requests < settings.MinRequests {
+		c.record(service, state, CanaryActionHold,
+			fmt.Sprintf("waiting for %d requests per group", settings.MinRequests), stable, canary)
+		return
+	}
+
+	if delta := canary.ErrorRate - stable.ErrorRate; delta > settings.MaxErrorRateDelta {
+		state.phase = CanaryRolledBack
+		c.apply(service, state, 0, CanaryActionRollback,
+			fmt.Sprintf("canary error rate %.2f%% exceeds stable %.2f%% by more than %.2f points",
+				canary.ErrorRate, stable.ErrorRate, settings.MaxErrorRateDelta), stable, canary)
+		return
+	}
+	if stable.P99 > 0 && canary.P99 > stable.P99*settings.MaxLatencyRatio {
+		state.phase = CanaryRolledBack
+		c.apply(service, state, 0, CanaryActionRollback,
+			fmt.Sprintf("canary p99 latency %.1fms exceeds %.1fx stable p99 %.1fms",
+				canary.P99, settings.MaxLatencyRatio, stable.P99), stable, canary)
+		return
+	}
+
+	state.step++
+	if state.step >= len(settings.Steps) {
+		state.phase = CanaryPromoted
+		c.apply(service, state, 100, CanaryActionPromote, "canary passed all steps", stable, canary)
+		return
+	}
+	c.apply(service, state, settings.Steps[state.step], CanaryActionAdvance, "canary within thresholds", stable, canary)
+}
+
+// apply sets the canary weight and starts a new analysis window, the caller holds the lock
+func (c *CanaryController) apply(service string, state *canaryState, weight int, action, reason string, stable, canary *WindowStats) {
+	if err := state.pool.SetSplit(canarySplit(state.settings, weight)); err != nil {
+		c.logger.Error("Failed to change canary weight",
+			zap.String("service", service),
+			zap.Int("canary_weight", weight),
+			zap.Error(err))
+		return
+	}
+
+	state.weight = weight
+	state.stable = groupWindow{}
+	state.canary = groupWindow{}
+	c.weights.WithLabelValues(service).Set(float64(weight))
+	c.record(service, state, action, reason, stable, canary)
+}
+
+// record keeps and logs a decision, the caller holds the lock
+func (c *CanaryController) record(service string, state *canaryState, action, reason string, stable, canary *WindowStats) {
+	decision := CanaryDecision{
+		Time:         time.Now(),
+		Action:       action,
+		CanaryWeight: state.weight,
+		Reason:       reason,
+		Stable:       stable,
+		Canary:       canary,
+	}
+	state.decisions = append(state.decisions, decision)
+	if len(state.decisions) > maxCanaryDecisions {
+		state.decisions = state.decisions[len(state.decisions)-maxCanaryDecisions:]
+	}
+	c.decisions.WithLabelValues(service, action).Inc()
+
+	fields := []zap.Field{
+		zap.String("service", service),
+		zap.String("action", action),
+		zap.Int("canary_weight", state.weight),
+		zap.String("reason", reason),
+	}
+	if stable != nil && canary != nil {
+		fields = append(fields,
+			zap.Int("stable_requests", stable.Requests),
+			zap.Float64("stable_error_rate", stable.ErrorRate),
+			zap.Float64("stable_p99_ms", stable.P99),
+			zap.Int("canary_requests", canary.Requests),
+			zap.Float64("canary_error_rate", canary.ErrorRate),
+			zap.Float64("canary_p99_ms", canary.P99))
+	}
+	if action == CanaryActionRollback {
+		c.logger.Warn("Canary rolled back", fields...)
+	} else {
+		c.logger.Info("Canary decision", fields...)
+	}
+}
+
+// canarySplit returns the traffic split that sends the weight to the canary group
+func canarySplit(settings config.CanaryConfig, weight int) []balancer.GroupWeight {
+	return []balancer.GroupWeight{
+		{Group: settings.StableGroup, Weight: 100 - weight},
+		{Group: settings.CanaryGroup, Weight: weight},
+	}
+}
+
+// validateCanary checks the steps and that the service splits its traffic
+// between exactly the stable and canary groups
+func validateCanary(settings config.CanaryConfig, pool *balancer.Pool) error {
+	if settings.StableGroup == settings.CanaryGroup {
+		return fmt.Errorf("canary and stable group must differ")
+	}
+
+	prev := 0
+	for _, step := range settings.Steps {
+		// The canary is promoted to 100 after the last step
+		if step <= prev || step >= 100 {
+			return fmt.Errorf("canary steps must increase within 1 and 99")
+		}
+		prev = step
+	}
+
+	split := pool.Split()
+	valid := len(split) == 2
+	for _, g := range split {
+		if g.Group != settings.StableGroup && g.Group != settings.CanaryGroup {
+			valid = false
+		}
+	}
+	if !valid {
+		return fmt.Errorf("canary requires a traffic split between the %s and %s groups",
+			settings.StableGroup, settings.CanaryGroup)
+	}
+	return nil
+}
+
+// withCanaryDefaults fills unset canary settings
+func withCanaryDefaults(cc config.CanaryConfig) config.CanaryConfig {
+	if cc.StableGroup == "" {
+		cc.StableGroup = defaultCanaryStableGroup
+	}
+	if cc.CanaryGroup == "" {
+		cc.CanaryGroup = defaultCanaryGroup
+	}
+	if len(cc.Steps) == 0 {
+		cc.Steps = defaultCanarySteps
+	}
+	if cc.Interval <= 0 {
+		cc.Interval = defaultCanaryInterval
+	}
+	if cc.MinRequests <= 0 {
+		cc.MinRequests = defaultCanaryMinRequests
+	}
+	if cc.MaxErrorRateDelta <= 0 {
+		cc.MaxErrorRateDelta = defaultCanaryMaxErrorRateDelta
+	}
+	if cc.MaxLatencyRatio <= 0 {
+		cc.MaxLatencyRatio = defaultCanaryMaxLatencyRatio
+	}
+	return cc
+}
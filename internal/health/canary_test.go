@@ -0,0 +1,274 @@
+package health
+
+import (
+	"testing"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+)
+
+// canaryService is a service that splits its traffic between a stable and a
+// canary target and judges the canary on two requests per group
+func canaryService() config.ServiceConfig {
+	return config.ServiceConfig{
+		Name:         "api",
+		Targets:      []string{"http://stable:8080", "http://canary:8080"},
+		TargetGroups: []string{"stable", "canary"},
+		TrafficSplit: []config.TrafficSplitConfig{{Group: "stable", Weight: 100}, {Group: "canary", Weight: 0}},
+		Canary: config.CanaryConfig{
+			Enable:      true,
+			Steps:       []int{10, 50},
+			Interval:    3600,
+			MinRequests: 2,
+		},
+	}
+}
+
+// canaryWeight returns the weight the traffic split of a pool sends to the canary group
+func canaryWeight(pool *balancer.Pool) int {
+	for _, g := range pool.Split() {
+		if g.Group == "canary" {
+			return g.Weight
+		}
+	}
+	return -1
+}
+
+// window is the traffic reported for one group during an analysis window
+type window struct {
+	requests int
+	errors   int
+	latency  time.Duration
+}
+
+func (w window) report(c *CanaryController, group string) {
+	for i := 0; i < w.requests; i++ {
+		outcome := OutcomeSuccess
+		if i < w.errors {
+			outcome = OutcomeGatewayError
+		}
+		c.Report("api", group, outcome, w.latency)
+	}
+}
+
+func TestCanaryControllerAnalyze(t *testing.T) {
+	healthy := window{requests: 2, latency: 10 * time.Millisecond}
+
+	tests := []struct {
+		name string
+		// step is the step the canary is at before the window
+		step       int
+		stable     window
+		canary     window
+		wantAction string
+		wantPhase  string
+		wantWeight int
+	}{
+		{
+			name:       "too few requests",
+			stable:     healthy,
+			canary:     window{requests: 1, latency: 10 * time.Millisecond},
+			wantAction: CanaryActionHold,
+			wantPhase:  CanaryProgressing,
+			wantWeight: 10,
+		},
+		{
+			name:       "within thresholds",
+			stable:     healthy,
+			canary:     healthy,
+			wantAction: CanaryActionAdvance,
+			wantPhase:  CanaryProgressing,
+			wantWeight: 50,
+		},
+		{
+			name:       "last step passed",
+			step:       1,
+			stable:     healthy,
+			canary:     healthy,
+			wantAction: CanaryActionPromote,
+			wantPhase:  CanaryPromoted,
+			wantWeight: 100,
+		},
+		{
+			name:       "error rate above stable",
+			stable:     healthy,
+			canary:     window{requests: 2, errors: 1, latency: 10 * time.Millisecond},
+			wantAction: CanaryActionRollback,
+			wantPhase:  CanaryRolledBack,
+			wantWeight: 0,
+		},
+		{
+			name:       "latency above stable",
+			stable:     healthy,
+			canary:     window{requests: 2, latency: 20 * time.Millisecond},
+			wantAction: CanaryActionRollback,
+			wantPhase:  CanaryRolledBack,
+			wantWeight: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := canaryService()
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCanaryController(&config.Config{Services: []config.ServiceConfig{svc}}, testLogger(t), map[string]*balancer.Pool{svc.Name: pool})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			state, _ := c.state("api")
+			for i := 0; i < tt.step; i++ {
+				healthy.report(c, "stable")
+				healthy.report(c, "canary")
+				c.analyze("api", state)
+			}
+
+			tt.stable.report(c, "stable")
+			tt.canary.report(c, "canary")
+			c.analyze("api", state)
+
+			status, _ := c.Status("api")
+			if got := status.Decisions[len(status.Decisions)-1].Action; got != tt.wantAction {
+				t.Errorf("action = %s, want %s", got, tt.wantAction)
+			}
+			if status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", status.Phase, tt.wantPhase)
+			}
+			if status.CanaryWeight != tt.wantWeight {
+				t.Errorf("status weight = %d, want %d", status.CanaryWeight, tt.wantWeight)
+			}
+			if got := canaryWeight(pool); got != tt.wantWeight {
+				t.Errorf("split weight = %d, want %d", got, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestCanaryControllerUpdateKeepsProgress(t *testing.T) {
+	healthy := window{requests: 2, latency: 10 * time.Millisecond}
+
+	tests := []struct {
+		name string
+		// steps is the number of passed windows before the update
+		steps int
+		// change edits the service, newPool replaces its pool like a reload does
+		change     func(svc *config.ServiceConfig)
+		newPool    bool
+		wantPhase  string
+		wantWeight int
+	}{
+		{
+			name:       "unchanged service",
+			steps:      1,
+			change:     func(svc *config.ServiceConfig) {},
+			wantPhase:  CanaryProgressing,
+			wantWeight: 50,
+		},
+		{
+			name:       "other settings changed",
+			steps:      1,
+			change:     func(svc *config.ServiceConfig) { svc.StripBasePath = true },
+			newPool:    true,
+			wantPhase:  CanaryProgressing,
+			wantWeight: 50,
+		},
+		{
+			name:       "promoted canary",
+			steps:      2,
+			change:     func(svc *config.ServiceConfig) { svc.StripBasePath = true },
+			newPool:    true,
+			wantPhase:  CanaryPromoted,
+			wantWeight: 100,
+		},
+		{
+			name:       "canary settings changed",
+			steps:      2,
+			change:     func(svc *config.ServiceConfig) { svc.Canary.Steps = []int{20, 50} },
+			newPool:    true,
+			wantPhase:  CanaryProgressing,
+			wantWeight: 20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := canaryService()
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCanaryController(&config.Config{Services: []config.ServiceConfig{svc}}, testLogger(t), map[string]*balancer.Pool{svc.Name: pool})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			state, _ := c.state("api")
+			for i := 0; i < tt.steps; i++ {
+				healthy.report(c, "stable")
+				healthy.report(c, "canary")
+				c.analyze("api", state)
+			}
+
+			tt.change(&svc)
+			if tt.newPool {
+				if pool, err = balancer.NewPool(svc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Update([]config.ServiceConfig{svc}, map[string]*balancer.Pool{svc.Name: pool}); err != nil {
+				t.Fatal(err)
+			}
+
+			status, _ := c.Status("api")
+			if status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", status.Phase, tt.wantPhase)
+			}
+			if status.CanaryWeight != tt.wantWeight {
+				t.Errorf("status weight = %d, want %d", status.CanaryWeight, tt.wantWeight)
+			}
+			if got := canaryWeight(pool); got != tt.wantWeight {
+				t.Errorf("split weight = %d, want %d", got, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestValidateCanary(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(svc *config.ServiceConfig)
+		wantErr bool
+	}{
+		{name: "valid", change: func(svc *config.ServiceConfig) {}},
+		{name: "same groups", change: func(svc *config.ServiceConfig) { svc.Canary.CanaryGroup = "stable" }, wantErr: true},
+		{name: "decreasing steps", change: func(svc *config.ServiceConfig) { svc.Canary.Steps = []int{50, 10} }, wantErr: true},
+		{name: "step of 100", change: func(svc *config.ServiceConfig) { svc.Canary.Steps = []int{10, 100} }, wantErr: true},
+		{
+			name: "no traffic split",
+			change: func(svc *config.ServiceConfig) {
+				svc.TargetGroups = nil
+				svc.TrafficSplit = nil
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := canaryService()
+			tt.change(&svc)
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateCanary(withCanaryDefaults(svc.Canary), pool)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
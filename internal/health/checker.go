@@ -0,0 +1,324 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/internal/proxy"
+	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+)
+
+// Checker actively probes upstream targets and updates their health state
+type Checker struct {
+	config      *config.Config
+	logger      *logging.Logger
+	client      *http.Client
+	transports  *proxy.Transports
+	tls         *proxy.TLSTransports
+	healthy     *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+	wg          sync.WaitGroup
+
+	mu sync.Mutex
+	// probes holds the running probe loop of each service
+	probes map[string]probeLoop
+}
+
+// probeLoop is the running health check of a service pool
+type probeLoop struct {
+	pool *balancer.Pool
+	hc   config.HealthCheckConfig
+	stop chan struct{}
+}
+
+// NewChecker creates a new health checker and starts probing the targets
+// of services that have a health check path configured. Probes connect with
+// the upstream TLS settings of their service.
+func NewChecker(cfg *config.Config, logger *logging.Logger, pools map[string]*balancer.Pool, upstreamTLS *proxy.UpstreamTLS) (*Checker, error) {
+	checker := &Checker{
+		config: cfg,
+		logger: logger,
+		client: &http.Client{
+			// Probes should not follow redirects to other hosts
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		// Probes of Unix socket and h2c targets use pools of their own
+		transports:  proxy.NewTransports(func() *http.Transport { return &http.Transport{} }),
+		tls:         proxy.NewTLSTransports(upstreamTLS, func() *http.Transport { return &http.Transport{} }),
+		healthy:     metrics.NewUpstreamHealthy(),
+		transitions: metrics.NewUpstreamHealthTransitions(),
+		probes:      make(map[string]probeLoop),
+	}
+
+	if err := checker.Update(cfg.Services, pools); err != nil {
+		return nil, err
+	}
+	return checker, nil
+}
+
+// Update switches the probes to a new set of services and pools. Probes of
+// unchanged pools keep running, and nothing changes when a service is invalid.
+// The upstream TLS settings must be updated first.
+func (c *Checker) Update(services []config.ServiceConfig, pools map[string]*balancer.Pool) error {
+	for _, svc := range services {
+		if svc.HealthCheck.Path != "" && svc.HealthCheck.Interval <= 0 {
+			return fmt.Errorf("service %s: health check interval must be positive", svc.Name)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	probes := make(map[string]probeLoop, len(services))
+	for _, svc := range services {
+		hc := svc.HealthCheck
+		if hc.Path == "" {
+			continue
+		}
+		pool, ok := pools[svc.Name]
+		if !ok {
+			continue
+		}
+
+		if current, ok := c.probes[svc.Name]; ok && current.pool == pool && current.hc == hc {
+			probes[svc.Name] = current
+			continue
+		}
+
+		loop := probeLoop{pool: pool, hc: hc, stop: make(chan struct{})}
+		probes[svc.Name] = loop
+		c.wg.Add(1)
+		go c.run(loop)
+	}
+
+	// Stop the probes of pools that are no longer used
+	for service, current := range c.probes {
+		if next, ok := probes[service]; !ok || next.stop != current.stop {
+			close(current.stop)
+		}
+	}
+	c.probes = probes
+	c.tls.SetServices(services)
+
+	return nil
+}
+
+// Collectors returns the Prometheus collectors of the checker
+func (c *Checker) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.healthy, c.transitions}
+}
+
+// Close stops all probes
+func (c *Checker) Close() {
+	c.mu.Lock()
+	for _, loop := range c.probes {
+		close(loop.stop)
+	}
+	c.probes = nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+// probeState tracks consecutive probe results of a single target
+type probeState struct {
+	successes int
+	failures  int
+}
+
+// run probes every target of a pool on the service's schedule. The target
+// set is re-read on every round so discovered targets are picked up.
+func (c *Checker) run(loop probeLoop) {
+	defer c.wg.Done()
+
+	pool, hc := loop.pool, loop.hc
+	healthyThreshold := hc.HealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	unhealthyThreshold := hc.UnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	ticker := time.NewTicker(time.Duration(hc.Interval) * time.Second)
+	defer ticker.Stop()
+
+	service := pool.Service()
+	states := make(map[*balancer.Target]*probeState)
+	for {
+		targets := pool.Targets()
+
+		// Forget targets that left the pool
+		current := make(map[*balancer.Target]struct{}, len(targets))
+		for _, target := range targets {
+			current[target] = struct{}{}
+			if _, ok := states[target]; !ok {
+				states[target] = &probeState{}
+				c.healthy.WithLabelValues(service, target.URL).Set(healthValue(target.Healthy()))
+			}
+		}
+		for target := range states {
+			if _, ok := current[target]; !ok {
+				delete(states, target)
+				c.healthy.DeleteLabelValues(service, target.URL)
+			}
+		}
+
+		// Probe all targets of the round concurrently
+		results := make([]error, len(targets))
+		var wg sync.WaitGroup
+		for i, target := range targets {
+			wg.Add(1)
+			go func(i int, target *balancer.Target) {
+				defer wg.Done()
+				results[i] = c.probe(service, hc, target)
+			}(i, target)
+		}
+		wg.Wait()
+
+		for i, target := range targets {
+			state := states[target]
+			if err := results[i]; err == nil {
+				state.successes++
+				state.failures = 0
+				if state.successes >= healthyThreshold {
+					c.setHealthy(service, target, true, nil)
+				}
+			} else {
+				state.failures++
+				state.successes = 0
+				if state.failures >= unhealthyThreshold {
+					c.setHealthy(service, target, false, err)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-loop.stop:
+			c.forget(service, loop, states)
+			return
+		}
+	}
+}
+
+// forget removes the health gauges of the targets of a stopped probe loop,
+// except those of targets that the loop replacing it probes
+func (c *Checker) forget(service string, loop probeLoop, states map[*balancer.Target]*probeState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	kept := make(map[string]struct{})
+	if next, ok := c.probes[service]; ok && next.stop != loop.stop {
+		for _, target := range next.pool.Targets() {
+			kept[target.URL] = struct{}{}
+		}
+	}
+	for target := range states {
+		if _, ok := kept[target.URL]; !ok {
+			c.healthy.DeleteLabelValues(service, target.URL)
+		}
+	}
+}
+
+// probe performs a single health check request against the target
+func (c *Checker) probe(service string, hc config.HealthCheckConfig, target *balancer.Target) error {
+	upstream, err := proxy.ParseUpstream(target.URL)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(hc.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(hc.Interval) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL(upstream, hc.Path), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create health check request: %w", err)
+	}
+	req.Header.Set("User-Agent", "api-gateway-health-check")
+
+	client := c.client
+	rt := c.transports.RoundTripper(upstream)
+	if rt == nil {
+		rt = c.tls.RoundTripper(service)
+	}
+	if rt != nil {
+		client = &http.Client{Transport: rt, CheckRedirect: c.client.CheckRedirect}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// setHealthy records the health state of a target and reports changes
+func (c *Checker) setHealthy(service string, target *balancer.Target, healthy bool, cause error) {
+	if !target.SetHealthy(healthy) {
+		return
+	}
+
+	state := "unhealthy"
+	if healthy {
+		state = "healthy"
+	}
+	c.healthy.WithLabelValues(service, target.URL).Set(healthValue(healthy))
+	c.transitions.WithLabelValues(service, target.URL, state).Inc()
+
+	if healthy {
+		c.logger.Info("Upstream target became healthy",
+			zap.String("service", service),
+			zap.String("target", target.URL))
+	} else {
+		c.logger.Warn("Upstream target became unhealthy",
+			zap.String("service", service),
+			zap.String("target", target.URL),
+			zap.Error(cause))
+	}
+}
+
+// healthValue converts a health state into a gauge value
+func healthValue(healthy bool) float64 {
+	if healthy {
+		return 1
+	}
+	return 0
+}
+
+// checkURL builds the health check URL for a target. WebSocket targets are
+// probed over HTTP.
+func checkURL(upstream proxy.Upstream, path string) string {
+	switch upstream.Scheme {
+	case "ws":
+		upstream.Scheme = "http"
+	case "wss":
+		upstream.Scheme = "https"
+	}
+	return upstream.URL(path)
+}
@@ -0,0 +1,193 @@
+package health
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/internal/proxy"
+	"api-gateway/pkg/logging"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testLogger creates the logger of a test
+func testLogger(t *testing.T) *logging.Logger {
+	t.Helper()
+
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logger
+}
+
+func TestCheckerForgetsStoppedProbes(t *testing.T) {
+	// Nothing listens on the targets, so every probe fails right away
+	svc := config.ServiceConfig{
+		Name:        "api",
+		Targets:     []string{"http://127.0.0.1:1", "http://127.0.0.2:1"},
+		HealthCheck: config.HealthCheckConfig{Path: "/health", Interval: 3600},
+	}
+
+	tests := []struct {
+		name string
+		// change stops the probes of the service, or replaces them
+		change func(t *testing.T, checker *Checker)
+		// want is the number of targets with a health gauge afterwards
+		want int
+	}{
+		{
+			name: "health check removed",
+			change: func(t *testing.T, checker *Checker) {
+				other := svc
+				other.HealthCheck = config.HealthCheckConfig{}
+				pool, err := balancer.NewPool(other)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := checker.Update([]config.ServiceConfig{other}, map[string]*balancer.Pool{"api": pool}); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "pool replaced",
+			change: func(t *testing.T, checker *Checker) {
+				pool, err := balancer.NewPool(svc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := checker.Update([]config.ServiceConfig{svc}, map[string]*balancer.Pool{"api": pool}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: 2,
+		},
+		{
+			name: "pool replaced with fewer targets",
+			change: func(t *testing.T, checker *Checker) {
+				other := svc
+				other.Targets = svc.Targets[:1]
+				pool, err := balancer.NewPool(other)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := checker.Update([]config.ServiceConfig{other}, map[string]*balancer.Pool{"api": pool}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: 1,
+		},
+		{
+			name:   "checker closed",
+			change: func(t *testing.T, checker *Checker) { checker.Close() },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := testLogger(t)
+			cfg := &config.Config{Services: []config.ServiceConfig{svc}}
+			upstreamTLS, err := proxy.NewUpstreamTLS(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer upstreamTLS.Close()
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checker, err := NewChecker(cfg, logger, map[string]*balancer.Pool{"api": pool}, upstreamTLS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer checker.Close()
+
+			// gauges waits until the number of health gauges settles at want
+			gauges := func(want int) int {
+				deadline := time.Now().Add(2 * time.Second)
+				for {
+					got := testutil.CollectAndCount(checker.healthy)
+					if got == want || time.Now().After(deadline) {
+						return got
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			if got := gauges(2); got != 2 {
+				t.Fatalf("gauges before the change = %d, want 2", got)
+			}
+
+			tt.change(t, checker)
+			// Give the stopped probe loop time to exit before counting
+			time.Sleep(50 * time.Millisecond)
+			if got := gauges(tt.want); got != tt.want {
+				t.Errorf("gauges = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckerProbeUpstreamTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := testLogger(t)
+
+	tests := []struct {
+		name    string
+		tls     config.UpstreamTLSConfig
+		healthy bool
+	}{
+		{name: "system roots", healthy: false},
+		{name: "service CA", tls: config.UpstreamTLSConfig{CAFile: caFile}, healthy: true},
+		{name: "insecure skip verify", tls: config.UpstreamTLSConfig{InsecureSkipVerify: true}, healthy: true},
+	}
+
+	// A single checker follows the settings of the service across updates
+	cfg := &config.Config{}
+	upstreamTLS, err := proxy.NewUpstreamTLS(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstreamTLS.Close()
+	checker, err := NewChecker(cfg, logger, nil, upstreamTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checker.Close()
+
+	hc := config.HealthCheckConfig{Path: "/health", Interval: 1}
+	target := balancer.NewTarget(server.URL, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []config.ServiceConfig{{Name: "api", TLS: tt.tls}}
+			if err := upstreamTLS.Update(services); err != nil {
+				t.Fatal(err)
+			}
+			if err := checker.Update(services, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			err := checker.probe("api", hc, target)
+			if healthy := err == nil; healthy != tt.healthy {
+				t.Errorf("probe healthy = %v, want %v (err: %v)", healthy, tt.healthy, err)
+			}
+		})
+	}
+}
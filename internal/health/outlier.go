@@ -0,0 +1,262 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultConsecutive5xx           = 5
+	defaultConsecutiveGatewayErrors = 5
+	defaultBaseEjectionTime         = 30
+	defaultMaxEjectionTime          = 300
+	defaultMaxEjectionPercent       = 50
+)
+
+// Outcome is the result of a single proxied request as seen by the gateway
+type Outcome int
+
+const (
+	// OutcomeSuccess means the upstream answered with a non-5xx response
+	OutcomeSuccess Outcome = iota
+	// OutcomeServerError means the upstream answered with a 5xx response
+	OutcomeServerError
+	// OutcomeGatewayError means the upstream could not be reached or timed out
+	OutcomeGatewayError
+)
+
+// String returns the metric label of the outcome
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeServerError:
+		return "server_error"
+	case OutcomeGatewayError:
+		return "gateway_error"
+	default:
+		return "success"
+	}
+}
+
+// outlierState tracks consecutive failures of a single target
+type outlierState struct {
+	service            string
+	consecutive5xx     int
+	consecutiveGateway int
+	ejections          int
+	lastEjectionEnd    time.Time
+}
+
+// OutlierDetector ejects targets based on the results of real traffic
+type OutlierDetector struct {
+	config    *config.Config
+	logger    *logging.Logger
+	pools     map[string]*balancer.Pool
+	settings  map[string]config.OutlierDetectionConfig
+	states    map[*balancer.Target]*outlierState
+	mu        sync.Mutex
+	ejections *prometheus.CounterVec
+
+	// generations holds the pool generation each service was last pruned at
+	generations map[string]uint64
+}
+
+// NewOutlierDetector creates a new outlier detector for services that enable it
+func NewOutlierDetector(cfg *config.Config, logger *logging.Logger, pools map[string]*balancer.Pool) (*OutlierDetector, error) {
+	d := &OutlierDetector{
+		config:    cfg,
+		logger:    logger,
+		states:    make(map[*balancer.Target]*outlierState),
+		ejections: metrics.NewUpstreamEjections(),
+	}
+	d.Update(cfg.Services, pools)
+	return d, nil
+}
+
+// Update switches detection to a new set of services and pools, and forgets
+// the targets that are no longer in them
+func (d *OutlierDetector) Update(services []config.ServiceConfig, pools map[string]*balancer.Pool) {
+	settings := make(map[string]config.OutlierDetectionConfig)
+	for _, svc := range services {
+		if !svc.OutlierDetection.Enable {
+			continue
+		}
+		settings[svc.Name] = withOutlierDefaults(svc.OutlierDetection)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.settings = settings
+	d.pools = pools
+	d.generations = make(map[string]uint64)
+	d.prune(func(service string) bool { return true })
+}
+
+// prune forgets the state and ejection metrics of targets of the matching
+// services that left their pools. Targets replaced by another target with the
+// same URL keep their metrics.
+func (d *OutlierDetector) prune(match func(service string) bool) {
+	current := make(map[string]map[string]*balancer.Target)
+	for target, state := range d.states {
+		if !match(state.service) {
+			continue
+		}
+		urls, ok := current[state.service]
+		if !ok {
+			urls = make(map[string]*balancer.Target)
+			if pool, ok := d.pools[state.service]; ok {
+				if _, enabled := d.settings[state.service]; enabled {
+					for _, t := range pool.Targets() {
+						urls[t.URL] = t
+					}
+				}
+			}
+			current[state.service] = urls
+		}
+
+		if urls[target.URL] == target {
+			continue
+		}
+		delete(d.states, target)
+		if _, ok := urls[target.URL]; !ok {
+			d.ejections.DeleteLabelValues(state.service, target.URL)
+		}
+	}
+}
+
+// pruneService forgets the targets that left the pool of a service since it
+// was last pruned
+func (d *OutlierDetector) pruneService(service string) {
+	pool, ok := d.pools[service]
+	if !ok {
+		return
+	}
+	generation := pool.Generation()
+	if last, ok := d.generations[service]; ok && last == generation {
+		return
+	}
+	d.generations[service] = generation
+	d.prune(func(name string) bool { return name == service })
+}
+
+// Collectors returns the Prometheus collectors of the detector
+func (d *OutlierDetector) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{d.ejections}
+}
+
+// Report records the outcome of a request to a target of the service
+func (d *OutlierDetector) Report(service string, target *balancer.Target, outcome Outcome) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	settings, ok := d.settings[service]
+	if !ok {
+		return
+	}
+	d.pruneService(service)
+
+	state, ok := d.states[target]
+	if !ok {
+		state = &outlierState{service: service}
+		d.states[target] = state
+	}
+
+	switch outcome {
+	case OutcomeSuccess:
+		state.consecutive5xx = 0
+		state.consecutiveGateway = 0
+		// Forget past ejections once the target has behaved for long enough
+		maxEjection := time.Duration(settings.MaxEjectionTime) * time.Second
+		if state.ejections > 0 && time.Since(state.lastEjectionEnd) > maxEjection {
+			state.ejections = 0
+		}
+		return
+	case OutcomeServerError:
+		state.consecutive5xx++
+	case OutcomeGatewayError:
+		state.consecutive5xx++
+		state.consecutiveGateway++
+	}
+
+	if state.consecutive5xx < settings.Consecutive5xx &&
+		state.consecutiveGateway < settings.ConsecutiveGatewayErrors {
+		return
+	}
+	if target.Ejected() {
+		return
+	}
+
+	d.eject(service, target, state, settings)
+}
+
+// eject removes a target from selection unless that would eject more than the
+// max ejection percent of the pool or leave no other target available
+func (d *OutlierDetector) eject(service string, target *balancer.Target, state *outlierState, settings config.OutlierDetectionConfig) {
+	if pool, ok := d.pools[service]; ok {
+		targets := pool.Targets()
+		ejected, available := 0, 0
+		for _, t := range targets {
+			if t.Ejected() {
+				ejected++
+			} else if t != target && t.Available() {
+				available++
+			}
+		}
+		if (ejected+1)*100 > settings.MaxEjectionPercent*len(targets) || available == 0 {
+			d.logger.Warn("Outlier ejection skipped, max ejection percent reached",
+				zap.String("service", service),
+				zap.String("target", target.URL),
+				zap.Int("ejected", ejected),
+				zap.Int("max_ejection_percent", settings.MaxEjectionPercent))
+			return
+		}
+	}
+
+	// Each ejection of the same target lasts longer than the previous one
+	state.ejections++
+	duration := time.Duration(settings.BaseEjectionTime*state.ejections) * time.Second
+	if maxDuration := time.Duration(settings.MaxEjectionTime) * time.Second; duration > maxDuration {
+		duration = maxDuration
+	}
+	until := time.Now().Add(duration)
+
+	target.Eject(until)
+	state.consecutive5xx = 0
+	state.consecutiveGateway = 0
+	state.lastEjectionEnd = until
+	d.ejections.WithLabelValues(service, target.URL).Inc()
+
+	d.logger.Warn("Upstream target ejected by outlier detection",
+		zap.String("service", service),
+		zap.String("target", target.URL),
+		zap.Duration("duration", duration),
+		zap.Int("ejections", state.ejections))
+}
+
+// withOutlierDefaults fills unset outlier detection settings
+func withOutlierDefaults(od config.OutlierDetectionConfig) config.OutlierDetectionConfig {
+	if od.Consecutive5xx <= 0 {
+		od.Consecutive5xx = defaultConsecutive5xx
+	}
+	if od.ConsecutiveGatewayErrors <= 0 {
+		od.ConsecutiveGatewayErrors = defaultConsecutiveGatewayErrors
+	}
+	if od.BaseEjectionTime <= 0 {
+		od.BaseEjectionTime = defaultBaseEjectionTime
+	}
+	if od.MaxEjectionTime <= 0 {
+		od.MaxEjectionTime = defaultMaxEjectionTime
+	}
+	if od.MaxEjectionPercent <= 0 {
+		od.MaxEjectionPercent = defaultMaxEjectionPercent
+	}
+	return od
+}
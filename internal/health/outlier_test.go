@@ -0,0 +1,154 @@
+package health
+
+import (
+	"testing"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// outlierService is a service that ejects a target after a single gateway error
+func outlierService(targets ...string) config.ServiceConfig {
+	return config.ServiceConfig{
+		Name:          "api",
+		Targets:       targets,
+		LoadBalancing: "round_robin",
+		OutlierDetection: config.OutlierDetectionConfig{
+			Enable:                   true,
+			ConsecutiveGatewayErrors: 1,
+			Consecutive5xx:           1,
+			MaxEjectionPercent:       50,
+		},
+	}
+}
+
+// lookupTarget returns the target of a pool with a URL
+func lookupTarget(t *testing.T, pool *balancer.Pool, url string) *balancer.Target {
+	t.Helper()
+
+	for _, target := range pool.Targets() {
+		if target.URL == url {
+			return target
+		}
+	}
+	t.Fatalf("target %s not in pool", url)
+	return nil
+}
+
+func TestOutlierDetectorPrunesRemovedTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		// remove replaces the targets of the pool or the detector's pools
+		remove      func(d *OutlierDetector, pool *balancer.Pool, svc config.ServiceConfig)
+		wantStates  int
+		wantMetrics int
+	}{
+		{
+			name:        "targets unchanged",
+			remove:      func(d *OutlierDetector, pool *balancer.Pool, svc config.ServiceConfig) {},
+			wantStates:  2,
+			wantMetrics: 1,
+		},
+		{
+			name: "target removed from the pool",
+			remove: func(d *OutlierDetector, pool *balancer.Pool, svc config.ServiceConfig) {
+				pool.Update(balancer.StaticSource, []balancer.Endpoint{{URL: "http://b:8080", Weight: 1}})
+			},
+			wantStates:  1,
+			wantMetrics: 0,
+		},
+		{
+			name: "service removed",
+			remove: func(d *OutlierDetector, pool *balancer.Pool, svc config.ServiceConfig) {
+				d.Update(nil, nil)
+			},
+			wantStates:  0,
+			wantMetrics: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := outlierService("http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080")
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewOutlierDetector(&config.Config{Services: []config.ServiceConfig{svc}}, testLogger(t), map[string]*balancer.Pool{svc.Name: pool})
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := lookupTarget(t, pool, "http://a:8080")
+			b := lookupTarget(t, pool, "http://b:8080")
+
+			d.Report(svc.Name, a, OutcomeGatewayError)
+			if !a.Ejected() {
+				t.Fatal("target was not ejected")
+			}
+			d.Report(svc.Name, b, OutcomeSuccess)
+
+			tt.remove(d, pool, svc)
+			d.Report(svc.Name, b, OutcomeSuccess)
+
+			d.mu.Lock()
+			states := len(d.states)
+			d.mu.Unlock()
+			if states != tt.wantStates {
+				t.Errorf("states = %d, want %d", states, tt.wantStates)
+			}
+			if got := testutil.CollectAndCount(d.ejections); got != tt.wantMetrics {
+				t.Errorf("ejection series = %d, want %d", got, tt.wantMetrics)
+			}
+		})
+	}
+}
+
+func TestOutlierDetectorMaxEjectionPercent(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		// failing is the number of targets that fail in turn
+		failing int
+		// down lists the targets that fail their health checks
+		down        []string
+		wantEjected int
+	}{
+		{name: "single target", targets: []string{"http://a:8080"}, failing: 1, wantEjected: 0},
+		{name: "two targets", targets: []string{"http://a:8080", "http://b:8080"}, failing: 2, wantEjected: 1},
+		{name: "two targets, other one down", targets: []string{"http://a:8080", "http://b:8080"}, failing: 1, down: []string{"http://b:8080"}, wantEjected: 0},
+		{name: "three targets", targets: []string{"http://a:8080", "http://b:8080", "http://c:8080"}, failing: 3, wantEjected: 1},
+		{name: "four targets", targets: []string{"http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"}, failing: 4, wantEjected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := outlierService(tt.targets...)
+			pool, err := balancer.NewPool(svc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewOutlierDetector(&config.Config{Services: []config.ServiceConfig{svc}}, testLogger(t), map[string]*balancer.Pool{svc.Name: pool})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, url := range tt.down {
+				lookupTarget(t, pool, url).SetHealthy(false)
+			}
+			for _, url := range tt.targets[:tt.failing] {
+				d.Report(svc.Name, lookupTarget(t, pool, url), OutcomeGatewayError)
+			}
+
+			ejected := 0
+			for _, target := range pool.Targets() {
+				if target.Ejected() {
+					ejected++
+				}
+			}
+			if ejected != tt.wantEjected {
+				t.Errorf("ejected = %d, want %d", ejected, tt.wantEjected)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"fmt"
 	"strings"
 
+	"api-gateway/internal/config"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -10,67 +14,143 @@ import (
 // JWT returns a middleware that validates JWT tokens
 func JWT(secret string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Get authorization header
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return fiber.NewError(fiber.StatusUnauthorized, "Missing authorization header")
+		claims, err := parseJWT(c.Get("Authorization"), secret)
+		if err != nil {
+			return err
 		}
 
-		// Check if the header has the Bearer prefix
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid authorization header format")
-		}
+		// Store claims in context for later use
+		c.Locals("user", claims)
+
+		return c.Next()
+	}
+}
 
-		// Extract the token
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+// APIKey returns a middleware that validates API keys
+func APIKey(validKeys []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if err := checkAPIKey(c.Get("X-API-Key"), validKeys); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
 
-		// Parse and validate the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token signing method")
-			}
-			return []byte(secret), nil
-		})
+// Authenticator checks the credentials of the jwt and api_key middlewares of
+// a pipeline for calls that do not run through fiber, such as gRPC calls
+type Authenticator struct {
+	jwtSecret string
+	apiKeys   []string
+}
 
+// NewAuthenticator creates the checks of the authentication middlewares of a
+// resolved pipeline. The other middlewares only apply to HTTP requests.
+func NewAuthenticator(entries []config.MiddlewareConfig, cfg *config.Config) (*Authenticator, error) {
+	a := &Authenticator{}
+	for _, mw := range entries {
+		var err error
+		switch mw.Name {
+		case "jwt":
+			a.jwtSecret, err = jwtSecret(mw, cfg)
+		case "api_key":
+			a.apiKeys, err = apiKeys(mw, cfg)
+		}
 		if err != nil {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token: "+err.Error())
+			return nil, fmt.Errorf("middleware %s: %w", mw.Name, err)
 		}
+	}
+	return a, nil
+}
 
-		// Check if the token is valid
-		if !token.Valid {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+// Authenticate checks the credentials in the headers read by get and returns
+// the JWT claims, or nil when no JWT is required
+func (a *Authenticator) Authenticate(get func(name string) string) (jwt.MapClaims, error) {
+	var claims jwt.MapClaims
+	if a.jwtSecret != "" {
+		var err error
+		claims, err = parseJWT(get("Authorization"), a.jwtSecret)
+		if err != nil {
+			return nil, err
 		}
+	}
+	if a.apiKeys != nil {
+		if err := checkAPIKey(get("X-API-Key"), a.apiKeys); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// parseJWT validates the bearer token of an Authorization header and returns its claims
+func parseJWT(authHeader, secret string) (jwt.MapClaims, error) {
+	if authHeader == "" {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Missing authorization header")
+	}
+
+	// Check if the header has the Bearer prefix
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid authorization header format")
+	}
+
+	// Extract the token
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return fiber.NewError(fiber.StatusUnauthorized, "Invalid token claims")
+	// Parse and validate the token
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token signing method")
 		}
+		return []byte(secret), nil
+	})
 
-		// Store claims in context for later use
-		c.Locals("user", claims)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token: "+err.Error())
+	}
 
-		return c.Next()
+	// Check if the token is valid
+	if !token.Valid {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token")
+	}
+
+	// Extract claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusUnauthorized, "Invalid token claims")
 	}
+
+	return claims, nil
 }
 
-// APIKey returns a middleware that validates API keys
-func APIKey(validKeys []string) fiber.Handler {
+// checkAPIKey validates an API key against the configured keys
+func checkAPIKey(apiKey string, validKeys []string) error {
+	if apiKey == "" {
+		return fiber.NewError(fiber.StatusUnauthorized, "Missing API key")
+	}
+
+	// Check if the API key is valid
+	for _, key := range validKeys {
+		if apiKey == key {
+			return nil
+		}
+	}
+
+	return fiber.NewError(fiber.StatusUnauthorized, "Invalid API key")
+}
+
+// AdminToken returns a middleware that validates the admin API token
+func AdminToken(token string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Get API key from header
-		apiKey := c.Get("X-API-Key")
-		if apiKey == "" {
-			return fiber.NewError(fiber.StatusUnauthorized, "Missing API key")
+		// A separate header keeps the admin token apart from end-user JWTs
+		provided := c.Get("X-Admin-Token")
+		if provided == "" {
+			return fiber.NewError(fiber.StatusUnauthorized, "Missing admin token")
 		}
 
-		// Check if the API key is valid
-		for _, key := range validKeys {
-			if apiKey == key {
-				return c.Next()
-			}
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusUnauthorized, "Invalid admin token")
 		}
 
-		return fiber.NewError(fiber.StatusUnauthorized, "Invalid API key")
+		return c.Next()
 	}
 }
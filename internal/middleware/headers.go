@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Headers returns a middleware that sets and removes request headers before
+// the route is handled, and response headers after it
+func Headers(setRequest map[string]string, removeRequest []string, setResponse map[string]string, removeResponse []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, key := range removeRequest {
+			c.Request().Header.Del(key)
+		}
+		for key, value := range setRequest {
+			c.Request().Header.Set(key, value)
+		}
+
+		err := c.Next()
+
+		// Applied after the upstream headers were copied so they take precedence
+		for _, key := range removeResponse {
+			c.Response().Header.Del(key)
+		}
+		for key, value := range setResponse {
+			c.Set(key, value)
+		}
+
+		return err
+	}
+}
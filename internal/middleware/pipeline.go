@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cache"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// MaxPipelineLength is the number of middlewares a route pipeline may have
+const MaxPipelineLength = 16
+
+const (
+	defaultRateLimitWindow = 60
+	defaultCacheTTL        = 60
+)
+
+// CORS defaults, allowing WebSocket upgrades and request ID correlation
+var (
+	defaultCORSMethods       = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"}
+	defaultCORSHeaders       = []string{"Origin", "Content-Type", "Accept", "Authorization", "Connection", "Upgrade", "Sec-WebSocket-Key", "Sec-WebSocket-Version", "Sec-WebSocket-Extensions", "Sec-WebSocket-Protocol", "X-Request-ID"}
+	defaultCORSExposeHeaders = []string{"Upgrade", "Connection", "Sec-WebSocket-Accept", "Sec-WebSocket-Protocol", "X-Request-ID"}
+)
+
+// Pipeline is the ordered list of middlewares of a route
+type Pipeline struct {
+	handlers []fiber.Handler
+	closers  []func()
+}
+
+// Defaults returns the default pipeline. Without a configured one it is
+// derived from the security settings.
+func Defaults(cfg *config.Config) []config.MiddlewareConfig {
+	if len(cfg.Middleware) > 0 {
+		return cfg.Middleware
+	}
+
+	var defaults []config.MiddlewareConfig
+	if cfg.Security.EnableCORS {
+		defaults = append(defaults, config.MiddlewareConfig{Name: "cors"})
+	}
+	if cfg.Security.EnableJWT {
+		defaults = append(defaults, config.MiddlewareConfig{Name: "jwt"})
+	}
+	if cfg.Security.EnableAPIKey {
+		defaults = append(defaults, config.MiddlewareConfig{Name: "api_key"})
+	}
+	return defaults
+}
+
+// Resolve merges the middlewares of a service into the defaults. An entry
+// replaces the default of the same name in place or is appended, and
+// disabled entries are removed.
+func Resolve(defaults, overrides []config.MiddlewareConfig) ([]config.MiddlewareConfig, error) {
+	if err := checkDuplicates(defaults); err != nil {
+		return nil, err
+	}
+	if err := checkDuplicates(overrides); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]config.MiddlewareConfig, len(overrides))
+	for _, mw := range overrides {
+		byName[mw.Name] = mw
+	}
+
+	resolved := make([]config.MiddlewareConfig, 0, len(defaults)+len(overrides))
+	seen := make(map[string]struct{}, len(defaults))
+	for _, mw := range defaults {
+		seen[mw.Name] = struct{}{}
+		if override, ok := byName[mw.Name]; ok {
+			mw = override
+		}
+		if !mw.Disable {
+			resolved = append(resolved, mw)
+		}
+	}
+	for _, mw := range overrides {
+		if _, ok := seen[mw.Name]; !ok && !mw.Disable {
+			resolved = append(resolved, mw)
+		}
+	}
+
+	if len(resolved) > MaxPipelineLength {
+		return nil, fmt.Errorf("pipeline has %d middlewares, at most %d are allowed", len(resolved), MaxPipelineLength)
+	}
+	return resolved, nil
+}
+
+// checkDuplicates rejects lists that name a middleware twice
+func checkDuplicates(entries []config.MiddlewareConfig) error {
+	seen := make(map[string]struct{}, len(entries))
+	for _, mw := range entries {
+		if _, ok := seen[mw.Name]; ok {
+			return fmt.Errorf("middleware %s is listed twice", mw.Name)
+		}
+		seen[mw.Name] = struct{}{}
+	}
+	return nil
+}
+
+// NewPipeline creates the middlewares of a resolved pipeline
+func NewPipeline(entries []config.MiddlewareConfig, cfg *config.Config) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, mw := range entries {
+		handler, err := p.build(mw, cfg)
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("middleware %s: %w", mw.Name, err)
+		}
+		p.handlers = append(p.handlers, handler)
+	}
+	return p, nil
+}
+
+// build creates a single middleware
+func (p *Pipeline) build(mw config.MiddlewareConfig, cfg *config.Config) (fiber.Handler, error) {
+	switch mw.Name {
+	case "jwt":
+		secret, err := jwtSecret(mw, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return JWT(secret), nil
+
+	case "api_key":
+		keys, err := apiKeys(mw, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return APIKey(keys), nil
+
+	case "rate_limit":
+		if mw.Max <= 0 {
+			return nil, fmt.Errorf("max must be positive")
+		}
+		window := mw.Window
+		if window <= 0 {
+			window = defaultRateLimitWindow
+		}
+		limiter := NewRateLimiter(mw.Max, window)
+		p.closers = append(p.closers, limiter.Close)
+		return limiter.Handler(), nil
+
+	case "cors":
+		return cors.New(cors.Config{
+			AllowOrigins:     strings.Join(orDefault(mw.AllowOrigins, cfg.Security.CORSAllowOrigins), ","),
+			AllowMethods:     strings.Join(orDefault(mw.AllowMethods, defaultCORSMethods), ","),
+			AllowHeaders:     strings.Join(orDefault(mw.AllowHeaders, defaultCORSHeaders), ","),
+			AllowCredentials: mw.AllowCredentials,
+			ExposeHeaders:    strings.Join(orDefault(mw.ExposeHeaders, defaultCORSExposeHeaders), ","),
+			MaxAge:           mw.MaxAge,
+		}), nil
+
+	case "headers":
+		return Headers(mw.RequestHeaders, mw.RemoveRequestHeaders, mw.ResponseHeaders, mw.RemoveResponseHeaders), nil
+
+	case "security_headers":
+		return Security(), nil
+
+	case "cache":
+		ttl := mw.TTL
+		if ttl <= 0 {
+			ttl = defaultCacheTTL
+		}
+		return cache.New(cache.Config{
+			Expiration: time.Duration(ttl) * time.Second,
+			// Virtual hosts share paths, so the host is part of the key
+			KeyGenerator: func(c *fiber.Ctx) string {
+				return utils.CopyString(c.Hostname() + c.OriginalURL())
+			},
+		}), nil
+
+	default:
+		return nil, fmt.Errorf("unknown middleware")
+	}
+}
+
+// Len returns the number of middlewares in the pipeline
+func (p *Pipeline) Len() int {
+	return len(p.handlers)
+}
+
+// Handler returns the i-th middleware of the pipeline
+func (p *Pipeline) Handler(i int) fiber.Handler {
+	return p.handlers[i]
+}
+
+// Close stops background work of the middlewares
+func (p *Pipeline) Close() {
+	for _, closer := range p.closers {
+		closer()
+	}
+}
+
+// orDefault returns the values, or the defaults when none are set
+func orDefault(values, defaults []string) []string {
+	if len(values) > 0 {
+		return values
+	}
+	return defaults
+}
+
+// jwtSecret returns the secret of a jwt middleware, falling back to the security settings
+func jwtSecret(mw config.MiddlewareConfig, cfg *config.Config) (string, error) {
+	secret := mw.Secret
+	if secret == "" {
+		secret = cfg.Security.JWTSecret
+	}
+	if secret == "" {
+		return "", fmt.Errorf("no secret configured")
+	}
+	return secret, nil
+}
+
+// apiKeys returns the keys of an api_key middleware, falling back to the security settings
+func apiKeys(mw config.MiddlewareConfig, cfg *config.Config) ([]string, error) {
+	keys := orDefault(mw.Keys, cfg.Security.APIKeys)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys configured")
+	}
+	return keys, nil
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestResolve(t *testing.T) {
+	defaults := []config.MiddlewareConfig{{Name: "cors"}, {Name: "jwt"}, {Name: "rate_limit", Max: 100}}
+
+	tests := []struct {
+		name      string
+		overrides []config.MiddlewareConfig
+		want      []config.MiddlewareConfig
+		wantErr   bool
+	}{
+		{
+			name: "defaults",
+			want: defaults,
+		},
+		{
+			name:      "override kept in place",
+			overrides: []config.MiddlewareConfig{{Name: "rate_limit", Max: 10}, {Name: "cors", AllowOrigins: []string{"https://example.com"}}},
+			want:      []config.MiddlewareConfig{{Name: "cors", AllowOrigins: []string{"https://example.com"}}, {Name: "jwt"}, {Name: "rate_limit", Max: 10}},
+		},
+		{
+			name:      "new middlewares appended in order",
+			overrides: []config.MiddlewareConfig{{Name: "headers"}, {Name: "cache"}},
+			want:      []config.MiddlewareConfig{{Name: "cors"}, {Name: "jwt"}, {Name: "rate_limit", Max: 100}, {Name: "headers"}, {Name: "cache"}},
+		},
+		{
+			name:      "default disabled",
+			overrides: []config.MiddlewareConfig{{Name: "jwt", Disable: true}},
+			want:      []config.MiddlewareConfig{{Name: "cors"}, {Name: "rate_limit", Max: 100}},
+		},
+		{
+			name:      "disabled new middleware left out",
+			overrides: []config.MiddlewareConfig{{Name: "cache", Disable: true}},
+			want:      defaults,
+		},
+		{
+			name:      "middleware listed twice",
+			overrides: []config.MiddlewareConfig{{Name: "headers"}, {Name: "headers"}},
+			wantErr:   true,
+		},
+		{
+			name: "pipeline too long",
+			overrides: func() []config.MiddlewareConfig {
+				var overrides []config.MiddlewareConfig
+				for i := len(defaults); i <= MaxPipelineLength; i++ {
+					overrides = append(overrides, config.MiddlewareConfig{Name: "headers-" + string(rune('a'+i))})
+				}
+				return overrides
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(defaults, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("resolved = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -49,8 +49,11 @@ func NewRateLimiter(max int, windowSeconds int) *RateLimiter {
 
 // RateLimit returns a middleware that limits the number of requests per IP address
 func RateLimit(max int, windowSeconds int) fiber.Handler {
-	limiter := NewRateLimiter(max, windowSeconds)
+	return NewRateLimiter(max, windowSeconds).Handler()
+}
 
+// Handler returns a middleware that limits the number of requests per IP address
+func (rl *RateLimiter) Handler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get client IP
 		ip := c.IP()
@@ -59,7 +62,7 @@ func RateLimit(max int, windowSeconds int) fiber.Handler {
 		}
 
 		// Check if the IP is rate limited
-		if limiter.isLimited(ip) {
+		if rl.isLimited(ip) {
 			return fiber.NewError(fiber.StatusTooManyRequests, "Rate limit exceeded")
 		}
 
@@ -0,0 +1,395 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Tracer for gRPC proxy
+var grpcTracer = otel.Tracer("grpc-proxy")
+
+// grpcStreamDesc lets every call stream both ways, so unary and streaming
+// calls are relayed the same way
+var grpcStreamDesc = &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+
+// GRPCCodec passes gRPC messages through as raw bytes, so calls are proxied
+// without knowing their protobuf definitions
+type GRPCCodec struct{}
+
+// grpcFrame is a message relayed by the proxy
+type grpcFrame struct {
+	payload []byte
+}
+
+// Marshal returns the payload of a relayed message
+func (GRPCCodec) Marshal(v any) ([]byte, error) {
+	frame, ok := v.(*grpcFrame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return frame.payload, nil
+}
+
+// Unmarshal stores a received message for relaying
+func (GRPCCodec) Unmarshal(data []byte, v any) error {
+	frame, ok := v.(*grpcFrame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	frame.payload = append(frame.payload[:0], data...)
+	return nil
+}
+
+// Name keeps the content subtype of protobuf calls towards the targets
+func (GRPCCodec) Name() string {
+	return "proto"
+}
+
+// GRPCProxy relays gRPC calls to targets over HTTP/2
+type GRPCProxy struct {
+	config   *config.Config
+	logger   *logging.Logger
+	tls      *UpstreamTLS
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	mu sync.Mutex
+	// conns holds a connection per service and target
+	conns map[grpcConnKey]*grpcConn
+	pools map[string]*balancer.Pool
+	// generations holds the pool generation each service was last pruned at
+	generations map[string]uint64
+}
+
+// grpcConnKey identifies the connection to a target of a service
+type grpcConnKey struct {
+	service string
+	target  string
+}
+
+// grpcConn is a connection to a target with the TLS settings it was made with
+type grpcConn struct {
+	tls  *serviceTLS
+	conn *grpc.ClientConn
+}
+
+// NewGRPCProxy creates a new gRPC proxy. Connections to the targets are
+// opened on the first call and shared by later ones.
+func NewGRPCProxy(cfg *config.Config, logger *logging.Logger, upstreamTLS *UpstreamTLS) (*GRPCProxy, error) {
+	return &GRPCProxy{
+		config:      cfg,
+		logger:      logger,
+		tls:         upstreamTLS,
+		requests:    metrics.NewGRPCRequests(),
+		duration:    metrics.NewGRPCRequestDuration(),
+		conns:       make(map[grpcConnKey]*grpcConn),
+		generations: make(map[string]uint64),
+	}, nil
+}
+
+// Collectors returns the Prometheus collectors of the proxy
+func (p *GRPCProxy) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.requests, p.duration}
+}
+
+// ValidateGRPCTarget checks that a target can be reached over HTTP/2.
+// http:// and h2c:// targets are connected to in cleartext.
+func ValidateGRPCTarget(target string) error {
+	upstream, err := ParseUpstream(target)
+	if err != nil {
+		return err
+	}
+	_, _, err = grpcAddress(upstream)
+	return err
+}
+
+// grpcAddress returns the dial target of an upstream and whether it uses TLS
+func grpcAddress(upstream Upstream) (string, bool, error) {
+	switch upstream.Scheme {
+	case "http", SchemeH2C:
+		return "passthrough:///" + upstream.Host, false, nil
+	case "https":
+		return "passthrough:///" + upstream.Host, true, nil
+	case SchemeUnix:
+		return "unix://" + upstream.Socket, false, nil
+	}
+	return "", false, fmt.Errorf("unsupported scheme %q for gRPC targets", upstream.Scheme)
+}
+
+// SetServices switches the proxy to a new set of pools, and closes the
+// connections of services and targets that are no longer in them
+func (p *GRPCProxy) SetServices(pools map[string]*balancer.Pool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pools = pools
+	p.generations = make(map[string]uint64)
+	p.prune(func(service string) bool { return true })
+}
+
+// prune closes the connections of the matching services to targets that left
+// their pools. The caller holds mu.
+func (p *GRPCProxy) prune(match func(service string) bool) {
+	current := make(map[string]map[string]struct{})
+	for key, c := range p.conns {
+		if !match(key.service) {
+			continue
+		}
+		urls, ok := current[key.service]
+		if !ok {
+			urls = make(map[string]struct{})
+			if pool, ok := p.pools[key.service]; ok {
+				for _, t := range pool.Targets() {
+					urls[t.URL] = struct{}{}
+				}
+			}
+			current[key.service] = urls
+		}
+
+		if _, ok := urls[key.target]; !ok {
+			c.conn.Close()
+			delete(p.conns, key)
+		}
+	}
+}
+
+// pruneService closes the connections to targets that left the pool of a
+// service since it was last pruned. The caller holds mu.
+func (p *GRPCProxy) pruneService(service string) {
+	pool, ok := p.pools[service]
+	if !ok {
+		return
+	}
+	generation := pool.Generation()
+	if last, ok := p.generations[service]; ok && last == generation {
+		return
+	}
+	p.generations[service] = generation
+	p.prune(func(name string) bool { return name == service })
+}
+
+// Close closes the connections to the targets
+func (p *GRPCProxy) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, c := range p.conns {
+		c.conn.Close()
+		delete(p.conns, key)
+	}
+}
+
+// conn returns the connection to a target of a service. Connections made
+// with TLS settings that have since changed are replaced.
+func (p *GRPCProxy) conn(service, target string) (*grpc.ClientConn, error) {
+	st := p.tls.lookup(service)
+	key := grpcConnKey{service: service, target: target}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pruneService(service)
+	current, ok := p.conns[key]
+	if ok && current.tls == st {
+		return current.conn, nil
+	}
+
+	upstream, err := ParseUpstream(target)
+	if err != nil {
+		return nil, err
+	}
+	addr, secure, err := grpcAddress(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if secure {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if st != nil {
+			creds = serviceCredentials{TransportCredentials: credentials.NewTLS(st.current()), st: st}
+		}
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(GRPCCodec{})),
+	}
+	if upstream.Scheme == SchemeUnix {
+		opts = append(opts, grpc.WithAuthority(unixHost))
+	}
+
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		current.conn.Close()
+	}
+	p.conns[key] = &grpcConn{tls: st, conn: conn}
+	return conn, nil
+}
+
+// Forward relays a call to a target. Messages flow both ways until the
+// target ends the call, and its headers, trailers and status are passed
+// back to the client unchanged.
+func (p *GRPCProxy) Forward(stream grpc.ServerStream, service, method, target string) (err error) {
+	start := time.Now()
+	defer func() {
+		code := status.Code(err)
+		p.requests.WithLabelValues(service, method, code.String()).Inc()
+		p.duration.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+	}()
+
+	// Continue the trace of the client and pass it on to the target
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	md = md.Copy()
+	ctx := otel.GetTextMapPropagator().Extract(stream.Context(), metadataCarrier(md))
+	ctx, span := grpcTracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.method", method),
+			attribute.String("gateway.service", service),
+			attribute.String("gateway.target", target),
+		))
+	defer func() {
+		code := status.Code(err)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+		if code != codes.OK {
+			span.SetStatus(otelcodes.Error, code.String())
+		}
+		span.End()
+	}()
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+
+	conn, err := p.conn(service, target)
+	if err != nil {
+		p.logger.Error("Failed to connect to gRPC target",
+			zap.String("service", service),
+			zap.String("target", target),
+			zap.Error(err))
+		return status.Error(codes.Unavailable, "failed to connect to target")
+	}
+
+	// Cancelling the upstream call when the client goes away ends both directions
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, md))
+	defer cancel()
+
+	upstream, err := conn.NewStream(ctx, grpcStreamDesc, method)
+	if err != nil {
+		return err
+	}
+
+	go relayRequests(stream, upstream, cancel)
+
+	// The headers come before the first response, or with the status of
+	// calls that end without a response
+	header, err := upstream.Header()
+	if err == nil && len(header) > 0 {
+		if err := stream.SendHeader(header); err != nil {
+			return err
+		}
+	}
+
+	for {
+		frame := &grpcFrame{}
+		if err := upstream.RecvMsg(frame); err != nil {
+			stream.SetTrailer(upstream.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := stream.SendMsg(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// relayRequests copies the messages of the client to the target and half
+// closes the upstream call once the client is done sending
+func relayRequests(stream grpc.ServerStream, upstream grpc.ClientStream, cancel context.CancelFunc) {
+	for {
+		frame := &grpcFrame{}
+		if err := stream.RecvMsg(frame); err != nil {
+			if errors.Is(err, io.EOF) {
+				upstream.CloseSend()
+			} else {
+				cancel()
+			}
+			return
+		}
+		// A failed send means the call ended, and the status is read from
+		// the responses
+		if err := upstream.SendMsg(frame); err != nil {
+			return
+		}
+	}
+}
+
+// serviceCredentials performs TLS handshakes with the latest certificates of
+// a service, so rotated certificates apply to new connections
+type serviceCredentials struct {
+	credentials.TransportCredentials
+	st *serviceTLS
+}
+
+// ClientHandshake performs the TLS handshake with the target
+func (c serviceCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.st.current()).ClientHandshake(ctx, authority, conn)
+}
+
+// Clone returns a copy that keeps following the certificates of the service
+func (c serviceCredentials) Clone() credentials.TransportCredentials {
+	return serviceCredentials{TransportCredentials: c.TransportCredentials.Clone(), st: c.st}
+}
+
+// metadataCarrier adapts gRPC metadata for trace context propagation
+type metadataCarrier metadata.MD
+
+// Get returns the first value of a key
+func (m metadataCarrier) Get(key string) string {
+	values := metadata.MD(m).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set replaces the values of a key
+func (m metadataCarrier) Set(key, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+// Keys returns the keys of the metadata
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
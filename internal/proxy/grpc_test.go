@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+)
+
+func TestGRPCProxyClosesConnections(t *testing.T) {
+	wallet := config.ServiceConfig{Name: "wallet", Targets: []string{"http://a:9090", "http://b:9090"}}
+	users := config.ServiceConfig{Name: "users", Targets: []string{"http://c:9090"}}
+
+	tests := []struct {
+		name string
+		// change changes the pools after a connection to every target was made
+		change func(p *GRPCProxy, pools map[string]*balancer.Pool)
+		want   []string
+	}{
+		{
+			name:   "targets unchanged",
+			change: func(p *GRPCProxy, pools map[string]*balancer.Pool) {},
+			want:   []string{"users http://c:9090", "wallet http://a:9090", "wallet http://b:9090"},
+		},
+		{
+			name: "target left the pool",
+			change: func(p *GRPCProxy, pools map[string]*balancer.Pool) {
+				pools["wallet"].Update(balancer.StaticSource, []balancer.Endpoint{{URL: "http://a:9090", Weight: 1}})
+			},
+			want: []string{"users http://c:9090", "wallet http://a:9090"},
+		},
+		{
+			name: "service removed",
+			change: func(p *GRPCProxy, pools map[string]*balancer.Pool) {
+				p.SetServices(map[string]*balancer.Pool{"wallet": pools["wallet"]})
+			},
+			want: []string{"wallet http://a:9090", "wallet http://b:9090"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{Services: []config.ServiceConfig{wallet, users}}
+			upstreamTLS, err := NewUpstreamTLS(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer upstreamTLS.Close()
+			p, err := NewGRPCProxy(cfg, logger, upstreamTLS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			pools := make(map[string]*balancer.Pool)
+			for _, svc := range cfg.Services {
+				pool, err := balancer.NewPool(svc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pools[svc.Name] = pool
+			}
+			p.SetServices(pools)
+			for _, svc := range cfg.Services {
+				for _, target := range svc.Targets {
+					if _, err := p.conn(svc.Name, target); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			tt.change(p, pools)
+			// Connections of a service are pruned when a call of the service needs one
+			if _, err := p.conn("wallet", "http://a:9090"); err != nil {
+				t.Fatal(err)
+			}
+
+			p.mu.Lock()
+			var got []string
+			for key := range p.conns {
+				got = append(got, key.service+" "+key.target)
+			}
+			p.mu.Unlock()
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("connections = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
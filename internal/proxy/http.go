@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/propagation"
 	"go.uber.org/zap"
@@ -17,6 +18,7 @@ import (
 	"api-gateway/internal/config"
 	"api-gateway/pkg/cache"
 	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
 )
 
 // Tracer for HTTP proxy
@@ -24,26 +26,32 @@ var tracer = otel.Tracer("http-proxy")
 
 // HTTPProxy handles HTTP proxying
 type HTTPProxy struct {
-	client    *http.Client
-	config    *config.Config
-	logger    *logging.Logger
-	cache     *cache.Cache
+	client         *http.Client
+	transports     *Transports
+	config         *config.Config
+	logger         *logging.Logger
+	cache          *cache.Cache
+	tls            *UpstreamTLS
+	mirrorRequests *prometheus.CounterVec
+	mirrorDuration *prometheus.HistogramVec
+
+	mu      sync.RWMutex
+	mirrors map[string]*mirror
+	// clients holds the clients of services with their own upstream TLS settings
+	clients map[string]*tlsClient
 }
 
-// NewHTTPProxy creates a new HTTP proxy
-func NewHTTPProxy(cfg *config.Config, logger *logging.Logger) (*HTTPProxy, error) {
-	// Create HTTP client with custom transport
-	transport := &http.Transport{
-		MaxIdleConns:        cfg.Proxy.MaxIdleConns,
-		IdleConnTimeout:     time.Duration(cfg.Proxy.IdleConnTimeout) * time.Second,
-		TLSHandshakeTimeout: 10 * time.Second,
-		DisableCompression:  false,
-	}
+// tlsClient is an HTTP client that connects with the TLS settings of a service
+type tlsClient struct {
+	tls    *serviceTLS
+	client *http.Client
+}
 
-	client := &http.Client{
-		Transport: transport,
-		Timeout:   time.Duration(cfg.Proxy.Timeout) * time.Second,
-	}
+// NewHTTPProxy creates a new HTTP proxy. Services with upstream TLS settings
+// get a client of their own, and Unix socket and h2c targets separate pools.
+func NewHTTPProxy(cfg *config.Config, logger *logging.Logger, upstreamTLS *UpstreamTLS) (*HTTPProxy, error) {
+	// Create HTTP client with custom transport
+	client := newHTTPClient(cfg, nil)
 
 	// Create cache if enabled
 	// TODO: Cache change to redis from in-memory cache
@@ -56,16 +64,103 @@ func NewHTTPProxy(cfg *config.Config, logger *logging.Logger) (*HTTPProxy, error
 		}
 	}
 
-	return &HTTPProxy{
-		client: client,
-		config: cfg,
-		logger: logger,
-		cache:  c,
-	}, nil
+	p := &HTTPProxy{
+		client:         client,
+		transports:     NewTransports(func() *http.Transport { return newTransport(cfg) }),
+		config:         cfg,
+		logger:         logger,
+		cache:          c,
+		tls:            upstreamTLS,
+		mirrorRequests: metrics.NewMirrorRequests(),
+		mirrorDuration: metrics.NewMirrorRequestDuration(),
+	}
+
+	// Create shadow traffic mirrors
+	if err := p.SetServices(cfg.Services); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
-// Forward forwards an HTTP request to the target service
-func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.ServiceConfig, cfg *config.Config) error {
+// newHTTPClient creates a client for the targets, dialing TLS connections
+// with the settings of a service when they are given
+func newHTTPClient(cfg *config.Config, st *serviceTLS) *http.Client {
+	transport := newTransport(cfg)
+	if st != nil {
+		transport.DialTLSContext = st.dial
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.Proxy.Timeout) * time.Second,
+	}
+}
+
+// newTransport creates a transport with the pool settings of the proxy
+func newTransport(cfg *config.Config) *http.Transport {
+	return &http.Transport{
+		MaxIdleConns:        cfg.Proxy.MaxIdleConns,
+		IdleConnTimeout:     time.Duration(cfg.Proxy.IdleConnTimeout) * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableCompression:  false,
+	}
+}
+
+// SetServices replaces the shadow traffic mirrors and the upstream TLS
+// clients with those of the given services. Nothing changes when a mirror is
+// invalid. The upstream TLS settings must be updated first.
+func (p *HTTPProxy) SetServices(services []config.ServiceConfig) error {
+	mirrors := make(map[string]*mirror)
+	for _, svc := range services {
+		m, err := newMirror(svc, p.config, p.logger, p.mirrorRequests, p.mirrorDuration)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		if m != nil {
+			mirrors[svc.Name] = m
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Clients whose TLS settings did not change keep their connections
+	clients := make(map[string]*tlsClient)
+	for _, svc := range services {
+		st := p.tls.lookup(svc.Name)
+		if st == nil {
+			continue
+		}
+		if current, ok := p.clients[svc.Name]; ok && current.tls == st {
+			clients[svc.Name] = current
+			continue
+		}
+		clients[svc.Name] = &tlsClient{tls: st, client: newHTTPClient(p.config, st)}
+	}
+
+	// Requests in flight keep their connections until they finish
+	for _, m := range p.mirrors {
+		m.client.CloseIdleConnections()
+	}
+	for service, current := range p.clients {
+		if clients[service] != current {
+			current.client.CloseIdleConnections()
+		}
+	}
+	p.mirrors = mirrors
+	p.clients = clients
+
+	return nil
+}
+
+// Collectors returns the Prometheus collectors of the proxy
+func (p *HTTPProxy) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.mirrorRequests, p.mirrorDuration}
+}
+
+// Forward forwards an HTTP request to the target service. A non-empty host
+// replaces the target host in the Host header.
+func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path, host string, svc config.ServiceConfig, cfg *config.Config) error {
 	// Skip WebSocket requests - they should be handled by the WebSocket proxy
 	if c.Get("Upgrade") == "websocket" {
 		// p.logger.Debug("Skipping WebSocket request in HTTP proxy",
@@ -78,33 +173,17 @@ func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.Servic
 	ctx, span := tracer.Start(c.UserContext(), cfg.Tracing.ServiceName)
 	defer span.End()
 
-	// Check if response is in cache - TODO: Cache change to redis from in-memory cache
-	if p.config.Proxy.EnableCache && p.cache != nil && c.Method() == fiber.MethodGet {
-		queryString := c.Request().URI().QueryString()
-		cacheKey := getCacheKey(c.Path(), string(queryString))
-		if cachedResp, found := p.cache.Get(cacheKey); found {
-			// p.logger.Debug("Cache hit", "path", c.Path(), "service", svc.Name)
-			return c.Send(cachedResp.([]byte))
-		}
-	}
-
 	// Parse target URL
-	targetURL, err := url.Parse(target)
+	upstream, err := ParseUpstream(target)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "invalid target URL")
 	}
 
 	// Create the request URL
-	requestURL := fmt.Sprintf("%s://%s", targetURL.Scheme, targetURL.Host)
-	if targetURL.Path != "" && targetURL.Path != "/" {
-		requestURL = fmt.Sprintf("%s%s", requestURL, targetURL.Path)
-	}
-	if path != "" {
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
-		}
-		requestURL = fmt.Sprintf("%s%s", requestURL, path)
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
 	}
+	requestURL := upstream.URL(path)
 	queryString := c.Request().URI().QueryString()
 	if len(queryString) > 0 {
 		requestURL = fmt.Sprintf("%s?%s", requestURL, string(queryString))
@@ -122,7 +201,10 @@ func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.Servic
 	})
 
 	// Set host header
-	req.Host = targetURL.Host
+	req.Host = upstream.Host
+	if host != "" {
+		req.Host = host
+	}
 
 	// Add custom headers from service config
 	for key, value := range svc.Headers {
@@ -132,9 +214,33 @@ func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.Servic
 	// Propagate trace context to outgoing request
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
+	// Copy the request to the shadow service, without waiting for it
+	p.mu.RLock()
+	m, ok := p.mirrors[svc.Name]
+	client := p.client
+	if tc, ok := p.clients[svc.Name]; ok {
+		client = tc.client
+	}
+	p.mu.RUnlock()
+	if rt := p.transports.RoundTripper(upstream); rt != nil {
+		client = &http.Client{Transport: rt, Timeout: p.client.Timeout}
+	}
+	if ok {
+		m.send(c, req, path)
+	}
+
+	// Check if response is in cache, after mirroring so cache hits are mirrored too - TODO: Cache change to redis from in-memory cache
+	if p.config.Proxy.EnableCache && p.cache != nil && c.Method() == fiber.MethodGet {
+		cacheKey := getCacheKey(svc.Name, c.Hostname(), c.Path(), string(queryString))
+		if cachedResp, found := p.cache.Get(cacheKey); found {
+			// p.logger.Debug("Cache hit", "path", c.Path(), "service", svc.Name)
+			return c.Send(cachedResp.([]byte))
+		}
+	}
+
 	// Execute the request
 	start := time.Now()
-	resp, err := p.client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return fiber.NewError(fiber.StatusBadGateway, "failed to execute request")
 	}
@@ -158,7 +264,7 @@ func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.Servic
 
 	// Cache the response if enabled
 	if p.config.Proxy.EnableCache && p.cache != nil && c.Method() == fiber.MethodGet && resp.StatusCode == fiber.StatusOK {
-		cacheKey := getCacheKey(c.Path(), string(queryString))
+		cacheKey := getCacheKey(svc.Name, c.Hostname(), c.Path(), string(queryString))
 		p.cache.Set(cacheKey, body)
 	}
 
@@ -176,11 +282,12 @@ func (p *HTTPProxy) Forward(c *fiber.Ctx, target, path string, svc config.Servic
 	return c.Send(body)
 }
 
-// getCacheKey generates a cache key from path and query
-func getCacheKey(path, query string) string {
+// getCacheKey generates a cache key from the matched service, host, path and
+// query, so requests that hosts or predicates route to different services do
+// not share responses
+func getCacheKey(service, host, path, query string) string {
 	if query != "" {
-		return fmt.Sprintf("%s?%s", path, query)
+		return fmt.Sprintf("%s|%s%s?%s", service, host, path, query)
 	}
-	return path
+	return service + "|" + host + path
 }
-
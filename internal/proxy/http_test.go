@@ -0,0 +1,27 @@
+package proxy
+
+import "testing"
+
+func TestGetCacheKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		host    string
+		path    string
+		query   string
+		want    string
+	}{
+		{name: "path only", service: "users", host: "a.example.com", path: "/users", want: "users|a.example.com/users"},
+		{name: "with query", service: "users", host: "a.example.com", path: "/users", query: "page=2", want: "users|a.example.com/users?page=2"},
+		{name: "other host", service: "users", host: "b.example.com", path: "/users", want: "users|b.example.com/users"},
+		{name: "other route", service: "users-beta", host: "a.example.com", path: "/users", want: "users-beta|a.example.com/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getCacheKey(tt.service, tt.host, tt.path, tt.query); got != tt.want {
+				t.Errorf("getCacheKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
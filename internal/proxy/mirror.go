@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// MirrorHeader marks requests sent to a shadow service
+const MirrorHeader = "X-Shadow-Request"
+
+const (
+	defaultMirrorMaxInflight = 100
+
+	// mirroredKey marks a request as mirrored so retries are not mirrored again
+	mirroredKey = "mirrored"
+)
+
+// Mirror outcomes used as metric labels
+const (
+	mirrorSuccess     = "success"
+	mirrorServerError = "server_error"
+	mirrorError       = "error"
+	mirrorDropped     = "dropped"
+)
+
+// mirror sends copies of a share of a service's requests to a shadow target
+type mirror struct {
+	service string
+	target  *url.URL
+	percent float64
+	client  *http.Client
+	logger  *logging.Logger
+	// inflight bounds the concurrent shadow requests
+	inflight chan struct{}
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// newMirror creates the mirror of a service, or nil when mirroring is not configured
+func newMirror(svc config.ServiceConfig, cfg *config.Config, logger *logging.Logger, requests *prometheus.CounterVec, duration *prometheus.HistogramVec) (*mirror, error) {
+	mc := svc.Mirror
+	if mc.Target == "" {
+		return nil, nil
+	}
+
+	target, err := url.Parse(mc.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid mirror target %q", mc.Target)
+	}
+	if mc.Percent <= 0 || mc.Percent > 100 {
+		return nil, fmt.Errorf("mirror percent must be within 0 and 100")
+	}
+
+	timeout := mc.Timeout
+	if timeout <= 0 {
+		timeout = cfg.Proxy.Timeout
+	}
+	maxInflight := mc.MaxInflight
+	if maxInflight <= 0 {
+		maxInflight = defaultMirrorMaxInflight
+	}
+
+	return &mirror{
+		service: svc.Name,
+		target:  target,
+		percent: mc.Percent,
+		// A separate transport keeps shadow connections out of the upstream pool
+		client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        maxInflight,
+				MaxIdleConnsPerHost: maxInflight,
+				IdleConnTimeout:     time.Duration(cfg.Proxy.IdleConnTimeout) * time.Second,
+				TLSHandshakeTimeout: 10 * time.Second,
+			},
+			Timeout: time.Duration(timeout) * time.Second,
+		},
+		logger:   logger,
+		inflight: make(chan struct{}, maxInflight),
+		requests: requests,
+		duration: duration,
+	}, nil
+}
+
+// send mirrors the upstream request in the background. The request and body
+// are copied first, since fiber reuses them once the handler returns.
+func (m *mirror) send(c *fiber.Ctx, req *http.Request, path string) {
+	if c.Locals(mirroredKey) != nil {
+		return
+	}
+	c.Locals(mirroredKey, true)
+
+	if rand.Float64()*100 >= m.percent {
+		return
+	}
+
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		m.requests.WithLabelValues(m.service, mirrorDropped).Inc()
+		return
+	}
+
+	// The shadow service gets the same upstream path as the target
+	shadowURL := *m.target
+	shadowURL.Path = strings.TrimSuffix(m.target.Path, "/") + path
+	shadowURL.RawQuery = req.URL.RawQuery
+
+	// The shadow request must outlive the client request
+	shadow, err := http.NewRequestWithContext(context.Background(), req.Method, shadowURL.String(), bytes.NewReader(bytes.Clone(c.Body())))
+	if err != nil {
+		<-m.inflight
+		m.requests.WithLabelValues(m.service, mirrorError).Inc()
+		return
+	}
+	shadow.Header = req.Header.Clone()
+	shadow.Header.Set(MirrorHeader, "true")
+	shadow.Host = m.target.Host
+
+	go m.do(shadow)
+}
+
+// do executes a shadow request and discards its response
+func (m *mirror) do(req *http.Request) {
+	defer func() { <-m.inflight }()
+
+	start := time.Now()
+	resp, err := m.client.Do(req)
+	if err != nil {
+		m.duration.WithLabelValues(m.service, mirrorError).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(m.service, mirrorError).Inc()
+		m.logger.Debug("Shadow request failed",
+			zap.String("service", m.service),
+			zap.String("target", req.URL.String()),
+			zap.Error(err))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	outcome := mirrorSuccess
+	if resp.StatusCode >= http.StatusInternalServerError {
+		outcome = mirrorServerError
+	}
+	m.duration.WithLabelValues(m.service, outcome).Observe(time.Since(start).Seconds())
+	m.requests.WithLabelValues(m.service, outcome).Inc()
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewMirror(t *testing.T) {
+	tests := []struct {
+		name    string
+		mirror  config.MirrorConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "not configured", wantNil: true},
+		{name: "valid", mirror: config.MirrorConfig{Target: "http://shadow:8080", Percent: 10}},
+		{name: "invalid scheme", mirror: config.MirrorConfig{Target: "ftp://shadow", Percent: 10}, wantErr: true},
+		{name: "missing host", mirror: config.MirrorConfig{Target: "http://", Percent: 10}, wantErr: true},
+		{name: "zero percent", mirror: config.MirrorConfig{Target: "http://shadow:8080"}, wantErr: true},
+		{name: "percent above 100", mirror: config.MirrorConfig{Target: "http://shadow:8080", Percent: 101}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := config.ServiceConfig{Name: "api", Mirror: tt.mirror}
+			m, err := newMirror(svc, &config.Config{}, logger, metrics.NewMirrorRequests(), metrics.NewMirrorRequestDuration())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (m == nil) != tt.wantNil {
+				t.Errorf("mirror = %v, want nil %v", m, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestMirrorOutcomes(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		// down closes the shadow service before the request is mirrored
+		down bool
+		want string
+	}{
+		{name: "success", status: http.StatusOK, want: mirrorSuccess},
+		{name: "client error", status: http.StatusNotFound, want: mirrorSuccess},
+		{name: "server error", status: http.StatusBadGateway, want: mirrorServerError},
+		{name: "shadow service down", down: true, want: mirrorError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(MirrorHeader) != "true" {
+					t.Errorf("%s header = %q", MirrorHeader, r.Header.Get(MirrorHeader))
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer shadow.Close()
+			if tt.down {
+				shadow.Close()
+			}
+
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			requests, duration := metrics.NewMirrorRequests(), metrics.NewMirrorRequestDuration()
+			svc := config.ServiceConfig{Name: "api", Mirror: config.MirrorConfig{Target: shadow.URL, Percent: 100, MaxInflight: 1}}
+			m, err := newMirror(svc, &config.Config{}, logger, requests, duration)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := fiber.New()
+			app.Get("/*", func(c *fiber.Ctx) error {
+				req, err := http.NewRequest(c.Method(), "http://upstream"+c.Path(), nil)
+				if err != nil {
+					return err
+				}
+				m.send(c, req, c.Path())
+				return nil
+			})
+			if _, err := app.Test(httptest.NewRequest(http.MethodGet, "/users", nil)); err != nil {
+				t.Fatal(err)
+			}
+
+			// The only inflight slot frees up once the shadow request is done
+			select {
+			case m.inflight <- struct{}{}:
+			case <-time.After(5 * time.Second):
+				t.Fatal("shadow request not finished")
+			}
+
+			if got := testutil.ToFloat64(requests.WithLabelValues("api", tt.want)); got != 1 {
+				t.Errorf("%s requests = %v, want 1", tt.want, got)
+			}
+			if got := testutil.CollectAndCount(duration); got != 1 {
+				t.Fatalf("duration series = %d, want 1", got)
+			}
+			if _, err := duration.GetMetricWithLabelValues("api", tt.want); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyMirrorsCachedRequests(t *testing.T) {
+	var upstreamHits atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		w.Write([]byte("users"))
+	}))
+	defer upstream.Close()
+
+	mirrored := make(chan struct{}, 2)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- struct{}{}
+	}))
+	defer shadow.Close()
+
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := config.ServiceConfig{Name: "api", Mirror: config.MirrorConfig{Target: shadow.URL, Percent: 100}}
+	cfg := &config.Config{
+		Proxy:    config.ProxyConfig{Timeout: 5, EnableCache: true, CacheTTL: 60},
+		Services: []config.ServiceConfig{svc},
+	}
+	upstreamTLS, err := NewUpstreamTLS(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstreamTLS.Close()
+	p, err := NewHTTPProxy(cfg, logger, upstreamTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return p.Forward(c, upstream.URL, c.Path(), "", svc, cfg)
+	})
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/users", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	if got := upstreamHits.Load(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1", got)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-mirrored:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("mirrored requests = %d, want 2", i)
+		}
+	}
+}
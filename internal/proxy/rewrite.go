@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"api-gateway/internal/config"
+)
+
+// Rewriter maps the path and host of a request onto the upstream path and
+// Host header of a service. It is shared by the HTTP and WebSocket proxies.
+type Rewriter struct {
+	service     string
+	basePath    string
+	prefix      string
+	keepBase    bool
+	regex       *regexp.Regexp
+	replacement string
+	host        string
+}
+
+// NewRewriter creates the rewriter for a service. A path regex takes
+// precedence over the prefix; paths it does not match fall back to the prefix.
+// Without a prefix, strip_base_path removes the base path.
+func NewRewriter(svc config.ServiceConfig) (*Rewriter, error) {
+	cfg := svc.Rewrite
+
+	basePath := "/" + strings.Trim(svc.BasePath, "/")
+	rw := &Rewriter{
+		service:     svc.Name,
+		basePath:    strings.TrimSuffix(basePath, "/"),
+		prefix:      strings.TrimSuffix(cfg.Prefix, "/"),
+		keepBase:    cfg.Prefix == "" && !svc.StripBasePath,
+		replacement: cfg.Replacement,
+		host:        cfg.Host,
+	}
+
+	if cfg.Prefix != "" && !strings.HasPrefix(cfg.Prefix, "/") {
+		return nil, fmt.Errorf("rewrite prefix %q must start with /", cfg.Prefix)
+	}
+	if cfg.Regex != "" {
+		re, err := regexp.Compile(cfg.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite regex: %w", err)
+		}
+		rw.regex = re
+	} else if cfg.Replacement != "" {
+		return nil, fmt.Errorf("rewrite replacement requires a regex")
+	}
+
+	return rw, nil
+}
+
+// Rewrite returns the upstream path for a request path, and the Host header
+// to send upstream, which is empty when the target host should be used
+func (rw *Rewriter) Rewrite(path, host string) (string, string) {
+	upstream, vars := rw.rewrite(path, host)
+	if rw.host == "" {
+		return upstream, ""
+	}
+
+	// Keep the target host when the template refers to a capture the path did not provide
+	missing := false
+	host = os.Expand(rw.host, func(key string) string {
+		value, ok := vars[key]
+		if !ok {
+			missing = true
+		}
+		return value
+	})
+	if missing {
+		return upstream, ""
+	}
+	return upstream, host
+}
+
+// Expand fills a template with the rewritten path as ${path}, the request
+// host, the service name and the captures of the path regex
+func (rw *Rewriter) Expand(tmpl, path, host string) string {
+	upstream, vars := rw.rewrite(path, host)
+	vars["path"] = upstream
+	return os.Expand(tmpl, func(key string) string {
+		return vars[key]
+	})
+}
+
+// rewrite returns the upstream path and the template variables of a request
+func (rw *Rewriter) rewrite(path, host string) (string, map[string]string) {
+	vars := map[string]string{
+		"host":    host,
+		"service": rw.service,
+	}
+
+	upstream := ""
+	matched := false
+	if rw.regex != nil {
+		if m := rw.regex.FindStringSubmatchIndex(path); m != nil {
+			matched = true
+			upstream = string(rw.regex.ExpandString(nil, rw.replacement, path, m))
+			for i, name := range rw.regex.SubexpNames() {
+				if i == 0 || m[2*i] < 0 {
+					continue
+				}
+				value := path[m[2*i]:m[2*i+1]]
+				vars[fmt.Sprint(i)] = value
+				if name != "" {
+					vars[name] = value
+				}
+			}
+		}
+	}
+
+	if !matched {
+		upstream = path
+		if !rw.keepBase {
+			if rest, ok := strings.CutPrefix(path, rw.basePath); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+				upstream = rw.prefix + rest
+			}
+		}
+	}
+	if !strings.HasPrefix(upstream, "/") {
+		upstream = "/" + upstream
+	}
+	return upstream, vars
+}
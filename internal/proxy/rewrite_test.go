@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestRewriterRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		svc      config.ServiceConfig
+		path     string
+		host     string
+		wantPath string
+		wantHost string
+	}{
+		{
+			name:     "full path without strip",
+			svc:      config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer"},
+			path:     "/games/ice-age-royal/consumer/foo",
+			wantPath: "/games/ice-age-royal/consumer/foo",
+		},
+		{
+			name:     "strip base path",
+			svc:      config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path:     "/games/ice-age-royal/consumer/foo",
+			wantPath: "/foo",
+		},
+		{
+			name:     "strip exact base path",
+			svc:      config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path:     "/games/ice-age-royal/consumer",
+			wantPath: "/",
+		},
+		{
+			name:     "strip keeps socket.io in the path",
+			svc:      config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path:     "/games/ice-age-royal/consumer/socket.io/",
+			wantPath: "/socket.io/",
+		},
+		{
+			name:     "strip only matches whole segments",
+			svc:      config.ServiceConfig{BasePath: "/api", StripBasePath: true},
+			path:     "/apis/foo",
+			wantPath: "/apis/foo",
+		},
+		{
+			name: "prefix replaces base path",
+			svc: config.ServiceConfig{
+				BasePath: "/api",
+				Rewrite:  config.RewriteConfig{Prefix: "/v2/"},
+			},
+			path:     "/api/users",
+			wantPath: "/v2/users",
+		},
+		{
+			name: "regex with named captures",
+			svc: config.ServiceConfig{
+				BasePath: "/games",
+				Rewrite: config.RewriteConfig{
+					Regex:       "^/games/(?P<game>[^/]+)/api(/.*)?$",
+					Replacement: "/v1/${game}$2",
+				},
+			},
+			path:     "/games/ice-age-royal/api/bets",
+			wantPath: "/v1/ice-age-royal/bets",
+		},
+		{
+			name: "regex miss falls back to strip",
+			svc: config.ServiceConfig{
+				BasePath:      "/games",
+				StripBasePath: true,
+				Rewrite: config.RewriteConfig{
+					Regex:       "^/games/(?P<game>[^/]+)/api(/.*)?$",
+					Replacement: "/v1/${game}$2",
+				},
+			},
+			path:     "/games/lobby",
+			wantPath: "/lobby",
+		},
+		{
+			name: "host template",
+			svc: config.ServiceConfig{
+				BasePath: "/games",
+				Rewrite: config.RewriteConfig{
+					Regex:       "^/games/(?P<game>[^/]+)(/.*)?$",
+					Replacement: "$2",
+					Host:        "${game}.internal",
+				},
+			},
+			path:     "/games/ice-age-royal/bets",
+			host:     "gateway.example.com",
+			wantPath: "/bets",
+			wantHost: "ice-age-royal.internal",
+		},
+		{
+			name: "host template with missing capture keeps target host",
+			svc: config.ServiceConfig{
+				BasePath:      "/games",
+				StripBasePath: true,
+				Rewrite: config.RewriteConfig{
+					Regex:       "^/games/(?P<game>[^/]+)/api(/.*)?$",
+					Replacement: "$2",
+					Host:        "${game}.internal",
+				},
+			},
+			path:     "/games",
+			wantPath: "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := NewRewriter(tt.svc)
+			if err != nil {
+				t.Fatalf("NewRewriter: %v", err)
+			}
+			path, host := rw.Rewrite(tt.path, tt.host)
+			if path != tt.wantPath {
+				t.Errorf("path = %q, want %q", path, tt.wantPath)
+			}
+			if host != tt.wantHost {
+				t.Errorf("host = %q, want %q", host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestNewRewriterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite config.RewriteConfig
+	}{
+		{name: "relative prefix", rewrite: config.RewriteConfig{Prefix: "v2"}},
+		{name: "invalid regex", rewrite: config.RewriteConfig{Regex: "("}},
+		{name: "replacement without regex", rewrite: config.RewriteConfig{Replacement: "/x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRewriter(config.ServiceConfig{BasePath: "/api", Rewrite: tt.rewrite}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
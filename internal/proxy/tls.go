@@ -0,0 +1,451 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// certDebounce groups the burst of events produced by a single certificate rotation
+const certDebounce = 100 * time.Millisecond
+
+// tlsVersions maps the configured minimum versions to their TLS constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// UpstreamTLS holds the TLS settings of the services for connections to their
+// targets. The HTTP and WebSocket proxies share it, so both verify targets
+// the same way.
+type UpstreamTLS struct {
+	logger *logging.Logger
+
+	mu       sync.RWMutex
+	services map[string]*serviceTLS
+}
+
+// NewUpstreamTLS loads the upstream TLS settings of the services
+func NewUpstreamTLS(cfg *config.Config, logger *logging.Logger) (*UpstreamTLS, error) {
+	u := &UpstreamTLS{
+		logger:   logger,
+		services: make(map[string]*serviceTLS),
+	}
+	if err := u.Update(cfg.Services); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Update switches to the TLS settings of a new set of services. Unchanged
+// settings keep their certificates and connections, and nothing changes when
+// a certificate cannot be loaded.
+func (u *UpstreamTLS) Update(services []config.ServiceConfig) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	next := make(map[string]*serviceTLS)
+	var created []*serviceTLS
+	for _, svc := range services {
+		if !tlsConfigured(svc.TLS) {
+			continue
+		}
+		if current, ok := u.services[svc.Name]; ok && reflect.DeepEqual(current.settings, svc.TLS) {
+			next[svc.Name] = current
+			continue
+		}
+
+		st, err := newServiceTLS(svc.Name, svc.TLS, u.logger)
+		if err != nil {
+			for _, c := range created {
+				c.close()
+			}
+			return fmt.Errorf("invalid upstream TLS for service %s: %w", svc.Name, err)
+		}
+		created = append(created, st)
+		next[svc.Name] = st
+	}
+
+	// Stop watching the certificates of settings that were removed or replaced
+	for service, current := range u.services {
+		if next[service] != current {
+			current.close()
+		}
+	}
+	u.services = next
+
+	return nil
+}
+
+// lookup returns the TLS settings of a service, or nil when the service uses the defaults
+func (u *UpstreamTLS) lookup(service string) *serviceTLS {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.services[service]
+}
+
+// Close stops watching certificate files
+func (u *UpstreamTLS) Close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, st := range u.services {
+		st.close()
+	}
+	u.services = nil
+}
+
+// TLSTransports keeps a transport per service with upstream TLS settings for
+// clients outside the proxies, such as health probes. A transport is replaced
+// when the settings of its service change.
+type TLSTransports struct {
+	tls          *UpstreamTLS
+	newTransport func() *http.Transport
+
+	mu         sync.Mutex
+	transports map[string]*tlsTransport
+}
+
+// tlsTransport is a transport that connects with the TLS settings of a service
+type tlsTransport struct {
+	tls       *serviceTLS
+	transport *http.Transport
+}
+
+// NewTLSTransports creates the transports of the services from a template
+func NewTLSTransports(upstreamTLS *UpstreamTLS, newTransport func() *http.Transport) *TLSTransports {
+	return &TLSTransports{
+		tls:          upstreamTLS,
+		newTransport: newTransport,
+		transports:   make(map[string]*tlsTransport),
+	}
+}
+
+// RoundTripper returns the transport of a service with its own TLS settings,
+// or nil when the service uses the defaults
+func (t *TLSTransports) RoundTripper(service string) http.RoundTripper {
+	st := t.tls.lookup(service)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, ok := t.transports[service]
+	if ok && current.tls == st {
+		return current.transport
+	}
+	if ok {
+		current.transport.CloseIdleConnections()
+		delete(t.transports, service)
+	}
+	if st == nil {
+		return nil
+	}
+
+	transport := t.newTransport()
+	transport.DialTLSContext = st.dial
+	t.transports[service] = &tlsTransport{tls: st, transport: transport}
+	return transport
+}
+
+// SetServices drops the transports of services that were removed or whose
+// TLS settings changed. The upstream TLS settings must be updated first.
+func (t *TLSTransports) SetServices(services []config.ServiceConfig) {
+	settings := make(map[string]*serviceTLS, len(services))
+	for _, svc := range services {
+		settings[svc.Name] = t.tls.lookup(svc.Name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for service, current := range t.transports {
+		if st := settings[service]; st != current.tls {
+			current.transport.CloseIdleConnections()
+			delete(t.transports, service)
+		}
+	}
+}
+
+// tlsConfigured reports whether a service has its own upstream TLS settings
+func tlsConfigured(cfg config.UpstreamTLSConfig) bool {
+	return cfg.CAFile != "" || cfg.CertFile != "" || cfg.KeyFile != "" || cfg.ServerName != "" ||
+		cfg.MinVersion != "" || len(cfg.CipherSuites) > 0 || cfg.InsecureSkipVerify
+}
+
+// serviceTLS is the TLS client configuration of a service. It is replaced
+// whenever one of its certificate files changes.
+type serviceTLS struct {
+	service  string
+	settings config.UpstreamTLSConfig
+	logger   *logging.Logger
+	dialer   net.Dialer
+	watcher  *fsnotify.Watcher
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu     sync.RWMutex
+	config *tls.Config
+	files  certFiles
+}
+
+// certFiles holds the contents of the certificate files of a service
+type certFiles struct {
+	ca   []byte
+	cert []byte
+	key  []byte
+}
+
+// newServiceTLS validates the settings, loads the certificates and starts watching their files
+func newServiceTLS(service string, settings config.UpstreamTLSConfig, logger *logging.Logger) (*serviceTLS, error) {
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		return nil, fmt.Errorf("cert_file and key_file must be set together")
+	}
+
+	st := &serviceTLS{
+		service:  service,
+		settings: settings,
+		logger:   logger,
+		dialer:   net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+	if _, err := st.reload(); err != nil {
+		return nil, err
+	}
+
+	files := st.paths()
+	if len(files) == 0 {
+		return st, nil
+	}
+
+	// Watch the directories so files replaced by rename, such as mounted
+	// Kubernetes Secrets, keep being picked up
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate watcher: %w", err)
+	}
+	dirs := make(map[string]struct{})
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		dirs[dir] = struct{}{}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", file, err)
+		}
+	}
+	st.watcher = watcher
+	st.stop = make(chan struct{})
+	st.done = make(chan struct{})
+	go st.run()
+
+	return st, nil
+}
+
+// paths returns the certificate files of the settings
+func (st *serviceTLS) paths() []string {
+	var files []string
+	for _, file := range []string{st.settings.CAFile, st.settings.CertFile, st.settings.KeyFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// run reloads the certificates on changes until the settings are closed
+func (st *serviceTLS) run() {
+	defer close(st.done)
+	defer st.watcher.Close()
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-st.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			debounce = time.After(certDebounce)
+		case err, ok := <-st.watcher.Errors:
+			if !ok {
+				return
+			}
+			st.logger.Warn("Certificate watcher error",
+				zap.String("service", st.service),
+				zap.Error(err))
+		case <-debounce:
+			debounce = nil
+			changed, err := st.reload()
+			if err != nil {
+				// Keep the previous certificates until the files are valid again
+				st.logger.Error("Failed to reload upstream TLS certificates",
+					zap.String("service", st.service),
+					zap.Error(err))
+			} else if changed {
+				st.logger.Info("Upstream TLS certificates reloaded",
+					zap.String("service", st.service))
+			}
+		case <-st.stop:
+			return
+		}
+	}
+}
+
+// reload reads the certificate files and builds a new client configuration,
+// reporting whether any of the files changed
+func (st *serviceTLS) reload() (bool, error) {
+	var files certFiles
+	for _, f := range []struct {
+		path    string
+		content *[]byte
+	}{
+		{st.settings.CAFile, &files.ca},
+		{st.settings.CertFile, &files.cert},
+		{st.settings.KeyFile, &files.key},
+	} {
+		if f.path == "" {
+			continue
+		}
+		content, err := os.ReadFile(f.path)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", f.path, err)
+		}
+		*f.content = content
+	}
+
+	st.mu.RLock()
+	unchanged := st.config != nil && files.equal(st.files)
+	st.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cfg, err := st.build(files)
+	if err != nil {
+		return false, err
+	}
+
+	st.mu.Lock()
+	st.config = cfg
+	st.files = files
+	st.mu.Unlock()
+
+	return true, nil
+}
+
+// build creates the client configuration from the settings and certificate files
+func (st *serviceTLS) build(files certFiles) (*tls.Config, error) {
+	s := st.settings
+	cfg := &tls.Config{
+		ServerName:         s.ServerName,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: s.InsecureSkipVerify,
+	}
+
+	if s.MinVersion != "" {
+		version, ok := tlsVersions[s.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", s.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if len(s.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, suite := range tls.CipherSuites() {
+			suites[suite.Name] = suite.ID
+		}
+		for _, name := range s.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if s.CAFile != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(files.ca) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", s.CAFile)
+		}
+		cfg.RootCAs = roots
+	}
+
+	if s.CertFile != "" {
+		cert, err := tls.X509KeyPair(files.cert, files.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// current returns the latest client configuration
+func (st *serviceTLS) current() *tls.Config {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.config
+}
+
+// dial opens a TLS connection to a target with the latest certificates, so
+// rotated certificates apply to new connections without dropping open ones
+func (st *serviceTLS) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	cfg := st.current().Clone()
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+
+	conn, err := st.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// close stops watching the certificate files
+func (st *serviceTLS) close() {
+	if st.stop == nil {
+		return
+	}
+	close(st.stop)
+	<-st.done
+}
+
+// equal reports whether the files have the same contents
+func (f certFiles) equal(other certFiles) bool {
+	return bytes.Equal(f.ca, other.ca) && bytes.Equal(f.cert, other.cert) && bytes.Equal(f.key, other.key)
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target schemes that are not reached over plain TCP HTTP/1.1 or TLS
+const (
+	// SchemeUnix targets a Unix domain socket, such as unix:///var/run/app.sock
+	SchemeUnix = "unix"
+	// SchemeH2C targets a cleartext HTTP/2 service, such as h2c://host:port
+	SchemeH2C = "h2c"
+)
+
+// unixHost is the Host header of requests to Unix socket targets
+const unixHost = "localhost"
+
+// Upstream is a target URL resolved to the transport it is reached over
+type Upstream struct {
+	// Scheme is the scheme of the target, including unix and h2c
+	Scheme string
+	// Socket is the socket path of Unix socket targets
+	Socket string
+	// Host is the authority of the requests
+	Host string
+	// Path is the base path of the requests
+	Path string
+}
+
+// ParseUpstream parses a target URL. Unix socket targets take the socket path
+// as their URL path and have no base path.
+func ParseUpstream(target string) (Upstream, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return Upstream{}, fmt.Errorf("invalid target URL %q: %w", target, err)
+	}
+
+	if u.Scheme == SchemeUnix {
+		if u.Host != "" || u.Path == "" {
+			return Upstream{}, fmt.Errorf("invalid unix target %q, expected unix:///path/to/socket", target)
+		}
+		return Upstream{Scheme: SchemeUnix, Socket: u.Path, Host: unixHost}, nil
+	}
+
+	if u.Host == "" {
+		return Upstream{}, fmt.Errorf("invalid target URL %q: missing host", target)
+	}
+	return Upstream{Scheme: u.Scheme, Host: u.Host, Path: u.Path}, nil
+}
+
+// URL returns the request URL of a path on the upstream. Unix socket and h2c
+// requests are plain http requests on their own transports.
+func (u Upstream) URL(path string) string {
+	scheme := u.Scheme
+	if scheme == SchemeUnix || scheme == SchemeH2C {
+		scheme = "http"
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("%s://%s%s%s", scheme, u.Host, strings.TrimSuffix(u.Path, "/"), path)
+}
+
+// Transports keeps the connection pools of Unix socket and h2c targets apart
+// from the TCP pool, with one pool for all h2c targets and one per socket
+type Transports struct {
+	newTransport func() *http.Transport
+	h2c          *http.Transport
+
+	mu   sync.Mutex
+	unix map[string]*http.Transport
+}
+
+// NewTransports creates the transports from a template of the pool settings
+func NewTransports(newTransport func() *http.Transport) *Transports {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	h2c := newTransport()
+	h2c.Protocols = &protocols
+
+	return &Transports{
+		newTransport: newTransport,
+		h2c:          h2c,
+		unix:         make(map[string]*http.Transport),
+	}
+}
+
+// RoundTripper returns the transport of a Unix socket or h2c upstream, or nil
+// when the upstream is reached over TCP
+func (t *Transports) RoundTripper(u Upstream) http.RoundTripper {
+	switch u.Scheme {
+	case SchemeH2C:
+		return t.h2c
+	case SchemeUnix:
+		return t.socket(u.Socket)
+	}
+	return nil
+}
+
+// socket returns the transport of a Unix socket, creating it on first use
+func (t *Transports) socket(path string) *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if transport, ok := t.unix[path]; ok {
+		return transport
+	}
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	transport := t.newTransport()
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+	t.unix[path] = transport
+	return transport
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    Upstream
+		wantURL string
+		wantErr bool
+	}{
+		{
+			name:    "http",
+			target:  "http://users:8080",
+			want:    Upstream{Scheme: "http", Host: "users:8080"},
+			wantURL: "http://users:8080/users",
+		},
+		{
+			name:    "base path",
+			target:  "https://users.internal/v1/",
+			want:    Upstream{Scheme: "https", Host: "users.internal", Path: "/v1/"},
+			wantURL: "https://users.internal/v1/users",
+		},
+		{
+			name:    "unix socket",
+			target:  "unix:///run/users.sock",
+			want:    Upstream{Scheme: SchemeUnix, Socket: "/run/users.sock", Host: unixHost},
+			wantURL: "http://localhost/users",
+		},
+		{
+			name:    "h2c",
+			target:  "h2c://users:8080/v1",
+			want:    Upstream{Scheme: SchemeH2C, Host: "users:8080", Path: "/v1"},
+			wantURL: "http://users:8080/v1/users",
+		},
+		{name: "unix socket with host", target: "unix://users.sock", wantErr: true},
+		{name: "unix socket without path", target: "unix://", wantErr: true},
+		{name: "missing host", target: "users:8080", wantErr: true},
+		{name: "invalid URL", target: "http://users:port", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUpstream(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("upstream = %+v, want %+v", got, tt.want)
+			}
+			if url := got.URL("users"); url != tt.wantURL {
+				t.Errorf("URL = %q, want %q", url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestTransportsRoundTripper(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+
+	// A Unix socket server
+	socket := filepath.Join(t.TempDir(), "users.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixServer := &http.Server{Handler: handler}
+	go unixServer.Serve(listener)
+	defer unixServer.Close()
+
+	// A cleartext HTTP/2 server
+	h2cServer := httptest.NewUnstartedServer(handler)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	transports := NewTransports(func() *http.Transport { return &http.Transport{} })
+
+	tests := []struct {
+		name      string
+		target    string
+		wantProto string
+	}{
+		{name: "unix socket", target: "unix://" + socket, wantProto: "HTTP/1.1"},
+		{name: "h2c", target: "h2c://" + strings.TrimPrefix(h2cServer.URL, "http://"), wantProto: "HTTP/2.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, err := ParseUpstream(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rt := transports.RoundTripper(upstream)
+			if rt == nil {
+				t.Fatal("no transport")
+			}
+			if again := transports.RoundTripper(upstream); again != rt {
+				t.Error("transport not reused")
+			}
+
+			client := &http.Client{Transport: rt}
+			resp, err := client.Get(upstream.URL("/users"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			proto, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(proto) != tt.wantProto {
+				t.Errorf("protocol = %q, want %q", proto, tt.wantProto)
+			}
+		})
+	}
+
+	t.Run("sockets get their own pools", func(t *testing.T) {
+		a := transports.RoundTripper(Upstream{Scheme: SchemeUnix, Socket: "/run/a.sock"})
+		b := transports.RoundTripper(Upstream{Scheme: SchemeUnix, Socket: "/run/b.sock"})
+		if a == b {
+			t.Error("sockets share a transport")
+		}
+	})
+
+	t.Run("TCP targets use the shared client", func(t *testing.T) {
+		if rt := transports.RoundTripper(Upstream{Scheme: "https", Host: "users"}); rt != nil {
+			t.Errorf("transport = %v, want nil", rt)
+		}
+	})
+}
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -10,7 +12,6 @@ import (
 
 	"api-gateway/internal/config"
 	"api-gateway/pkg/logging"
-	"crypto/tls"
 
 	"github.com/fasthttp/websocket"
 	fiberws "github.com/gofiber/websocket/v2"
@@ -23,27 +24,83 @@ import (
 // Tracer for WebSocket proxy
 var wsTracer = otel.Tracer("websocket-proxy")
 
+// ErrDial is returned when the connection to the target WebSocket cannot be established
+var ErrDial = errors.New("failed to connect to target WebSocket")
+
+// WebSocket protocol modes of a service
+const (
+	// WebSocketRaw relays frames without assumptions about the application protocol
+	WebSocketRaw = "raw"
+	// WebSocketSocketIO relays the Engine.IO handshake before the frames
+	WebSocketSocketIO = "socket.io"
+	// WebSocketGraphQL negotiates a GraphQL over WebSocket subprotocol with both sides
+	WebSocketGraphQL = "graphql-ws"
+)
+
+// GraphQLSubprotocols are the GraphQL over WebSocket subprotocols, newest first
+var GraphQLSubprotocols = []string{"graphql-transport-ws", "graphql-ws"}
+
+// WebSocketProtocol returns the protocol mode of a service, raw by default
+func WebSocketProtocol(cfg config.WebSocketConfig) string {
+	if cfg.Protocol == "" {
+		return WebSocketRaw
+	}
+	return cfg.Protocol
+}
+
+// ValidateWebSocket checks that a WebSocket configuration is usable
+func ValidateWebSocket(cfg config.WebSocketConfig) error {
+	switch WebSocketProtocol(cfg) {
+	case WebSocketRaw, WebSocketSocketIO, WebSocketGraphQL:
+	default:
+		return fmt.Errorf("unknown websocket protocol %q", cfg.Protocol)
+	}
+	if cfg.Path != "" && !strings.HasPrefix(cfg.Path, "/") {
+		return fmt.Errorf("websocket path %q must start with /", cfg.Path)
+	}
+	return nil
+}
+
+// Subprotocols parses the subprotocols offered in a Sec-WebSocket-Protocol value
+func Subprotocols(value string) []string {
+	var protocols []string
+	for _, proto := range strings.Split(strings.Trim(value, "[]"), ",") {
+		if proto = strings.TrimSpace(proto); proto != "" {
+			protocols = append(protocols, proto)
+		}
+	}
+	return protocols
+}
+
+// socketIOPrefix is the path Socket.IO servers listen under
+const socketIOPrefix = "/socket.io"
+
+// SocketIOPath collapses a doubled /socket.io prefix, so a path template that
+// adds the prefix also serves clients that already connect below it
+func SocketIOPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, socketIOPrefix+socketIOPrefix); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+		return socketIOPrefix + rest
+	}
+	return path
+}
+
 // WebSocketProxy handles WebSocket connections and proxying
 type WebSocketProxy struct {
 	config *config.Config
 	logger *logging.Logger
 	dialer *websocket.Dialer
+	tls    *UpstreamTLS
 }
 
-// NewWebSocketProxy creates a new WebSocket proxy instance
-func NewWebSocketProxy(cfg *config.Config, logger *logging.Logger) (*WebSocketProxy, error) {
+// NewWebSocketProxy creates a new WebSocket proxy instance. Targets of
+// services with upstream TLS settings are dialed with them.
+func NewWebSocketProxy(cfg *config.Config, logger *logging.Logger, upstreamTLS *UpstreamTLS) (*WebSocketProxy, error) {
 	dialer := websocket.Dialer{
 		HandshakeTimeout: time.Second * 10,
 		ReadBufferSize:  4096,
 		WriteBufferSize: 4096,
 		// Enable compression
 		EnableCompression: true,
-		// Don't verify TLS for internal communication
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-		// Enable all subprotocols
-		Subprotocols: []string{"websocket"},
 		// Custom header generator
 		Jar: nil, // Don't use cookies
 	}
@@ -52,15 +109,19 @@ func NewWebSocketProxy(cfg *config.Config, logger *logging.Logger) (*WebSocketPr
 		config: cfg,
 		logger: logger,
 		dialer: &dialer,
+		tls:    upstreamTLS,
 	}, nil
 }
 
-// ProxyWebSocket handles WebSocket connection proxying
-func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path string, headers map[string]string, ctx context.Context) error {
+// ProxyWebSocket handles WebSocket connection proxying for a service. A
+// non-empty host replaces the target host in the Host header, and protocol is
+// the mode of the service.
+func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, service, target, path, host, protocol string, headers map[string]string, ctx context.Context) error {
 	p.logger.Debug("WebSocket proxy starting with context",
 		zap.Bool("context_is_nil", ctx == nil),
 		zap.String("target", target),
-		zap.String("path", path))
+		zap.String("path", path),
+		zap.String("mode", protocol))
 
 	spanCtx := ctx
 
@@ -77,12 +138,13 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 	}
 
 	// Parse target URL
-	targetURL, err := url.Parse(target)
+	targetURL, err := ParseUpstream(target)
 	if err != nil {
 		return fmt.Errorf("failed to parse target URL: %w", err)
 	}
 
-	// Create WebSocket URL for target
+	// Create WebSocket URL for target. Unix socket and h2c targets are
+	// upgraded over HTTP/1.1 in cleartext.
 	wsScheme := "ws"
 	httpScheme := "http"
 	if targetURL.Scheme == "https" {
@@ -158,6 +220,9 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 	// Set source and host headers
 	header.Set("X-Source", "api-gateway")
 	header.Set("Host", targetURL.Host)
+	if host != "" {
+		header.Set("Host", host)
+	}
 
 	// Propagate trace context to outgoing request
 	if span != nil {
@@ -168,39 +233,37 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 	dialer := *p.dialer
 	dialer.HandshakeTimeout = time.Second * 10
 	dialer.EnableCompression = true
-
-	// For Socket.IO connections, configure specific settings
-	isSocketIO := strings.Contains(wsURL, "/socket.io/")
-	if isSocketIO {
-		// Socket.IO specific settings
-		dialer.Subprotocols = nil // Clear any subprotocols
-		dialer.EnableCompression = false // Disable compression for Socket.IO
-		p.logger.Debug("Socket.IO connection detected, cleared subprotocols and disabled compression")
-	} else if proto := headers["Sec-WebSocket-Protocol"]; proto != "" {
-		// Handle array-like protocol values for non-Socket.IO connections
-		if strings.HasPrefix(proto, "[") && strings.HasSuffix(proto, "]") {
-			protocols := strings.Split(strings.Trim(proto, "[]"), ",")
-			cleanProtocols := make([]string, 0)
-			for _, p := range protocols {
-				if p = strings.TrimSpace(p); p != "" {
-					cleanProtocols = append(cleanProtocols, p)
-				}
-			}
-			if len(cleanProtocols) > 0 {
-				dialer.Subprotocols = cleanProtocols
-			}
-		} else {
-			dialer.Subprotocols = []string{proto}
+	if st := p.tls.lookup(service); st != nil {
+		dialer.NetDialTLSContext = st.dial
+	}
+	if targetURL.Scheme == SchemeUnix {
+		socket := targetURL.Socket
+		dialer.NetDialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
 		}
 	}
 
+	switch protocol {
+	case WebSocketSocketIO:
+		// Engine.IO negotiates without subprotocols and without compression
+		dialer.Subprotocols = nil
+		dialer.EnableCompression = false
+	case WebSocketGraphQL:
+		// The target must speak the subprotocol selected with the client
+		dialer.Subprotocols = []string{c.Subprotocol()}
+	default:
+		// Offer the target the subprotocols the client offered
+		dialer.Subprotocols = Subprotocols(headers["Sec-WebSocket-Protocol"])
+	}
+
 	// Log connection attempt details
 	p.logger.Debug("WebSocket connection details",
 		zap.String("target_http", httpURL),
 		zap.String("target_ws", wsURL),
 		zap.Any("headers", header),
 		zap.Any("protocols", dialer.Subprotocols),
-		zap.Bool("is_socket_io", isSocketIO))
+		zap.String("mode", protocol))
 
 	// Connect to target WebSocket server with context timeout
 	// Burada her zaman yeni bir background context kullan, trace context'den bağımsız olarak
@@ -229,65 +292,22 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 				zap.String("target_url", wsURL),
 				zap.Any("request_headers", header))
 		}
-		return fmt.Errorf("failed to connect to target WebSocket: %w", err)
+		return fmt.Errorf("%w: %w", ErrDial, err)
 	}
 	defer targetConn.Close()
 
 	p.logger.Info("WebSocket connection established",
 		zap.String("target_url", wsURL))
 
-	// For Socket.IO, wait for the initial handshake message
-	if isSocketIO {
-		targetConn.SetReadDeadline(time.Now().Add(5 * time.Second))
-		messageType, message, err := targetConn.ReadMessage()
-		if err != nil {
-			p.logger.Error("Socket.IO initial handshake failed",
-				zap.Error(err),
-				zap.String("target_url", wsURL))
-			return fmt.Errorf("Socket.IO handshake failed: %w", err)
+	switch protocol {
+	case WebSocketSocketIO:
+		if err := p.relaySocketIOHandshake(c, targetConn, wsURL); err != nil {
+			return err
 		}
-		p.logger.Info("Socket.IO initial message received from target",
-			zap.Int("messageType", messageType),
-			zap.String("message", string(message)),
-			zap.String("target_url", wsURL))
-
-		if err := c.WriteMessage(messageType, message); err != nil {
-			p.logger.Error("Failed to forward Socket.IO initial message to client",
-				zap.Error(err),
-				zap.String("target_url", wsURL))
-			return fmt.Errorf("failed to forward Socket.IO initial message: %w", err)
+	case WebSocketGraphQL:
+		if selected := targetConn.Subprotocol(); selected != c.Subprotocol() {
+			return fmt.Errorf("%w: target selected subprotocol %q instead of %q", ErrDial, selected, c.Subprotocol())
 		}
-		p.logger.Info("Socket.IO initial message forwarded to client",
-			zap.Int("messageType", messageType),
-			zap.String("message", string(message)),
-			zap.String("target_url", wsURL))
-
-		messageType, message, err = c.ReadMessage()
-		if err != nil {
-			p.logger.Error("Failed to receive client's Socket.IO handshake response",
-				zap.Error(err),
-				zap.String("target_url", wsURL))
-			return fmt.Errorf("failed to receive client's Socket.IO handshake response: %w", err)
-		}
-		p.logger.Info("Socket.IO client handshake response received",
-			zap.Int("messageType", messageType),
-			zap.String("message", string(message)),
-			zap.String("target_url", wsURL))
-
-		if err := targetConn.WriteMessage(messageType, message); err != nil {
-			p.logger.Error("Failed to forward client's Socket.IO handshake response to target",
-				zap.Error(err),
-				zap.String("target_url", wsURL))
-			return fmt.Errorf("failed to forward client's Socket.IO handshake response: %w", err)
-		}
-		p.logger.Info("Socket.IO client handshake response forwarded to target",
-			zap.Int("messageType", messageType),
-			zap.String("message", string(message)),
-			zap.String("target_url", wsURL))
-
-		// Handshake sonrası normal mesajlaşma için deadline'i kaldır
-		targetConn.SetReadDeadline(time.Time{})
-		c.SetReadDeadline(time.Time{})
 	}
 
 	// Create channels for message passing
@@ -310,7 +330,7 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 					p.logger.Error("Client WebSocket read error",
 						zap.Error(err),
 						zap.String("error_type", fmt.Sprintf("%T", err)),
-						zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+						zap.String("mode", protocol))
 					errChan <- err
 					return
 				}
@@ -319,7 +339,7 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 					p.logger.Error("Target WebSocket write error",
 						zap.Error(err),
 						zap.String("error_type", fmt.Sprintf("%T", err)),
-						zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+						zap.String("mode", protocol))
 					errChan <- err
 					return
 				}
@@ -342,7 +362,7 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 					p.logger.Error("Target WebSocket read error",
 						zap.Error(err),
 						zap.String("error_type", fmt.Sprintf("%T", err)),
-						zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+						zap.String("mode", protocol))
 					errChan <- err
 					return
 				}
@@ -351,7 +371,7 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 					p.logger.Error("Client WebSocket write error",
 						zap.Error(err),
 						zap.String("error_type", fmt.Sprintf("%T", err)),
-						zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+						zap.String("mode", protocol))
 					errChan <- err
 					return
 				}
@@ -370,16 +390,72 @@ func (p *WebSocketProxy) ProxyWebSocket(c *fiberws.Conn, target string, path str
 			p.logger.Debug("WebSocket connection closed",
 				zap.Error(err),
 				zap.String("error_type", fmt.Sprintf("%T", err)),
-				zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+				zap.String("mode", protocol))
 		} else {
 			p.logger.Error("WebSocket proxy error",
 				zap.Error(err),
 				zap.String("error_type", fmt.Sprintf("%T", err)),
 				zap.String("target_url", wsURL),
-				zap.Bool("is_socket_io", strings.Contains(wsURL, "/socket.io/")))
+				zap.String("mode", protocol))
 			return fmt.Errorf("WebSocket proxy error: %w", err)
 		}
 	}
 
 	return nil
 }
+
+// relaySocketIOHandshake forwards the Engine.IO open packet of the target to
+// the client and the client's answer back, before frames are relayed freely
+func (p *WebSocketProxy) relaySocketIOHandshake(c *fiberws.Conn, targetConn *websocket.Conn, wsURL string) error {
+	targetConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	messageType, message, err := targetConn.ReadMessage()
+	if err != nil {
+		p.logger.Error("Socket.IO initial handshake failed",
+			zap.Error(err),
+			zap.String("target_url", wsURL))
+		return fmt.Errorf("Socket.IO handshake failed: %w", err)
+	}
+	p.logger.Info("Socket.IO initial message received from target",
+		zap.Int("messageType", messageType),
+		zap.String("message", string(message)),
+		zap.String("target_url", wsURL))
+
+	if err := c.WriteMessage(messageType, message); err != nil {
+		p.logger.Error("Failed to forward Socket.IO initial message to client",
+			zap.Error(err),
+			zap.String("target_url", wsURL))
+		return fmt.Errorf("failed to forward Socket.IO initial message: %w", err)
+	}
+	p.logger.Info("Socket.IO initial message forwarded to client",
+		zap.Int("messageType", messageType),
+		zap.String("message", string(message)),
+		zap.String("target_url", wsURL))
+
+	messageType, message, err = c.ReadMessage()
+	if err != nil {
+		p.logger.Error("Failed to receive client's Socket.IO handshake response",
+			zap.Error(err),
+			zap.String("target_url", wsURL))
+		return fmt.Errorf("failed to receive client's Socket.IO handshake response: %w", err)
+	}
+	p.logger.Info("Socket.IO client handshake response received",
+		zap.Int("messageType", messageType),
+		zap.String("message", string(message)),
+		zap.String("target_url", wsURL))
+
+	if err := targetConn.WriteMessage(messageType, message); err != nil {
+		p.logger.Error("Failed to forward client's Socket.IO handshake response to target",
+			zap.Error(err),
+			zap.String("target_url", wsURL))
+		return fmt.Errorf("failed to forward client's Socket.IO handshake response: %w", err)
+	}
+	p.logger.Info("Socket.IO client handshake response forwarded to target",
+		zap.Int("messageType", messageType),
+		zap.String("message", string(message)),
+		zap.String("target_url", wsURL))
+
+	// Remove the deadline for normal messaging after the handshake
+	targetConn.SetReadDeadline(time.Time{})
+	c.SetReadDeadline(time.Time{})
+	return nil
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestValidateWebSocket(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.WebSocketConfig
+		wantProtocol string
+		wantErr      bool
+	}{
+		{name: "raw by default", wantProtocol: WebSocketRaw},
+		{name: "socket.io", cfg: config.WebSocketConfig{Protocol: WebSocketSocketIO, Path: "/socket.io${path}"}, wantProtocol: WebSocketSocketIO},
+		{name: "graphql", cfg: config.WebSocketConfig{Protocol: WebSocketGraphQL}, wantProtocol: WebSocketGraphQL},
+		{name: "unknown protocol", cfg: config.WebSocketConfig{Protocol: "stomp"}, wantProtocol: "stomp", wantErr: true},
+		{name: "relative path", cfg: config.WebSocketConfig{Path: "socket.io${path}"}, wantProtocol: WebSocketRaw, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WebSocketProtocol(tt.cfg); got != tt.wantProtocol {
+				t.Errorf("protocol = %q, want %q", got, tt.wantProtocol)
+			}
+			if err := ValidateWebSocket(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubprotocols(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{name: "empty"},
+		{name: "single", value: "graphql-ws", want: []string{"graphql-ws"}},
+		{name: "list", value: "graphql-transport-ws, graphql-ws", want: []string{"graphql-transport-ws", "graphql-ws"}},
+		{name: "empty entries skipped", value: " ,graphql-ws,, ", want: []string{"graphql-ws"}},
+		{name: "bracketed list", value: "[graphql-transport-ws, graphql-ws]", want: []string{"graphql-transport-ws", "graphql-ws"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Subprotocols(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subprotocols = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketIOPath(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  config.ServiceConfig
+		path string
+		want string
+	}{
+		{
+			name: "client below socket.io",
+			svc:  config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path: "/games/ice-age-royal/consumer/socket.io/",
+			want: "/socket.io/",
+		},
+		{
+			name: "client on the base path",
+			svc:  config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path: "/games/ice-age-royal/consumer",
+			want: "/socket.io/",
+		},
+		{
+			name: "client below another path",
+			svc:  config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path: "/games/ice-age-royal/consumer/events",
+			want: "/socket.io/events",
+		},
+		{
+			name: "similar segment is kept",
+			svc:  config.ServiceConfig{BasePath: "/games/ice-age-royal/consumer", StripBasePath: true},
+			path: "/games/ice-age-royal/consumer/socket.iox",
+			want: "/socket.io/socket.iox",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := NewRewriter(tt.svc)
+			if err != nil {
+				t.Fatalf("NewRewriter: %v", err)
+			}
+			got := SocketIOPath(rw.Expand("/socket.io${path}", tt.path, ""))
+			if got != tt.want {
+				t.Errorf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/zap"
+)
+
+// maintenanceRequest is the body of a maintenance switch
+type maintenanceRequest struct {
+	Enable *bool `json:"enable"`
+}
+
+// maintenanceStatus describes the maintenance state of a service
+type maintenanceStatus struct {
+	Service    string `json:"service"`
+	Mode       string `json:"mode"`
+	Active     bool   `json:"active"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+// splitRequest is the body of a traffic split update
+type splitRequest struct {
+	Groups []balancer.GroupWeight `json:"groups"`
+}
+
+// RegisterAdmin installs the admin API on the app
+func (r *Router) RegisterAdmin(app *fiber.App) {
+	admin := app.Group("/admin", middleware.AdminToken(r.config.Admin.Token))
+
+	admin.Get("/services", r.listServices)
+	admin.Post("/services", r.createService)
+	admin.Get("/services/:service", r.getService)
+	admin.Put("/services/:service", r.putService)
+	admin.Delete("/services/:service", r.deleteService)
+	admin.Get("/services/:service/split", r.getSplit)
+	admin.Put("/services/:service/split", r.putSplit)
+	admin.Get("/services/:service/canary", r.getCanary)
+	admin.Post("/services/:service/canary/restart", r.restartCanary)
+	admin.Get("/services/:service/maintenance", r.getMaintenance)
+	admin.Put("/services/:service/maintenance", r.putMaintenance)
+	admin.Delete("/services/:service/maintenance", r.deleteMaintenance)
+}
+
+// listServices returns the configuration of all services
+func (r *Router) listServices(c *fiber.Ctx) error {
+	services := r.Services()
+	body := make([]interface{}, 0, len(services))
+	for _, svc := range services {
+		body = append(body, encodeConfig(reflect.ValueOf(svc)))
+	}
+
+	return c.JSON(body)
+}
+
+// getService returns the configuration of a service
+func (r *Router) getService(c *fiber.Ctx) error {
+	svc, ok := r.routing.Load().service(c.Params("service"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+
+	return c.JSON(encodeConfig(reflect.ValueOf(svc)))
+}
+
+// createService adds a service and its route
+func (r *Router) createService(c *fiber.Ctx) error {
+	svc, err := decodeService(c.Body())
+	if err != nil {
+		return err
+	}
+	if svc.Name == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Missing service name")
+	}
+
+	err = r.changeServices(func(services []config.ServiceConfig) ([]config.ServiceConfig, error) {
+		if indexOf(services, svc.Name) >= 0 {
+			return nil, fiber.NewError(fiber.StatusConflict, "Service already exists")
+		}
+		return append(services, svc), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(encodeConfig(reflect.ValueOf(svc)))
+}
+
+// putService replaces the configuration of a service
+func (r *Router) putService(c *fiber.Ctx) error {
+	// The name outlives the request, so it must not alias fiber's buffers
+	name := utils.CopyString(c.Params("service"))
+	svc, err := decodeService(c.Body())
+	if err != nil {
+		return err
+	}
+	if svc.Name == "" {
+		svc.Name = name
+	}
+	if svc.Name != name {
+		return fiber.NewError(fiber.StatusBadRequest, "Service name cannot be changed")
+	}
+
+	err = r.changeServices(func(services []config.ServiceConfig) ([]config.ServiceConfig, error) {
+		i := indexOf(services, name)
+		if i < 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Unknown service")
+		}
+		// Responses leave secrets out, so a service read from the API and
+		// sent back keeps the secrets it has
+		keepSecrets(reflect.ValueOf(&svc).Elem(), reflect.ValueOf(services[i]))
+		services[i] = svc
+		return services, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(encodeConfig(reflect.ValueOf(svc)))
+}
+
+// deleteService removes a service and its route
+func (r *Router) deleteService(c *fiber.Ctx) error {
+	name := c.Params("service")
+	err := r.changeServices(func(services []config.ServiceConfig) ([]config.ServiceConfig, error) {
+		i := indexOf(services, name)
+		if i < 0 {
+			return nil, fiber.NewError(fiber.StatusNotFound, "Unknown service")
+		}
+		return append(services[:i], services[i+1:]...), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// changeServices applies a change to the services. Changes that do not
+// validate are rejected as bad requests.
+func (r *Router) changeServices(change func(services []config.ServiceConfig) ([]config.ServiceConfig, error)) error {
+	err := r.updateServices(change)
+	var fiberErr *fiber.Error
+	if err != nil && !errors.As(err, &fiberErr) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// indexOf returns the position of a service, or -1
+func indexOf(services []config.ServiceConfig, name string) int {
+	for i, svc := range services {
+		if svc.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeService reads a service configuration from a JSON body that uses
+// the same keys as the configuration file
+func decodeService(body []byte) (config.ServiceConfig, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return config.ServiceConfig{}, fiber.NewError(fiber.StatusBadRequest, "Invalid request body: "+err.Error())
+	}
+
+	var svc config.ServiceConfig
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:      &svc,
+		ErrorUnused: true,
+	})
+	if err != nil {
+		return config.ServiceConfig{}, err
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return config.ServiceConfig{}, fiber.NewError(fiber.StatusBadRequest, "Invalid service: "+err.Error())
+	}
+	return svc, nil
+}
+
+// encodeConfig converts a configuration value to the keys of the configuration
+// file. Fields tagged secret, such as signing secrets and API keys, are left out.
+func encodeConfig(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		out := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Tag.Get("secret") == "true" {
+				continue
+			}
+			if key := field.Tag.Get("mapstructure"); key != "" {
+				out[key] = encodeConfig(v.Field(i))
+			}
+		}
+		return out
+	case reflect.Slice:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = encodeConfig(v.Index(i))
+		}
+		return out
+	default:
+		return v.Interface()
+	}
+}
+
+// keepSecrets copies the fields tagged secret that are empty in a configuration
+// value from the value it replaces. List entries, such as middlewares, are
+// matched by name.
+func keepSecrets(v, current reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).Tag.Get("secret") != "true" {
+				keepSecrets(v.Field(i), current.Field(i))
+			} else if v.Field(i).IsZero() {
+				v.Field(i).Set(current.Field(i))
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			name, ok := nameOf(v.Index(i))
+			if !ok {
+				return
+			}
+			for j := 0; j < current.Len(); j++ {
+				if other, _ := nameOf(current.Index(j)); other == name {
+					keepSecrets(v.Index(i), current.Index(j))
+					break
+				}
+			}
+		}
+	}
+}
+
+// nameOf returns the Name field of a configuration struct
+func nameOf(v reflect.Value) (string, bool) {
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	name := v.FieldByName("Name")
+	if !name.IsValid() || name.Kind() != reflect.String {
+		return "", false
+	}
+	return name.String(), true
+}
+
+// getSplit returns the current traffic split of a service
+func (r *Router) getSplit(c *fiber.Ctx) error {
+	pool, ok := r.routing.Load().pools[c.Params("service")]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+
+	return c.JSON(splitRequest{Groups: pool.Split()})
+}
+
+// putSplit changes the traffic split of a service. The split of a service
+// with a canary is set by the canary, so changing it is rejected.
+func (r *Router) putSplit(c *fiber.Ctx) error {
+	service := c.Params("service")
+	pool, ok := r.routing.Load().pools[service]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+	if _, ok := r.canaries.Status(service); ok {
+		return fiber.NewError(fiber.StatusConflict, "Traffic split is managed by the canary of the service")
+	}
+
+	var req splitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body: "+err.Error())
+	}
+	if err := pool.SetSplit(req.Groups); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	r.logger.Info("Traffic split changed",
+		zap.String("service", pool.Service()),
+		zap.Any("groups", req.Groups))
+
+	return c.JSON(splitRequest{Groups: pool.Split()})
+}
+
+// getCanary returns the progress and decisions of the canary of a service
+func (r *Router) getCanary(c *fiber.Ctx) error {
+	status, ok := r.canaries.Status(c.Params("service"))
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Service has no canary")
+	}
+
+	return c.JSON(status)
+}
+
+// restartCanary starts the canary of a service again, for example after a rollback
+func (r *Router) restartCanary(c *fiber.Ctx) error {
+	service := c.Params("service")
+	if !r.canaries.Restart(service) {
+		return fiber.NewError(fiber.StatusNotFound, "Service has no canary")
+	}
+
+	status, _ := r.canaries.Status(service)
+	return c.JSON(status)
+}
+
+// getMaintenance returns whether a service is in maintenance
+func (r *Router) getMaintenance(c *fiber.Ctx) error {
+	service := c.Params("service")
+	maint, ok := r.routing.Load().maintenance[service]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+
+	return c.JSON(maintenanceStatusOf(service, maint))
+}
+
+// putMaintenance switches maintenance of a service on or off, overriding the configuration
+func (r *Router) putMaintenance(c *fiber.Ctx) error {
+	service := c.Params("service")
+	maint, ok := r.routing.Load().maintenance[service]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+
+	var req maintenanceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body: "+err.Error())
+	}
+	if req.Enable == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Missing enable field")
+	}
+
+	mode := maintenanceOff
+	if *req.Enable {
+		mode = maintenanceOn
+	}
+	maint.setMode(mode)
+
+	r.logger.Info("Maintenance mode changed",
+		zap.String("service", service),
+		zap.String("mode", mode))
+
+	return c.JSON(maintenanceStatusOf(service, maint))
+}
+
+// deleteMaintenance returns maintenance of a service to its configuration and schedule
+func (r *Router) deleteMaintenance(c *fiber.Ctx) error {
+	service := c.Params("service")
+	maint, ok := r.routing.Load().maintenance[service]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "Unknown service")
+	}
+
+	maint.setMode(maintenanceScheduled)
+
+	r.logger.Info("Maintenance mode changed",
+		zap.String("service", service),
+		zap.String("mode", maintenanceScheduled))
+
+	return c.JSON(maintenanceStatusOf(service, maint))
+}
+
+// maintenanceStatusOf returns the maintenance state of a service
+func maintenanceStatusOf(service string, maint *maintenance) maintenanceStatus {
+	active, retryAfter := maint.active(time.Now())
+	return maintenanceStatus{
+		Service:    service,
+		Mode:       maint.currentMode(),
+		Active:     active,
+		RetryAfter: retryAfter,
+	}
+}
@@ -0,0 +1,260 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminRequest sends a request with the admin token and returns the status and body of the response
+func adminRequest(t *testing.T, app *fiber.App, method, path string, body []byte) (int, []byte) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("X-Admin-Token", "admin-token")
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestEncodeConfigRedactsSecrets(t *testing.T) {
+	svc := config.ServiceConfig{
+		Name:                "api",
+		EnableStickySession: true,
+		StickySession:       config.StickySessionConfig{Header: "X-Affinity", Secret: "sticky-secret"},
+		Middleware: []config.MiddlewareConfig{
+			{Name: "jwt", Secret: "jwt-secret"},
+			{Name: "api_key", Keys: []string{"key-1"}},
+		},
+	}
+	out := encodeConfig(reflect.ValueOf(svc)).(map[string]interface{})
+
+	tests := []struct {
+		name   string
+		value  map[string]interface{}
+		key    string
+		absent bool
+	}{
+		{name: "name", value: out, key: "name"},
+		{name: "sticky session header", value: out["sticky_session"].(map[string]interface{}), key: "header"},
+		{name: "sticky session secret", value: out["sticky_session"].(map[string]interface{}), key: "secret", absent: true},
+		{name: "middleware name", value: out["middleware"].([]interface{})[0].(map[string]interface{}), key: "name"},
+		{name: "jwt secret", value: out["middleware"].([]interface{})[0].(map[string]interface{}), key: "secret", absent: true},
+		{name: "api keys", value: out["middleware"].([]interface{})[1].(map[string]interface{}), key: "keys", absent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.value[tt.key]; ok == tt.absent {
+				t.Errorf("key %q present = %v, want %v", tt.key, ok, !tt.absent)
+			}
+		})
+	}
+}
+
+func TestPutServiceKeepsSecrets(t *testing.T) {
+	svc := config.ServiceConfig{
+		Name:                "api",
+		BasePath:            "/api",
+		Targets:             []string{"http://a:8080"},
+		EnableStickySession: true,
+		StickySession:       config.StickySessionConfig{Header: "X-Affinity", Secret: "sticky-secret"},
+		Middleware: []config.MiddlewareConfig{
+			{Name: "jwt", Secret: "jwt-secret"},
+			{Name: "api_key", Keys: []string{"key-1"}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		// change edits the service read from the API before it is sent back
+		change func(body map[string]interface{})
+		// want lists the sticky session secret, then the name, secret and
+		// keys of every middleware
+		want []string
+	}{
+		{
+			name:   "round trip",
+			change: func(body map[string]interface{}) {},
+			want:   []string{"sticky-secret", "jwt", "jwt-secret", "", "api_key", "", "key-1"},
+		},
+		{
+			name: "secret replaced",
+			change: func(body map[string]interface{}) {
+				body["sticky_session"].(map[string]interface{})["secret"] = "new-secret"
+			},
+			want: []string{"new-secret", "jwt", "jwt-secret", "", "api_key", "", "key-1"},
+		},
+		{
+			name: "middlewares reordered",
+			change: func(body map[string]interface{}) {
+				middlewares := body["middleware"].([]interface{})
+				middlewares[0], middlewares[1] = middlewares[1], middlewares[0]
+			},
+			want: []string{"sticky-secret", "api_key", "", "key-1", "jwt", "jwt-secret", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Admin:    config.AdminConfig{Enable: true, Token: "admin-token"},
+				Services: []config.ServiceConfig{svc},
+			}
+			r, err := New(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			app := fiber.New()
+			r.RegisterAdmin(app)
+
+			var body map[string]interface{}
+			status, read := adminRequest(t, app, http.MethodGet, "/admin/services/api", nil)
+			if status != http.StatusOK {
+				t.Fatalf("GET status = %d: %s", status, read)
+			}
+			if err := json.Unmarshal(read, &body); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(body)
+			put, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status, got := adminRequest(t, app, http.MethodPut, "/admin/services/api", put); status != http.StatusOK {
+				t.Fatalf("PUT status = %d: %s", status, got)
+			}
+
+			svc, _ := r.routing.Load().service("api")
+			got := []string{svc.StickySession.Secret}
+			for _, m := range svc.Middleware {
+				got = append(got, m.Name, m.Secret, strings.Join(m.Keys, ","))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("secrets = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutSplit(t *testing.T) {
+	split := config.ServiceConfig{
+		Name:         "split",
+		BasePath:     "/split",
+		Targets:      []string{"http://stable:8080", "http://canary:8080"},
+		TargetGroups: []string{"stable", "canary"},
+		TrafficSplit: []config.TrafficSplitConfig{{Group: "stable", Weight: 90}, {Group: "canary", Weight: 10}},
+	}
+	canary := split
+	canary.Name = "canary"
+	canary.BasePath = "/canary"
+	canary.Canary = config.CanaryConfig{Enable: true, Steps: []int{10, 50}, Interval: 3600}
+
+	tests := []struct {
+		name    string
+		service string
+		want    int
+	}{
+		{name: "split changed", service: "split", want: http.StatusOK},
+		{name: "split managed by a canary", service: "canary", want: http.StatusConflict},
+		{name: "unknown service", service: "web", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Admin:    config.AdminConfig{Enable: true, Token: "admin-token"},
+				Services: []config.ServiceConfig{split, canary},
+			}
+			r, err := New(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			app := fiber.New()
+			r.RegisterAdmin(app)
+
+			body := []byte(`{"groups":[{"group":"stable","weight":50},{"group":"canary","weight":50}]}`)
+			if status, got := adminRequest(t, app, http.MethodPut, "/admin/services/"+tt.service+"/split", body); status != tt.want {
+				t.Errorf("status = %d, want %d: %s", status, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMaintenanceKeptAcrossChanges(t *testing.T) {
+	svc := config.ServiceConfig{Name: "api", BasePath: "/api", Targets: []string{"http://a:8080"}}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "service unchanged", body: `{"base_path":"/api","targets":["http://a:8080"]}`},
+		{name: "targets changed", body: `{"base_path":"/api","targets":["http://a:8080","http://b:8080"]}`},
+		{name: "maintenance settings changed", body: `{"base_path":"/api","targets":["http://a:8080"],"maintenance":{"retry_after":60}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Admin:    config.AdminConfig{Enable: true, Token: "admin-token"},
+				Services: []config.ServiceConfig{svc},
+			}
+			r, err := New(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			app := fiber.New()
+			r.RegisterAdmin(app)
+
+			if status, got := adminRequest(t, app, http.MethodPut, "/admin/services/api/maintenance", []byte(`{"enable":true}`)); status != http.StatusOK {
+				t.Fatalf("maintenance status = %d: %s", status, got)
+			}
+			if status, got := adminRequest(t, app, http.MethodPut, "/admin/services/api", []byte(tt.body)); status != http.StatusOK {
+				t.Fatalf("PUT status = %d: %s", status, got)
+			}
+
+			var got maintenanceStatus
+			_, body := adminRequest(t, app, http.MethodGet, "/admin/services/api/maintenance", nil)
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Mode != maintenanceOn || !got.Active {
+				t.Errorf("maintenance = %+v, want switched on", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// invalidCookieChars matches characters that are not allowed in the default cookie name
+var invalidCookieChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// affinity pins clients to a target with a gateway-issued signed token
+type affinity struct {
+	service    string
+	cookieName string
+	cookiePath string
+	header     string
+	queryParam string
+	ttl        time.Duration
+	secret     []byte
+}
+
+// newAffinity creates the session affinity settings for a service
+func newAffinity(svc config.ServiceConfig, logger *logging.Logger) (*affinity, error) {
+	cfg := svc.StickySession
+
+	cookieName := cfg.CookieName
+	if cookieName == "" {
+		cookieName = "gw_affinity_" + invalidCookieChars.ReplaceAllString(svc.Name, "_")
+	}
+
+	cookiePath := svc.BasePath
+	if !strings.HasPrefix(cookiePath, "/") {
+		cookiePath = "/" + cookiePath
+	}
+
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		// Without a shared secret the tokens only survive as long as this instance
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate sticky session secret: %w", err)
+		}
+		logger.Warn("No sticky session secret configured, affinity will not survive restarts or span replicas",
+			zap.String("service", svc.Name))
+	}
+
+	return &affinity{
+		service:    svc.Name,
+		cookieName: cookieName,
+		cookiePath: cookiePath,
+		header:     cfg.Header,
+		queryParam: cfg.QueryParam,
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		secret:     secret,
+	}, nil
+}
+
+// lookup returns the target ID the client is pinned to. Tokens are read from
+// the header, the query parameter and the cookie in that order, and the first
+// valid one wins, so a stale header does not hide a valid cookie.
+func (a *affinity) lookup(c *fiber.Ctx) string {
+	now := time.Now()
+	var tokens []string
+	if a.header != "" {
+		tokens = append(tokens, c.Get(a.header))
+	}
+	if a.queryParam != "" {
+		tokens = append(tokens, c.Query(a.queryParam))
+	}
+	tokens = append(tokens, c.Cookies(a.cookieName))
+
+	for _, token := range tokens {
+		if id, ok := a.verify(token, now); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// issue hands the client a token that pins it to the target
+func (a *affinity) issue(c *fiber.Ctx, target *balancer.Target) {
+	token := a.token(target.ID, time.Now())
+
+	cookie := &fiber.Cookie{
+		Name:     a.cookieName,
+		Value:    token,
+		Path:     a.cookiePath,
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+	if a.ttl > 0 {
+		cookie.MaxAge = int(a.ttl.Seconds())
+	}
+	c.Cookie(cookie)
+
+	// Clients that cannot keep cookies echo the token back in the header
+	if a.header != "" {
+		c.Set(a.header, token)
+	}
+}
+
+// token returns the signed token of a target ID issued at a time
+func (a *affinity) token(id string, issued time.Time) string {
+	ts := strconv.FormatInt(issued.Unix(), 10)
+	return id + "." + ts + "." + a.sign(id, ts)
+}
+
+// verify returns the target ID of a token that is signed for this service and,
+// with a ttl, not older than the ttl
+func (a *affinity) verify(token string, now time.Time) (string, bool) {
+	id, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	ts, sig, ok := strings.Cut(rest, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(a.sign(id, ts))) {
+		return "", false
+	}
+	issued, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if a.ttl > 0 && now.Sub(time.Unix(issued, 0)) > a.ttl {
+		return "", false
+	}
+	return id, true
+}
+
+// sign returns the signature of a target ID and issue time for this service
+func (a *affinity) sign(id, issued string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(a.service))
+	mac.Write([]byte{0})
+	mac.Write([]byte(id))
+	mac.Write([]byte{0})
+	mac.Write([]byte(issued))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,209 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/pkg/logging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lookupRequest runs the lookup of an affinity for a request and returns the pinned target ID
+func lookupRequest(t *testing.T, aff *affinity, req *http.Request) string {
+	t.Helper()
+
+	app := fiber.New()
+	app.Get("/*", func(c *fiber.Ctx) error {
+		return c.SendString(aff.lookup(c))
+	})
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestAffinityLookup(t *testing.T) {
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := config.ServiceConfig{
+		Name:     "api",
+		BasePath: "/api",
+		StickySession: config.StickySessionConfig{
+			Header:     "X-Affinity",
+			QueryParam: "affinity",
+			TTL:        3600,
+			Secret:     "sticky-secret",
+		},
+	}
+	aff, err := newAffinity(svc, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := balancer.NewTarget("http://a:8080", 1)
+	b := balancer.NewTarget("http://b:8080", 1)
+	now := time.Now()
+	token := func(aff *affinity, target *balancer.Target) string {
+		return aff.token(target.ID, now)
+	}
+
+	other := svc
+	other.Name = "web"
+	otherService, err := newAffinity(other, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other = svc
+	other.StickySession.Secret = "other-secret"
+	otherSecret, err := newAffinity(other, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token with its issue time moved forward keeps the signature of the original
+	issued := strconv.FormatInt(now.Add(-2*time.Hour).Unix(), 10)
+	moved := a.ID + "." + strconv.FormatInt(now.Unix(), 10) + "." + aff.sign(a.ID, issued)
+
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		cookie string
+		want   string
+	}{
+		{name: "no token"},
+		{name: "cookie", cookie: token(aff, a), want: a.ID},
+		{name: "header", header: token(aff, a), want: a.ID},
+		{name: "query parameter", query: token(aff, a), want: a.ID},
+		{name: "header before cookie", header: token(aff, a), cookie: token(aff, b), want: a.ID},
+		{name: "query parameter before cookie", query: token(aff, b), cookie: token(aff, a), want: b.ID},
+		{name: "invalid header falls through", header: token(otherSecret, b), cookie: token(aff, a), want: a.ID},
+		{name: "invalid query parameter falls through", query: "garbage", cookie: token(aff, a), want: a.ID},
+		{name: "token within the ttl", cookie: aff.token(a.ID, now.Add(-59*time.Minute)), want: a.ID},
+		{name: "token older than the ttl", cookie: aff.token(a.ID, now.Add(-2*time.Hour))},
+		{name: "issue time changed", cookie: moved},
+		{name: "tampered target", cookie: b.ID + "." + issued + "." + aff.sign(a.ID, issued)},
+		{name: "missing signature", cookie: a.ID},
+		{name: "token of another service", cookie: token(otherService, a)},
+		{name: "token of another secret", cookie: token(otherSecret, a)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/api/users"
+			if tt.query != "" {
+				target += "?affinity=" + tt.query
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Affinity", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "gw_affinity_api", Value: tt.cookie})
+			}
+
+			if got := lookupRequest(t, aff, req); got != tt.want {
+				t.Errorf("pinned target = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAffinityIssue(t *testing.T) {
+	tests := []struct {
+		name       string
+		svc        config.ServiceConfig
+		wantCookie string
+		wantPath   string
+		wantMaxAge int
+		wantHeader bool
+	}{
+		{
+			name:       "defaults",
+			svc:        config.ServiceConfig{Name: "ice-age.api", BasePath: "/games/ice-age"},
+			wantCookie: "gw_affinity_ice-age_api",
+			wantPath:   "/games/ice-age",
+		},
+		{
+			name: "custom cookie with ttl",
+			svc: config.ServiceConfig{
+				Name:          "api",
+				StickySession: config.StickySessionConfig{CookieName: "session", TTL: 3600},
+			},
+			wantCookie: "session",
+			wantPath:   "/",
+			wantMaxAge: 3600,
+		},
+		{
+			name: "header echoed",
+			svc: config.ServiceConfig{
+				Name:          "api",
+				BasePath:      "/api",
+				StickySession: config.StickySessionConfig{Header: "X-Affinity"},
+			},
+			wantCookie: "gw_affinity_api",
+			wantPath:   "/api",
+			wantHeader: true,
+		},
+	}
+
+	logger, err := logging.NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aff, err := newAffinity(tt.svc, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			target := balancer.NewTarget("http://a:8080", 1)
+
+			app := fiber.New()
+			app.Get("/*", func(c *fiber.Ctx) error {
+				aff.issue(c, target)
+				return nil
+			})
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			cookies := resp.Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("cookies = %v, want one", cookies)
+			}
+			cookie := cookies[0]
+			if cookie.Name != tt.wantCookie || cookie.Path != tt.wantPath || cookie.MaxAge != tt.wantMaxAge || !cookie.HttpOnly {
+				t.Errorf("cookie = %s, want name %s, path %s and max age %d", cookie, tt.wantCookie, tt.wantPath, tt.wantMaxAge)
+			}
+			if header := resp.Header.Get("X-Affinity"); (header == cookie.Value) != tt.wantHeader {
+				t.Errorf("header = %q, want the token %v", header, tt.wantHeader)
+			}
+
+			// The issued token pins the client to the target
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+			if got := lookupRequest(t, aff, req); got != target.ID {
+				t.Errorf("pinned target = %q, want %q", got, target.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package router
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/proxy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultRedirectStatus        = fiber.StatusFound
+	defaultMaintenanceRetryAfter = 300
+	defaultMaintenanceBody       = "Service is under maintenance"
+)
+
+// Maintenance modes of a service
+const (
+	maintenanceScheduled = "scheduled"
+	maintenanceOn        = "on"
+	maintenanceOff       = "off"
+)
+
+// directResponse answers requests of a service with a fixed response
+type directResponse struct {
+	status      int
+	body        string
+	contentType string
+	headers     map[string]string
+}
+
+// newDirectResponse creates the fixed response of a service, or nil when none is configured
+func newDirectResponse(cfg config.DirectResponseConfig) (*directResponse, error) {
+	if cfg.Status == 0 {
+		return nil, nil
+	}
+	if cfg.Status < 100 || cfg.Status > 599 {
+		return nil, fmt.Errorf("invalid response status %d", cfg.Status)
+	}
+	return &directResponse{
+		status:      cfg.Status,
+		body:        cfg.Body,
+		contentType: cfg.ContentType,
+		headers:     cfg.Headers,
+	}, nil
+}
+
+// serve writes the fixed response
+func (d *directResponse) serve(c *fiber.Ctx) error {
+	for key, value := range d.headers {
+		c.Set(key, value)
+	}
+	if d.contentType != "" {
+		c.Set(fiber.HeaderContentType, d.contentType)
+	}
+	return c.Status(d.status).SendString(d.body)
+}
+
+// redirect answers requests of a service with a redirect
+type redirect struct {
+	url        string
+	status     int
+	stripQuery bool
+	rewriter   *proxy.Rewriter
+}
+
+// newRedirect creates the redirect of a service, or nil when none is configured
+func newRedirect(cfg config.RedirectConfig, rewriter *proxy.Rewriter) (*redirect, error) {
+	if cfg.URL == "" {
+		if cfg.Status != 0 {
+			return nil, fmt.Errorf("redirect status requires a url")
+		}
+		return nil, nil
+	}
+
+	status := cfg.Status
+	if status == 0 {
+		status = defaultRedirectStatus
+	}
+	switch status {
+	case fiber.StatusMovedPermanently, fiber.StatusFound, fiber.StatusSeeOther,
+		fiber.StatusTemporaryRedirect, fiber.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("invalid redirect status %d", status)
+	}
+
+	return &redirect{
+		url:        cfg.URL,
+		status:     status,
+		stripQuery: cfg.StripQuery,
+		rewriter:   rewriter,
+	}, nil
+}
+
+// serve redirects the request to the expanded URL template
+func (rd *redirect) serve(c *fiber.Ctx) error {
+	location := rd.rewriter.Expand(rd.url, c.Path(), requestHost(c))
+	if query := c.Request().URI().QueryString(); len(query) > 0 && !rd.stripQuery {
+		location += "?" + string(query)
+	}
+	return c.Redirect(location, rd.status)
+}
+
+// maintenanceWindow is a scheduled maintenance period
+type maintenanceWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// maintenance takes a service offline, either as configured, during scheduled
+// windows, or when switched on or off at runtime
+type maintenance struct {
+	enable      bool
+	windows     []maintenanceWindow
+	retryAfter  int
+	body        string
+	contentType string
+
+	mu   sync.RWMutex
+	mode string
+}
+
+// newMaintenance creates the maintenance mode of a service
+func newMaintenance(cfg config.MaintenanceConfig) (*maintenance, error) {
+	m := &maintenance{
+		enable:      cfg.Enable,
+		retryAfter:  cfg.RetryAfter,
+		body:        cfg.Body,
+		contentType: cfg.ContentType,
+		mode:        maintenanceScheduled,
+	}
+	if m.retryAfter <= 0 {
+		m.retryAfter = defaultMaintenanceRetryAfter
+	}
+	if m.body == "" {
+		m.body = defaultMaintenanceBody
+	}
+
+	for _, w := range cfg.Windows {
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window start: %w", err)
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance window end: %w", err)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("maintenance window ending %s does not end after its start", w.End)
+		}
+		m.windows = append(m.windows, maintenanceWindow{start: start, end: end})
+	}
+
+	return m, nil
+}
+
+// active reports whether the service is in maintenance, and for how many
+// seconds clients should wait before retrying
+func (m *maintenance) active(now time.Time) (bool, int) {
+	switch m.currentMode() {
+	case maintenanceOn:
+		return true, m.retryAfter
+	case maintenanceOff:
+		return false, 0
+	}
+
+	if m.enable {
+		return true, m.retryAfter
+	}
+	for _, w := range m.windows {
+		if !now.Before(w.start) && now.Before(w.end) {
+			return true, int(math.Ceil(w.end.Sub(now).Seconds()))
+		}
+	}
+	return false, 0
+}
+
+// currentMode returns whether maintenance follows the configuration or was switched at runtime
+func (m *maintenance) currentMode() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.mode
+}
+
+// setMode switches maintenance on or off, or back to the configuration
+func (m *maintenance) setMode(mode string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.mode = mode
+}
+
+// serve writes the maintenance response
+func (m *maintenance) serve(c *fiber.Ctx, retryAfter int) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	if m.contentType != "" {
+		c.Set(fiber.HeaderContentType, m.contentType)
+	}
+	return c.Status(fiber.StatusServiceUnavailable).SendString(m.body)
+}
@@ -0,0 +1,213 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/proxy"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestDirectResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.DirectResponseConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "not configured", wantNil: true},
+		{name: "response", cfg: config.DirectResponseConfig{Status: http.StatusTeapot, Body: `{"ok":false}`, ContentType: "application/json", Headers: map[string]string{"X-Direct": "1"}}},
+		{name: "status below 100", cfg: config.DirectResponseConfig{Status: 99}, wantErr: true},
+		{name: "status above 599", cfg: config.DirectResponseConfig{Status: 600}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDirectResponse(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantNil {
+				if d != nil {
+					t.Errorf("response = %+v, want nil", d)
+				}
+				return
+			}
+
+			app := fiber.New()
+			app.Get("/*", d.serve)
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != tt.cfg.Status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.cfg.Status)
+			}
+			if string(body) != tt.cfg.Body {
+				t.Errorf("body = %q, want %q", body, tt.cfg.Body)
+			}
+			if got := resp.Header.Get(fiber.HeaderContentType); got != tt.cfg.ContentType {
+				t.Errorf("content type = %q, want %q", got, tt.cfg.ContentType)
+			}
+			if got := resp.Header.Get("X-Direct"); got != "1" {
+				t.Errorf("X-Direct = %q, want %q", got, "1")
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          config.RedirectConfig
+		rewrite      config.RewriteConfig
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{
+			name:         "default status",
+			cfg:          config.RedirectConfig{URL: "https://${host}${path}"},
+			target:       "http://api.example.com/legacy/users?page=2",
+			wantStatus:   http.StatusFound,
+			wantLocation: "https://api.example.com/legacy/users?page=2",
+		},
+		{
+			name:         "query stripped",
+			cfg:          config.RedirectConfig{URL: "https://${host}${path}", Status: http.StatusMovedPermanently, StripQuery: true},
+			target:       "http://api.example.com/legacy/users?page=2",
+			wantStatus:   http.StatusMovedPermanently,
+			wantLocation: "https://api.example.com/legacy/users",
+		},
+		{
+			name:         "rewritten path and captures",
+			cfg:          config.RedirectConfig{URL: "https://v2.example.com${path}#${id}", Status: http.StatusPermanentRedirect},
+			rewrite:      config.RewriteConfig{Regex: `^/legacy/users/(?P<id>\d+)$`, Replacement: "/users/${id}"},
+			target:       "http://api.example.com/legacy/users/42",
+			wantStatus:   http.StatusPermanentRedirect,
+			wantLocation: "https://v2.example.com/users/42#42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewriter, err := proxy.NewRewriter(config.ServiceConfig{Name: "legacy", BasePath: "/legacy", Rewrite: tt.rewrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rd, err := newRedirect(tt.cfg, rewriter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app := fiber.New()
+			app.Get("/*", rd.serve)
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get(fiber.HeaderLocation); got != tt.wantLocation {
+				t.Errorf("location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestNewRedirectErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.RedirectConfig
+	}{
+		{name: "status without url", cfg: config.RedirectConfig{Status: http.StatusFound}},
+		{name: "status not a redirect", cfg: config.RedirectConfig{URL: "https://example.com", Status: http.StatusOK}},
+		{name: "not modified", cfg: config.RedirectConfig{URL: "https://example.com", Status: http.StatusNotModified}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRedirect(tt.cfg, nil); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestNewMaintenanceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		window config.MaintenanceWindowConfig
+	}{
+		{name: "invalid start", window: config.MaintenanceWindowConfig{Start: "2026-10-16 10:00", End: "2026-10-16T11:00:00Z"}},
+		{name: "invalid end", window: config.MaintenanceWindowConfig{Start: "2026-10-16T10:00:00Z", End: "tomorrow"}},
+		{name: "end before start", window: config.MaintenanceWindowConfig{Start: "2026-10-16T11:00:00Z", End: "2026-10-16T10:00:00Z"}},
+		{name: "empty window", window: config.MaintenanceWindowConfig{Start: "2026-10-16T10:00:00Z", End: "2026-10-16T10:00:00Z"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.MaintenanceConfig{Windows: []config.MaintenanceWindowConfig{tt.window}}
+			if _, err := newMaintenance(cfg); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestMaintenanceActive(t *testing.T) {
+	window := config.MaintenanceWindowConfig{Start: "2026-10-16T10:00:00Z", End: "2026-10-16T11:00:00+00:00"}
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		cfg            config.MaintenanceConfig
+		mode           string
+		now            time.Time
+		wantActive     bool
+		wantRetryAfter int
+	}{
+		{name: "off", cfg: config.MaintenanceConfig{Windows: []config.MaintenanceWindowConfig{window}}, now: start.Add(-time.Second)},
+		{name: "enabled", cfg: config.MaintenanceConfig{Enable: true}, now: start, wantActive: true, wantRetryAfter: defaultMaintenanceRetryAfter},
+		{name: "enabled with retry after", cfg: config.MaintenanceConfig{Enable: true, RetryAfter: 60}, now: start, wantActive: true, wantRetryAfter: 60},
+		{name: "window start", cfg: config.MaintenanceConfig{Windows: []config.MaintenanceWindowConfig{window}}, now: start, wantActive: true, wantRetryAfter: 3600},
+		{name: "retry after rounded up to the window end", cfg: config.MaintenanceConfig{Windows: []config.MaintenanceWindowConfig{window}}, now: start.Add(59*time.Minute + 30*time.Second), wantActive: true, wantRetryAfter: 30},
+		{name: "window end", cfg: config.MaintenanceConfig{Windows: []config.MaintenanceWindowConfig{window}}, now: start.Add(time.Hour)},
+		{name: "switched on", mode: maintenanceOn, now: start, wantActive: true, wantRetryAfter: defaultMaintenanceRetryAfter},
+		{name: "switched off during a window", cfg: config.MaintenanceConfig{Enable: true, Windows: []config.MaintenanceWindowConfig{window}}, mode: maintenanceOff, now: start},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMaintenance(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.mode != "" {
+				m.setMode(tt.mode)
+			}
+
+			active, retryAfter := m.active(tt.now)
+			if active != tt.wantActive || retryAfter != tt.wantRetryAfter {
+				t.Errorf("active = %v, %d, want %v, %d", active, retryAfter, tt.wantActive, tt.wantRetryAfter)
+			}
+		})
+	}
+}
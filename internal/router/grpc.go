@@ -0,0 +1,218 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/internal/health"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/proxy"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// grpcRoute is a service that takes gRPC calls
+type grpcRoute struct {
+	service config.ServiceConfig
+	auth    *middleware.Authenticator
+}
+
+// grpcTable routes gRPC calls by their full method name, /package.Service/Method
+type grpcTable struct {
+	routes   []*grpcRoute
+	methods  map[string]*grpcRoute
+	services map[string]*grpcRoute
+}
+
+// isGRPC reports whether a service is a gRPC route instead of an HTTP one
+func isGRPC(svc config.ServiceConfig) bool {
+	return len(svc.GRPC.Services) > 0 || len(svc.GRPC.Methods) > 0
+}
+
+// validateGRPCRoute rejects settings of a gRPC service that only apply to HTTP routes
+func validateGRPCRoute(svc config.ServiceConfig) error {
+	if svc.BasePath != "" || len(svc.Hosts) > 0 {
+		return fmt.Errorf("gRPC routes are matched by method and take no base_path or hosts")
+	}
+	if len(svc.Match.Methods) > 0 || len(svc.Match.Headers) > 0 || len(svc.Match.Query) > 0 || len(svc.Match.Cookies) > 0 {
+		return fmt.Errorf("gRPC routes take no match predicates")
+	}
+	if svc.EnableWebSocket || svc.EnableStickySession || svc.Mirror.Target != "" {
+		return fmt.Errorf("gRPC routes do not support websockets, sticky sessions or mirroring")
+	}
+	if len(svc.Targets) == 0 {
+		return fmt.Errorf("gRPC routes need targets")
+	}
+	for _, target := range svc.Targets {
+		if balancer.IsDiscoveryTarget(target) {
+			continue
+		}
+		if err := proxy.ValidateGRPCTarget(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newGRPCTable builds the gRPC routes and rejects methods or services that
+// more than one route takes
+func newGRPCTable(routes []*grpcRoute) (*grpcTable, error) {
+	t := &grpcTable{
+		routes:   routes,
+		methods:  make(map[string]*grpcRoute),
+		services: make(map[string]*grpcRoute),
+	}
+
+	for _, rt := range routes {
+		for _, name := range rt.service.GRPC.Services {
+			if name == "" || strings.Contains(name, "/") {
+				return nil, fmt.Errorf("service %s: invalid gRPC service name %q", rt.service.Name, name)
+			}
+			if other, ok := t.services[name]; ok {
+				return nil, fmt.Errorf("services %s and %s both route gRPC service %s", other.service.Name, rt.service.Name, name)
+			}
+			t.services[name] = rt
+		}
+		for _, name := range rt.service.GRPC.Methods {
+			service, method, ok := strings.Cut(strings.TrimPrefix(name, "/"), "/")
+			if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+				return nil, fmt.Errorf("service %s: invalid gRPC method %q, expected Service/Method", rt.service.Name, name)
+			}
+			fullMethod := "/" + service + "/" + method
+			if other, ok := t.methods[fullMethod]; ok {
+				return nil, fmt.Errorf("services %s and %s both route gRPC method %s", other.service.Name, rt.service.Name, fullMethod)
+			}
+			t.methods[fullMethod] = rt
+		}
+	}
+
+	return t, nil
+}
+
+// match returns the route of a call, preferring routes of the single method
+func (t *grpcTable) match(fullMethod string) *grpcRoute {
+	if rt, ok := t.methods[fullMethod]; ok {
+		return rt
+	}
+	service, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return t.services[service]
+}
+
+// HandleGRPC proxies a gRPC call to a target of the service that routes its
+// method. It handles every call of the gRPC listener, since the gateway
+// registers no gRPC services of its own.
+func (r *Router) HandleGRPC(_ any, stream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "missing method")
+	}
+
+	rs := r.routing.Load()
+	rt := rs.grpc.match(method)
+	if rt == nil {
+		return status.Errorf(codes.Unimplemented, "no route for method %s", method)
+	}
+	svc := rt.service
+
+	if active, _ := rs.maintenance[svc.Name].active(time.Now()); active {
+		return status.Errorf(codes.Unavailable, "service %s is under maintenance", svc.Name)
+	}
+
+	// Check the credentials of the jwt and api_key middlewares of the service
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	claims, err := rt.auth.Authenticate(func(name string) string {
+		return firstValue(md, name)
+	})
+	if err != nil {
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			return status.Error(codes.Unauthenticated, fiberErr.Message)
+		}
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	pool, ok := rs.pools[svc.Name]
+	if !ok {
+		return status.Errorf(codes.Unavailable, "no targets available for service %s", svc.Name)
+	}
+	target, err := pool.PickForKey(grpcHashKey(stream, md, claims, svc.HashKey))
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "no targets available for service %s", svc.Name)
+	}
+
+	// Track the call for load-aware balancing while it is open
+	target.Acquire()
+	defer target.Release()
+
+	r.logger.Debug("Routing gRPC call",
+		zap.String("method", method),
+		zap.String("target", target.URL),
+		zap.String("service", svc.Name))
+
+	start := time.Now()
+	err = r.grpcProxy.Forward(stream, svc.Name, method, target.URL)
+	elapsed := time.Since(start)
+	outcome := grpcOutcome(err)
+	r.outliers.Report(svc.Name, target, outcome)
+	r.canaries.Report(svc.Name, target.Group(), outcome, elapsed)
+	r.groupRequests.WithLabelValues(svc.Name, target.Group(), outcome.String()).Inc()
+	r.groupDuration.WithLabelValues(svc.Name, target.Group()).Observe(elapsed.Seconds())
+
+	return err
+}
+
+// grpcHashKey extracts the consistent hashing key of a call. Query and
+// cookie sources do not exist for gRPC calls and hash to no key.
+func grpcHashKey(stream grpc.ServerStream, md metadata.MD, claims jwt.MapClaims, cfg config.HashKeyConfig) string {
+	switch cfg.Source {
+	case "header":
+		return firstValue(md, cfg.Name)
+	case "claim":
+		return claimValue(claims, cfg.Name)
+	case "ip":
+		p, ok := peer.FromContext(stream.Context())
+		if !ok {
+			return ""
+		}
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	default:
+		return ""
+	}
+}
+
+// firstValue returns the first metadata value of a key
+func firstValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// grpcOutcome classifies the status of a proxied gRPC call for outlier detection
+func grpcOutcome(err error) health.Outcome {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return health.OutcomeGatewayError
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return health.OutcomeServerError
+	default:
+		return health.OutcomeSuccess
+	}
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestGRPCTableMatch(t *testing.T) {
+	routes := []*grpcRoute{
+		{service: config.ServiceConfig{Name: "wallet", GRPC: config.GRPCRouteConfig{Services: []string{"wallet.v1.Wallet"}}}},
+		{service: config.ServiceConfig{Name: "payouts", GRPC: config.GRPCRouteConfig{Methods: []string{"wallet.v1.Wallet/Payout", "/games.v1.Games/Payout"}}}},
+	}
+	table, err := newGRPCTable(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{name: "service", method: "/wallet.v1.Wallet/Balance", want: "wallet"},
+		{name: "method before its service", method: "/wallet.v1.Wallet/Payout", want: "payouts"},
+		{name: "method with leading slash", method: "/games.v1.Games/Payout", want: "payouts"},
+		{name: "other method of a routed method", method: "/games.v1.Games/Join"},
+		{name: "unknown service", method: "/users.v1.Users/Get"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if rt := table.match(tt.method); rt != nil {
+				got = rt.service.Name
+			}
+			if got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewGRPCTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b config.GRPCRouteConfig
+	}{
+		{name: "service routed twice", a: config.GRPCRouteConfig{Services: []string{"wallet.v1.Wallet"}}, b: config.GRPCRouteConfig{Services: []string{"wallet.v1.Wallet"}}},
+		{name: "method routed twice", a: config.GRPCRouteConfig{Methods: []string{"/wallet.v1.Wallet/Payout"}}, b: config.GRPCRouteConfig{Methods: []string{"wallet.v1.Wallet/Payout"}}},
+		{name: "service name with a method", a: config.GRPCRouteConfig{Services: []string{"wallet.v1.Wallet/Payout"}}},
+		{name: "method without a service", a: config.GRPCRouteConfig{Methods: []string{"Payout"}}},
+		{name: "method with a path", a: config.GRPCRouteConfig{Methods: []string{"wallet.v1.Wallet/Payout/1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := []*grpcRoute{
+				{service: config.ServiceConfig{Name: "a", GRPC: tt.a}},
+				{service: config.ServiceConfig{Name: "b", GRPC: tt.b}},
+			}
+			if _, err := newGRPCTable(routes); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
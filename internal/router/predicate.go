@@ -0,0 +1,213 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Specificity weights of the predicate kinds. Exact values are more specific
+// than patterns, which are more specific than presence checks.
+const (
+	scoreMethod  = 1
+	scorePresent = 1
+	scoreRegex   = 2
+	scoreExact   = 3
+)
+
+// valueMatcher checks a single named request value
+type valueMatcher struct {
+	name  string
+	value string
+	regex *regexp.Regexp
+}
+
+// matches reports whether a value satisfies the rule
+func (m valueMatcher) matches(value []byte, present bool) bool {
+	switch {
+	case !present:
+		return false
+	case m.regex != nil:
+		return m.regex.Match(value)
+	case m.value != "":
+		return string(value) == m.value
+	default:
+		return true
+	}
+}
+
+// score returns the specificity of the rule
+func (m valueMatcher) score() int {
+	switch {
+	case m.regex != nil:
+		return scoreRegex
+	case m.value != "":
+		return scoreExact
+	default:
+		return scorePresent
+	}
+}
+
+// routeMatcher evaluates the request predicates of a service route
+type routeMatcher struct {
+	methods map[string]struct{}
+	headers []valueMatcher
+	query   []valueMatcher
+	cookies []valueMatcher
+}
+
+// newRouteMatcher compiles the predicates of a service
+func newRouteMatcher(cfg config.RouteMatchConfig) (*routeMatcher, error) {
+	m := &routeMatcher{}
+
+	if len(cfg.Methods) > 0 {
+		m.methods = make(map[string]struct{}, len(cfg.Methods))
+		for _, method := range cfg.Methods {
+			method = strings.ToUpper(strings.TrimSpace(method))
+			if method == "" {
+				return nil, fmt.Errorf("empty method")
+			}
+			m.methods[method] = struct{}{}
+		}
+	}
+
+	var err error
+	if m.headers, err = compileRules("header", cfg.Headers); err != nil {
+		return nil, err
+	}
+	if m.query, err = compileRules("query", cfg.Query); err != nil {
+		return nil, err
+	}
+	if m.cookies, err = compileRules("cookie", cfg.Cookies); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// compileRules validates the rules of one predicate kind
+func compileRules(kind string, rules []config.MatchRuleConfig) ([]valueMatcher, error) {
+	matchers := make([]valueMatcher, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("%s predicate has no name", kind)
+		}
+		if rule.Value != "" && rule.Regex != "" {
+			return nil, fmt.Errorf("%s predicate %s sets both value and regex", kind, rule.Name)
+		}
+
+		m := valueMatcher{name: rule.Name, value: rule.Value}
+		if rule.Regex != "" {
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("%s predicate %s: invalid regex: %w", kind, rule.Name, err)
+			}
+			m.regex = re
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// matches evaluates the predicates in a fixed order: method, headers, query
+// parameters and cookies, each in configuration order
+func (m *routeMatcher) matches(c *fiber.Ctx) bool {
+	if m.methods != nil {
+		if _, ok := m.methods[requestMethod(c)]; !ok {
+			return false
+		}
+	}
+
+	for _, h := range m.headers {
+		value := c.Request().Header.Peek(h.name)
+		if !h.matches(value, value != nil) {
+			return false
+		}
+	}
+
+	args := c.Context().QueryArgs()
+	for _, q := range m.query {
+		if !q.matches(args.Peek(q.name), args.Has(q.name)) {
+			return false
+		}
+	}
+
+	for _, ck := range m.cookies {
+		value := c.Request().Header.Cookie(ck.name)
+		if !ck.matches(value, value != nil) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// requestMethod returns the method a request is matched with. CORS preflights
+// are matched with the method they announce, so they reach the pipeline of
+// the route that serves the actual request.
+func requestMethod(c *fiber.Ctx) string {
+	if c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderOrigin) != "" {
+		if method := c.Get(fiber.HeaderAccessControlRequestMethod); method != "" {
+			return strings.ToUpper(method)
+		}
+	}
+	return c.Method()
+}
+
+// score returns the specificity of the route, used to try more precise
+// routes before broader ones on the same path
+func (m *routeMatcher) score() int {
+	score := 0
+	if m.methods != nil {
+		score += scoreMethod
+	}
+	for _, rules := range [][]valueMatcher{m.headers, m.query, m.cookies} {
+		for _, rule := range rules {
+			score += rule.score()
+		}
+	}
+	return score
+}
+
+// disjoint reports whether no request can match both routes, which is only
+// known when they allow different methods or require different exact values
+func (m *routeMatcher) disjoint(other *routeMatcher) bool {
+	if m.methods != nil && other.methods != nil {
+		shared := false
+		for method := range m.methods {
+			if _, ok := other.methods[method]; ok {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			return true
+		}
+	}
+
+	return conflicting(m.headers, other.headers, strings.EqualFold) ||
+		conflicting(m.query, other.query, stringsEqual) ||
+		conflicting(m.cookies, other.cookies, stringsEqual)
+}
+
+// conflicting reports whether two rule sets require different exact values for the same name
+func conflicting(a, b []valueMatcher, sameName func(string, string) bool) bool {
+	for _, ra := range a {
+		for _, rb := range b {
+			if ra.regex == nil && rb.regex == nil && ra.value != "" && rb.value != "" &&
+				sameName(ra.name, rb.name) && ra.value != rb.value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stringsEqual compares names that are case-sensitive
+func stringsEqual(a, b string) bool {
+	return a == b
+}
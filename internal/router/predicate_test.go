@@ -0,0 +1,248 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// evaluate serves a request with a handler that reports how the request
+// matched, such as the name of the matched route, and returns the report
+func evaluate(t *testing.T, req *http.Request, handler func(c *fiber.Ctx) string) string {
+	t.Helper()
+
+	app := fiber.New()
+	app.All("/*", func(c *fiber.Ctx) error {
+		return c.SendString(handler(c))
+	})
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRouteMatcherMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		match config.RouteMatchConfig
+		query string
+		// request changes the GET request to /api with the query
+		request func(req *http.Request)
+		want    bool
+	}{
+		{
+			name: "no predicates",
+			want: true,
+		},
+		{
+			name:    "method",
+			match:   config.RouteMatchConfig{Methods: []string{" post ", "PUT"}},
+			request: func(req *http.Request) { req.Method = http.MethodPost },
+			want:    true,
+		},
+		{
+			name:  "other method",
+			match: config.RouteMatchConfig{Methods: []string{"POST"}},
+		},
+		{
+			name:    "header present",
+			match:   config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Beta"}}},
+			request: func(req *http.Request) { req.Header.Set("X-Beta", "") },
+			want:    true,
+		},
+		{
+			name:  "header missing",
+			match: config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Beta"}}},
+		},
+		{
+			name:    "header value ignores name case",
+			match:   config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "x-version", Value: "2"}}},
+			request: func(req *http.Request) { req.Header.Set("X-Version", "2") },
+			want:    true,
+		},
+		{
+			name:    "header value differs",
+			match:   config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Version", Value: "2"}}},
+			request: func(req *http.Request) { req.Header.Set("X-Version", "20") },
+		},
+		{
+			name:    "header regex",
+			match:   config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "User-Agent", Regex: "(?i)mobile"}}},
+			request: func(req *http.Request) { req.Header.Set("User-Agent", "Game Client Mobile/1.0") },
+			want:    true,
+		},
+		{
+			name:  "empty query parameter is present",
+			match: config.RouteMatchConfig{Query: []config.MatchRuleConfig{{Name: "debug"}}},
+			query: "debug",
+			want:  true,
+		},
+		{
+			name:  "query value",
+			match: config.RouteMatchConfig{Query: []config.MatchRuleConfig{{Name: "region", Value: "eu"}}},
+			query: "region=eu",
+			want:  true,
+		},
+		{
+			name:  "query names are case-sensitive",
+			match: config.RouteMatchConfig{Query: []config.MatchRuleConfig{{Name: "region", Value: "eu"}}},
+			query: "Region=eu",
+		},
+		{
+			name:    "cookie value",
+			match:   config.RouteMatchConfig{Cookies: []config.MatchRuleConfig{{Name: "cohort", Value: "beta"}}},
+			request: func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "cohort", Value: "beta"}) },
+			want:    true,
+		},
+		{
+			name:    "cookie missing",
+			match:   config.RouteMatchConfig{Cookies: []config.MatchRuleConfig{{Name: "cohort", Value: "beta"}}},
+			request: func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "session", Value: "beta"}) },
+		},
+		{
+			name: "all predicates must match",
+			match: config.RouteMatchConfig{
+				Methods: []string{"GET"},
+				Headers: []config.MatchRuleConfig{{Name: "X-Version", Value: "2"}},
+				Query:   []config.MatchRuleConfig{{Name: "region", Value: "eu"}},
+			},
+			request: func(req *http.Request) { req.Header.Set("X-Version", "2") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newRouteMatcher(tt.match)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api?"+tt.query, nil)
+			if tt.request != nil {
+				tt.request(req)
+			}
+
+			got := evaluate(t, req, func(c *fiber.Ctx) string {
+				if m.matches(c) {
+					return "match"
+				}
+				return ""
+			})
+			if (got == "match") != tt.want {
+				t.Errorf("matches = %v, want %v", got == "match", tt.want)
+			}
+		})
+	}
+}
+
+func TestNewRouteMatcherErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		match config.RouteMatchConfig
+	}{
+		{name: "empty method", match: config.RouteMatchConfig{Methods: []string{" "}}},
+		{name: "rule without name", match: config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Value: "2"}}}},
+		{name: "value and regex", match: config.RouteMatchConfig{Query: []config.MatchRuleConfig{{Name: "v", Value: "2", Regex: "2"}}}},
+		{name: "invalid regex", match: config.RouteMatchConfig{Cookies: []config.MatchRuleConfig{{Name: "c", Regex: "("}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRouteMatcher(tt.match); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRouteMatcherScoreAndDisjoint(t *testing.T) {
+	version := func(value string) config.RouteMatchConfig {
+		return config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Version", Value: value}}}
+	}
+
+	tests := []struct {
+		name         string
+		a, b         config.RouteMatchConfig
+		wantScores   [2]int
+		wantDisjoint bool
+	}{
+		{
+			name:       "no predicates",
+			wantScores: [2]int{0, 0},
+		},
+		{
+			name:         "different methods",
+			a:            config.RouteMatchConfig{Methods: []string{"GET"}},
+			b:            config.RouteMatchConfig{Methods: []string{"POST", "PUT"}},
+			wantScores:   [2]int{1, 1},
+			wantDisjoint: true,
+		},
+		{
+			name:       "shared method",
+			a:          config.RouteMatchConfig{Methods: []string{"GET", "POST"}},
+			b:          config.RouteMatchConfig{Methods: []string{"POST"}},
+			wantScores: [2]int{1, 1},
+		},
+		{
+			name:         "different exact values",
+			a:            version("1"),
+			b:            version("2"),
+			wantScores:   [2]int{3, 3},
+			wantDisjoint: true,
+		},
+		{
+			name:       "same exact value",
+			a:          version("1"),
+			b:          version("1"),
+			wantScores: [2]int{3, 3},
+		},
+		{
+			name:       "regexes may overlap",
+			a:          config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Version", Regex: "^1"}}},
+			b:          config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Version", Regex: "^2"}}},
+			wantScores: [2]int{2, 2},
+		},
+		{
+			name: "presence and exact value",
+			a:    config.RouteMatchConfig{Cookies: []config.MatchRuleConfig{{Name: "cohort"}}},
+			b: config.RouteMatchConfig{
+				Methods: []string{"GET"},
+				Cookies: []config.MatchRuleConfig{{Name: "cohort", Value: "beta"}},
+			},
+			wantScores: [2]int{1, 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newRouteMatcher(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := newRouteMatcher(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := [2]int{a.score(), b.score()}; got != tt.wantScores {
+				t.Errorf("scores = %v, want %v", got, tt.wantScores)
+			}
+			if got := a.disjoint(b); got != tt.wantDisjoint {
+				t.Errorf("disjoint = %v, want %v", got, tt.wantDisjoint)
+			}
+			if got := b.disjoint(a); got != tt.wantDisjoint {
+				t.Errorf("reverse disjoint = %v, want %v", got, tt.wantDisjoint)
+			}
+		})
+	}
+}
@@ -2,197 +2,497 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"api-gateway/internal/balancer"
 	"api-gateway/internal/config"
+	"api-gateway/internal/discovery"
+	"api-gateway/internal/health"
+	"api-gateway/internal/middleware"
 	"api-gateway/internal/proxy"
-	"api-gateway/internal/resilience"
 	"api-gateway/pkg/logging"
+	"api-gateway/pkg/metrics"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
+// Locals keys of a matched request
+const (
+	routeKey   = "route"
+	routingKey = "routing"
+)
+
 // Router handles dynamic routing and service discovery
 type Router struct {
-	config     *config.Config
-	logger     *logging.Logger
-	httpProxy  *proxy.HTTPProxy
-	wsProxy    *proxy.WebSocketProxy
-	breaker    *resilience.CircuitBreaker
-	retrier    *resilience.Retrier
+	config      *config.Config
+	logger      *logging.Logger
+	httpProxy   *proxy.HTTPProxy
+	wsProxy     *proxy.WebSocketProxy
+	grpcProxy   *proxy.GRPCProxy
+	upstreamTLS *proxy.UpstreamTLS
+	checker     *health.Checker
+	outliers    *health.OutlierDetector
+	canaries    *health.CanaryController
+	discovery   *discovery.Manager
+	// Per target group request metrics, used to compare canaries with stable targets
+	groupRequests *prometheus.CounterVec
+	groupDuration *prometheus.HistogramVec
+
+	// routing is the live snapshot of the services, replaced as a whole on
+	// every change; mu serializes the changes
+	routing atomic.Pointer[routing]
+	mu      sync.Mutex
 }
 
 // New creates a new router instance
 func New(cfg *config.Config, logger *logging.Logger) (*Router, error) {
+	if cfg.Admin.Enable && cfg.Admin.Token == "" {
+		return nil, fmt.Errorf("admin API requires a token")
+	}
+
+	// Load the TLS settings the proxies connect to targets with
+	upstreamTLS, err := proxy.NewUpstreamTLS(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create HTTP proxy
-	httpProxy, err := proxy.NewHTTPProxy(cfg, logger)
+	httpProxy, err := proxy.NewHTTPProxy(cfg, logger, upstreamTLS)
 	if err != nil {
+		upstreamTLS.Close()
 		return nil, fmt.Errorf("failed to create HTTP proxy: %w", err)
 	}
 
 	// Create WebSocket proxy
-	wsProxy, err := proxy.NewWebSocketProxy(cfg, logger)
+	wsProxy, err := proxy.NewWebSocketProxy(cfg, logger, upstreamTLS)
 	if err != nil {
+		upstreamTLS.Close()
 		return nil, fmt.Errorf("failed to create WebSocket proxy: %w", err)
 	}
 
-	// Create circuit breaker if enabled
-	var breaker *resilience.CircuitBreaker
-	if cfg.Resilience.EnableCircuitBreaker {
-		breaker, err = resilience.NewCircuitBreaker(cfg, logger)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create circuit breaker: %w", err)
+	// Create gRPC proxy
+	grpcProxy, err := proxy.NewGRPCProxy(cfg, logger, upstreamTLS)
+	if err != nil {
+		upstreamTLS.Close()
+		return nil, fmt.Errorf("failed to create gRPC proxy: %w", err)
+	}
+
+	// Validate the services and build the routes before starting background work
+	rs, err := buildRouting(cfg, cfg.Services, nil, logger)
+	if err != nil {
+		grpcProxy.Close()
+		upstreamTLS.Close()
+		return nil, err
+	}
+
+	// Resolve and watch targets that come from service discovery
+	disc, err := discovery.NewManager(cfg, logger, rs.pools, nil)
+	if err != nil {
+		rs.close(nil)
+		grpcProxy.Close()
+		upstreamTLS.Close()
+		return nil, fmt.Errorf("failed to start service discovery: %w", err)
+	}
+
+	// Start active health checks for the targets
+	checker, err := health.NewChecker(cfg, logger, rs.pools, upstreamTLS)
+	if err != nil {
+		disc.Close()
+		rs.close(nil)
+		grpcProxy.Close()
+		upstreamTLS.Close()
+		return nil, fmt.Errorf("failed to create health checker: %w", err)
+	}
+
+	// Watch proxied traffic for misbehaving targets
+	outliers, err := health.NewOutlierDetector(cfg, logger, rs.pools)
+	if err != nil {
+		checker.Close()
+		disc.Close()
+		rs.close(nil)
+		grpcProxy.Close()
+		upstreamTLS.Close()
+		return nil, fmt.Errorf("failed to create outlier detector: %w", err)
+	}
+
+	// Step canary target groups forward while they perform like the stable group
+	canaries, err := health.NewCanaryController(cfg, logger, rs.pools)
+	if err != nil {
+		checker.Close()
+		disc.Close()
+		rs.close(nil)
+		grpcProxy.Close()
+		upstreamTLS.Close()
+		return nil, fmt.Errorf("failed to create canary controller: %w", err)
+	}
+
+	r := &Router{
+		config:      cfg,
+		logger:      logger,
+		httpProxy:   httpProxy,
+		wsProxy:     wsProxy,
+		grpcProxy:   grpcProxy,
+		upstreamTLS: upstreamTLS,
+		checker:     checker,
+		outliers:    outliers,
+		canaries:    canaries,
+		discovery:   disc,
+
+		groupRequests: metrics.NewUpstreamGroupRequests(),
+		groupDuration: metrics.NewUpstreamGroupRequestDuration(),
+	}
+	r.routing.Store(rs)
+	return r, nil
+}
+
+// Collectors returns the Prometheus collectors owned by the router
+func (r *Router) Collectors() []prometheus.Collector {
+	collectors := append(r.checker.Collectors(), r.outliers.Collectors()...)
+	collectors = append(collectors, r.canaries.Collectors()...)
+	collectors = append(collectors, r.httpProxy.Collectors()...)
+	collectors = append(collectors, r.grpcProxy.Collectors()...)
+	pools := func() map[string]*balancer.Pool {
+		return r.routing.Load().pools
+	}
+	return append(collectors, balancer.NewWeightCollector(pools), r.groupRequests, r.groupDuration)
+}
+
+// Close stops background work of the router
+func (r *Router) Close() {
+	r.discovery.Close()
+	r.checker.Close()
+	r.canaries.Close()
+	r.routing.Load().close(nil)
+	r.grpcProxy.Close()
+	r.upstreamTLS.Close()
+}
+
+// Services returns the configuration of the live services
+func (r *Router) Services() []config.ServiceConfig {
+	return r.routing.Load().services
+}
+
+// Reload validates a new configuration and swaps in its services,
+// authentication, CORS and resilience settings as a whole. Open WebSocket
+// connections and requests already matched finish on the previous settings.
+func (r *Router) Reload(cfg *config.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.swap(cfg, cfg.Services)
+}
+
+// SetServices validates the services and swaps them in as a whole. Requests
+// already matched finish on the previous routes, and nothing changes when
+// the services are invalid.
+func (r *Router) SetServices(services []config.ServiceConfig) error {
+	return r.updateServices(func([]config.ServiceConfig) ([]config.ServiceConfig, error) {
+		return services, nil
+	})
+}
+
+// updateServices applies a change to a copy of the live services and swaps
+// the result in. Changes are serialized, so none of them is lost.
+func (r *Router) updateServices(change func(services []config.ServiceConfig) ([]config.ServiceConfig, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.routing.Load()
+	services, err := change(append([]config.ServiceConfig(nil), current.services...))
+	if err != nil {
+		return err
+	}
+
+	return r.swap(current.config, services)
+}
+
+// swap builds a snapshot of the configuration and services and makes it the
+// live one. The caller holds mu.
+func (r *Router) swap(cfg *config.Config, services []config.ServiceConfig) error {
+	current := r.routing.Load()
+	next, err := buildRouting(cfg, services, current, r.logger)
+	if err != nil {
+		return err
+	}
+
+	if err := r.applyServices(next); err != nil {
+		next.close(current)
+		// The current services were applied before, so they apply again
+		if rollbackErr := r.applyServices(current); rollbackErr != nil {
+			r.logger.Error("Failed to restore services", zap.Error(rollbackErr))
 		}
+		return err
 	}
 
-	// Create retrier if enabled
-	var retrier *resilience.Retrier
-	if cfg.Resilience.EnableRetry {
-		retrier, err = resilience.NewRetrier(cfg, logger)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create retrier: %w", err)
+	r.routing.Store(next)
+	current.close(next)
+
+	for _, rt := range next.table.routes {
+		if !current.unchanged(rt.service) {
+			r.logger.Info("Updated route",
+				zap.String("service", rt.service.Name),
+				zap.String("path", rt.basePath+"/*"),
+				zap.Strings("hosts", rt.service.Hosts))
+		}
+	}
+	for _, rt := range next.grpc.routes {
+		if !current.unchanged(rt.service) {
+			r.logger.Info("Updated gRPC route",
+				zap.String("service", rt.service.Name),
+				zap.Strings("grpc_services", rt.service.GRPC.Services),
+				zap.Strings("grpc_methods", rt.service.GRPC.Methods))
+		}
+	}
+	for _, svc := range current.services {
+		if _, ok := next.service(svc.Name); !ok {
+			r.logger.Info("Removed route", zap.String("service", svc.Name))
 		}
 	}
 
-	return &Router{
-		config:    cfg,
-		logger:    logger,
-		httpProxy: httpProxy,
-		wsProxy:   wsProxy,
-		breaker:   breaker,
-		retrier:   retrier,
-	}, nil
+	return nil
 }
 
-// RegisterService registers a service with the router
-func (r *Router) RegisterService(app *fiber.App, svc config.ServiceConfig) error {
-	// Create base path for the service
-	basePath := svc.BasePath
-	if !strings.HasPrefix(basePath, "/") {
-		basePath = "/" + basePath
-	}
-
-	// Special handling for WebSocket routes if enabled
-	if svc.EnableWebSocket {
-		wsPath := basePath
-		wsPath = strings.TrimSuffix(wsPath, "/")
-
-		// Dynamic WebSocket path
-		websocketPath := wsPath + "/*"
-
-		app.Get(websocketPath, func(c *fiber.Ctx) error {
-			if websocket.IsWebSocketUpgrade(c) {
-				// Prepare headers
-				headers := make(map[string]string)
-				for key, values := range c.GetReqHeaders() {
-					for _, value := range values {
-						if value != "" {
-							if !strings.HasPrefix(strings.ToLower(key), "sec-websocket-") &&
-							!strings.EqualFold(key, "Upgrade") &&
-							!strings.EqualFold(key, "Connection") {
-								headers[key] = value
-							}
-						}
-					}
-				}
-				for _, key := range []string{
-					"Sec-WebSocket-Key",
-					"Sec-WebSocket-Version",
-					"Sec-WebSocket-Extensions",
-					"Sec-WebSocket-Protocol",
-				} {
-					if value := c.Get(key); value != "" {
-						headers[key] = value
-					}
-				}
-				headers["X-Real-IP"] = c.IP()
-				headers["X-Forwarded-For"] = c.Get("X-Forwarded-For")
-				if headers["X-Forwarded-For"] == "" {
-					headers["X-Forwarded-For"] = c.IP()
-				}
-				queryString := string(c.Context().QueryArgs().QueryString())
-				if queryString != "" {
-					headers["X-Original-Query"] = queryString
-				}
+// applyServices hands the services and pools of a snapshot to the background workers
+func (r *Router) applyServices(rs *routing) error {
+	if err := r.upstreamTLS.Update(rs.services); err != nil {
+		return err
+	}
+	if err := r.httpProxy.SetServices(rs.services); err != nil {
+		return err
+	}
+	r.grpcProxy.SetServices(rs.pools)
+	if err := r.discovery.Update(rs.services, rs.pools); err != nil {
+		return fmt.Errorf("failed to start service discovery: %w", err)
+	}
+	if err := r.checker.Update(rs.services, rs.pools); err != nil {
+		return err
+	}
+	r.outliers.Update(rs.services, rs.pools)
+	return r.canaries.Update(rs.services, rs.pools)
+}
 
-				fullPath := c.Path()
-				if queryString != "" {
-					fullPath = fmt.Sprintf("%s?%s", strings.TrimSuffix(fullPath, "/*"), queryString)
-				} else {
-					fullPath = strings.TrimSuffix(fullPath, "/*")
-				}
-				c.Locals("ws_headers", headers)
-				c.Locals("ws_path", fullPath)
-				c.Locals("allowed", true)
-
-				// Store trace context for WebSocket handler
-				c.Locals("trace_context", c.UserContext())
-
-				return websocket.New(func(conn *websocket.Conn) {
-					wsHeaders := conn.Locals("ws_headers").(map[string]string)
-					wsPath := conn.Locals("ws_path").(string)
-
-					// Get trace context from locals
-					var ctx context.Context
-					if traceCtx, ok := conn.Locals("trace_context").(context.Context); ok {
-						ctx = traceCtx
-					} else {
-						ctx = context.Background()
-					}
-
-					if err := r.handleWebSocket(conn, svc, wsPath, wsHeaders, ctx); err != nil {
-						r.logger.Error("WebSocket handling error",
-							zap.Error(err),
-							zap.String("service", svc.Name),
-							zap.String("path", wsPath))
-					}
-				}, websocket.Config{
-					HandshakeTimeout: 10 * time.Second,
-				})(c)
-			}
+// Register installs the route table on the app. Every request is dispatched
+// to the first matching route, so precedence does not depend on the order of
+// the services in the configuration.
+func (r *Router) Register(app *fiber.App) {
+	for _, rt := range r.routing.Load().table.routes {
+		r.logger.Info("Registered route",
+			zap.String("service", rt.service.Name),
+			zap.String("path", rt.basePath+"/*"),
+			zap.Strings("hosts", rt.service.Hosts),
+			zap.Bool("websocket", rt.service.EnableWebSocket))
+	}
+	for _, rt := range r.routing.Load().grpc.routes {
+		r.logger.Info("Registered gRPC route",
+			zap.String("service", rt.service.Name),
+			zap.Strings("grpc_services", rt.service.GRPC.Services),
+			zap.Strings("grpc_methods", rt.service.GRPC.Methods))
+	}
+
+	// The route is matched once, then its middleware pipeline runs before the
+	// request is dispatched. Fiber advances through the handlers of a route
+	// with c.Next(), so every pipeline position is a handler of its own.
+	handlers := []fiber.Handler{r.match}
+	for i := 0; i < middleware.MaxPipelineLength; i++ {
+		handlers = append(handlers, r.pipelineStep(i))
+	}
+	handlers = append(handlers, r.dispatch)
+
+	app.All("/*", handlers...)
+}
+
+// match finds the route of a request. Services in maintenance answer before
+// their pipeline runs, so clients learn about it without authenticating.
+func (r *Router) match(c *fiber.Ctx) error {
+	rs := r.routing.Load()
+	rt := rs.table.match(c, rs.hosts.resolve(c))
+	if rt == nil {
+		return c.Next()
+	}
+
+	// Maintenance also turns away WebSocket upgrades
+	maint := rs.maintenance[rt.service.Name]
+	if active, retryAfter := maint.active(time.Now()); active {
+		return maint.serve(c, retryAfter)
+	}
+
+	c.Locals(routeKey, rt)
+	c.Locals(routingKey, rs)
+	return c.Next()
+}
+
+// pipelineStep runs the middleware at a position of the route pipeline, or
+// skips ahead when the pipeline is shorter
+func (r *Router) pipelineStep(i int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rt, ok := c.Locals(routeKey).(*route)
+		if !ok || i >= rt.pipeline.Len() {
 			return c.Next()
-		})
+		}
+		return rt.pipeline.Handler(i)(c)
 	}
+}
 
-	// Register HTTP routes
-	// Add trailing slash if not present for HTTP path matching
-	if !strings.HasSuffix(basePath, "/") {
-		basePath = basePath + "/"
+// dispatch routes a request to the service of its matched route
+func (r *Router) dispatch(c *fiber.Ctx) error {
+	rt, ok := c.Locals(routeKey).(*route)
+	if !ok {
+		return c.Next()
 	}
+	rs := c.Locals(routingKey).(*routing)
+	svc := rt.service
 
-	app.All(basePath+"*", func(c *fiber.Ctx) error {
-		r.logger.Info("Handling request",
-		zap.String("method", c.Method()),
-		zap.String("path", c.Path()),
-		zap.String("original_url", string(c.Request().URI().Path())),
-		)
-		// Skip if this is a WebSocket request that should be handled by the WebSocket handler
-		if websocket.IsWebSocketUpgrade(c) {
+	if response, ok := rs.responses[svc.Name]; ok {
+		return response.serve(c)
+	}
+	if redir, ok := rs.redirects[svc.Name]; ok {
+		return redir.serve(c)
+	}
+
+	if websocket.IsWebSocketUpgrade(c) {
+		if !svc.EnableWebSocket {
 			return c.Next()
 		}
+		return r.upgradeWebSocket(c, rs, svc)
+	}
+
+	r.logger.Info("Handling request",
+	zap.String("method", c.Method()),
+	zap.String("path", c.Path()),
+	zap.String("original_url", string(c.Request().URI().Path())),
+	)
+
+	// Map the request onto the upstream path and host
+	path, host := rs.rewriters[svc.Name].Rewrite(c.Path(), requestHost(c))
 
-		// Get the path without the base path if strip is enabled
-		path := c.Params("*")
-		if svc.StripBasePath {
-			path = strings.TrimPrefix(path, basePath)
+	// Handle HTTP request
+	return r.handleHTTP(c, rs, svc, path, host)
+}
+
+// upgradeWebSocket picks a target for the service and upgrades the connection
+func (r *Router) upgradeWebSocket(c *fiber.Ctx, rs *routing, svc config.ServiceConfig) error {
+	// Prepare headers
+	headers := make(map[string]string)
+	for key, values := range c.GetReqHeaders() {
+		for _, value := range values {
+			if value != "" {
+				if !strings.HasPrefix(strings.ToLower(key), "sec-websocket-") &&
+				!strings.EqualFold(key, "Upgrade") &&
+				!strings.EqualFold(key, "Connection") {
+					headers[key] = value
+				}
+			}
 		}
+	}
+	for _, key := range []string{
+		"Sec-WebSocket-Key",
+		"Sec-WebSocket-Version",
+		"Sec-WebSocket-Extensions",
+		"Sec-WebSocket-Protocol",
+	} {
+		if value := c.Get(key); value != "" {
+			headers[key] = value
+		}
+	}
+	headers["X-Real-IP"] = c.IP()
+	headers["X-Forwarded-For"] = c.Get("X-Forwarded-For")
+	if headers["X-Forwarded-For"] == "" {
+		headers["X-Forwarded-For"] = c.IP()
+	}
+	queryString := string(c.Context().QueryArgs().QueryString())
+	if queryString != "" {
+		headers["X-Original-Query"] = queryString
+	}
 
-		// Handle HTTP request
-		return r.handleHTTP(c, svc, path)
-	})
+	// Rewrite the path the same way as for HTTP requests, unless the service
+	// has its own upstream path for WebSocket connections
+	protocol := proxy.WebSocketProtocol(svc.WebSocket)
+	rewriter := rs.rewriters[svc.Name]
+	upstreamPath, upstreamHost := rewriter.Rewrite(c.Path(), requestHost(c))
+	if svc.WebSocket.Path != "" {
+		upstreamPath = rewriter.Expand(svc.WebSocket.Path, c.Path(), requestHost(c))
+		if protocol == proxy.WebSocketSocketIO {
+			upstreamPath = proxy.SocketIOPath(upstreamPath)
+		}
+	}
+	fullPath := upstreamPath
+	if queryString != "" {
+		fullPath = fmt.Sprintf("%s?%s", upstreamPath, queryString)
+	}
+	// GraphQL clients must offer a subprotocol the gateway can negotiate
+	var subprotocols []string
+	if protocol == proxy.WebSocketGraphQL {
+		if !offersAny(c.Get("Sec-WebSocket-Protocol"), proxy.GraphQLSubprotocols) {
+			return fiber.NewError(fiber.StatusBadRequest, "GraphQL over WebSocket requires the graphql-transport-ws or graphql-ws subprotocol")
+		}
+		subprotocols = proxy.GraphQLSubprotocols
+	}
 
-	r.logger.Info("Registered HTTP route", zap.String("service", svc.Name), zap.String("path", basePath+"*"))
+	// Pick the target before upgrading so affinity can be issued on the handshake
+	target, err := r.getTarget(c, rs, svc)
+	if err != nil {
+		return err
+	}
 
-	return nil
+	c.Locals("ws_headers", headers)
+	c.Locals("ws_path", fullPath)
+	c.Locals("ws_host", upstreamHost)
+	c.Locals("ws_target", target)
+	c.Locals("allowed", true)
+
+	// Store trace context for WebSocket handler
+	c.Locals("trace_context", c.UserContext())
+
+	return websocket.New(func(conn *websocket.Conn) {
+		wsHeaders := conn.Locals("ws_headers").(map[string]string)
+		wsPath := conn.Locals("ws_path").(string)
+		wsHost := conn.Locals("ws_host").(string)
+		wsTarget := conn.Locals("ws_target").(*balancer.Target)
+
+		// Get trace context from locals
+		var ctx context.Context
+		if traceCtx, ok := conn.Locals("trace_context").(context.Context); ok {
+			ctx = traceCtx
+		} else {
+			ctx = context.Background()
+		}
+
+		if err := r.handleWebSocket(conn, svc, wsTarget, wsPath, wsHost, protocol, wsHeaders, ctx); err != nil {
+			r.logger.Error("WebSocket handling error",
+				zap.Error(err),
+				zap.String("service", svc.Name),
+				zap.String("path", wsPath))
+		}
+	}, websocket.Config{
+		HandshakeTimeout: 10 * time.Second,
+		Subprotocols:     subprotocols,
+	})(c)
+}
+
+// offersAny reports whether a Sec-WebSocket-Protocol value offers one of the subprotocols
+func offersAny(value string, subprotocols []string) bool {
+	for _, offered := range proxy.Subprotocols(value) {
+		for _, proto := range subprotocols {
+			if offered == proto {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // handleHTTP handles HTTP requests
-func (r *Router) handleHTTP(c *fiber.Ctx, svc config.ServiceConfig, path string) error {
+func (r *Router) handleHTTP(c *fiber.Ctx, rs *routing, svc config.ServiceConfig, path, host string) error {
 	// Add request ID header if not present
 	requestID := c.Get("X-Request-ID")
 	if requestID == "" {
@@ -206,81 +506,169 @@ func (r *Router) handleHTTP(c *fiber.Ctx, svc config.ServiceConfig, path string)
 	}
 
 	// Get target URL
-	target, err := r.getTarget(svc)
+	target, err := r.getTarget(c, rs, svc)
 	if err != nil {
 		return err
 	}
 
+	// Track outstanding requests for load-aware balancing
+	target.Acquire()
+	defer target.Release()
+
 	// Log the request routing
 	r.logger.Debug("Routing request",
 		zap.String("method", c.Method()),
 		zap.String("path", c.Path()),
-		zap.String("target", target),
+		zap.String("target", target.URL),
 		zap.String("service", svc.Name),
 		zap.String("request_id", requestID),
 	)
 
+	// Forward the request and report the outcome of every attempt
+	forward := func() error {
+		start := time.Now()
+		err := r.httpProxy.Forward(c, target.URL, path, host, svc, rs.config)
+		elapsed := time.Since(start)
+		outcome := outcomeOf(c, err)
+		r.outliers.Report(svc.Name, target, outcome)
+		r.canaries.Report(svc.Name, target.Group(), outcome, elapsed)
+		r.groupRequests.WithLabelValues(svc.Name, target.Group(), outcome.String()).Inc()
+		r.groupDuration.WithLabelValues(svc.Name, target.Group()).Observe(elapsed.Seconds())
+		return err
+	}
+
 	// Handle request with resilience patterns if enabled
-	if r.config.Resilience.EnableCircuitBreaker && r.breaker != nil {
-		return r.breaker.Execute(func() error {
-			if r.config.Resilience.EnableRetry && r.retrier != nil {
-				return r.retrier.Execute(func() error {
-					return r.httpProxy.Forward(c, target, path, svc, r.config)
-				})
+	if rs.config.Resilience.EnableCircuitBreaker && rs.breaker != nil {
+		return rs.breaker.Execute(func() error {
+			if rs.config.Resilience.EnableRetry && rs.retrier != nil {
+				return rs.retrier.Execute(forward)
 			}
-			return r.httpProxy.Forward(c, target, path, svc, r.config)
-		})
-	} else if r.config.Resilience.EnableRetry && r.retrier != nil {
-		return r.retrier.Execute(func() error {
-			return r.httpProxy.Forward(c, target, path, svc, r.config)
+			return forward()
 		})
+	} else if rs.config.Resilience.EnableRetry && rs.retrier != nil {
+		return rs.retrier.Execute(forward)
 	}
 
 	// Forward the request directly
-	return r.httpProxy.Forward(c, target, path, svc, r.config)
+	return forward()
 }
 
 // handleWebSocket handles WebSocket connections
-func (r *Router) handleWebSocket(c *websocket.Conn, svc config.ServiceConfig, path string, headers map[string]string, ctx context.Context) error {
-	// Get target service URL
-	target, err := r.getTarget(svc)
-	if err != nil {
-		return fmt.Errorf("failed to get target service URL: %w", err)
-	}
-	wsPath := path
-	if svc.StripBasePath {
-		wsPath = strings.TrimPrefix(path, svc.BasePath)
-		if wsPath == "" {
-			wsPath = "/" // Boşsa kök dizine yönlendir
-		}
-	}
-
-    // İç servis için varsayılan WebSocket yolunu ekle (örneğin /socket.io)
-    if !strings.HasPrefix(wsPath, "/socket.io") {
-        wsPath = "/socket.io" + wsPath
-    }
-
+func (r *Router) handleWebSocket(c *websocket.Conn, svc config.ServiceConfig, target *balancer.Target, path, host, protocol string, headers map[string]string, ctx context.Context) error {
 	// Log computed path
 	r.logger.Info("Computed WebSocket path",
-		zap.String("wsPath", wsPath),
-		zap.String("service_name", svc.Name))
+		zap.String("wsPath", path),
+		zap.String("service_name", svc.Name),
+		zap.String("mode", protocol))
+
+	// Track the connection for load-aware balancing while it is open
+	target.Acquire()
+	defer target.Release()
 
 	// Proxy WebSocket connection
-	if err := r.wsProxy.ProxyWebSocket(c, target, wsPath, headers, ctx); err != nil {
+	err := r.wsProxy.ProxyWebSocket(c, svc.Name, target.URL, path, host, protocol, headers, ctx)
+	if errors.Is(err, proxy.ErrDial) {
+		r.outliers.Report(svc.Name, target, health.OutcomeGatewayError)
+	} else {
+		r.outliers.Report(svc.Name, target, health.OutcomeSuccess)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to proxy WebSocket: %w", err)
 	}
 
 	return nil
 }
 
-// getTarget returns a target URL for the service
-func (r *Router) getTarget(svc config.ServiceConfig) (string, error) {
-	// Simple round-robin load balancing
-	if len(svc.Targets) == 0 {
-		return "", fiber.NewError(fiber.StatusServiceUnavailable, "no targets available for service "+svc.Name)
+// getTarget picks a target for the service, honouring session affinity when enabled
+func (r *Router) getTarget(c *fiber.Ctx, rs *routing, svc config.ServiceConfig) (*balancer.Target, error) {
+	pool, ok := rs.pools[svc.Name]
+	if !ok {
+		return nil, fiber.NewError(fiber.StatusServiceUnavailable, "no targets available for service "+svc.Name)
+	}
+
+	key := hashKey(c, svc.HashKey)
+
+	aff, sticky := rs.affinities[svc.Name]
+	if !sticky {
+		return pool.PickForKey(key)
+	}
+
+	// Keep the client on its pinned target while that target can take traffic,
+	// clients pinned to a failover tier move back once the primary tier recovers
+	if id := aff.lookup(c); id != "" {
+		if target, ok := pool.Lookup(id); ok && pool.Active(target) {
+			return target, nil
+		}
+		r.logger.Debug("Pinned target unavailable, re-balancing client",
+			zap.String("service", svc.Name),
+			zap.String("target_id", id))
+	}
+
+	target, err := pool.PickForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	aff.issue(c, target)
+	return target, nil
+}
+
+// hashKey extracts the consistent hashing key of a request
+func hashKey(c *fiber.Ctx, cfg config.HashKeyConfig) string {
+	switch cfg.Source {
+	case "header":
+		return c.Get(cfg.Name)
+	case "query":
+		return c.Query(cfg.Name)
+	case "cookie":
+		return c.Cookies(cfg.Name)
+	case "claim":
+		claims, _ := c.Locals("user").(jwt.MapClaims)
+		return claimValue(claims, cfg.Name)
+	case "ip":
+		return c.IP()
+	default:
+		return ""
+	}
+}
+
+// claimValue returns a JWT claim as a string, or an empty string when it is missing
+func claimValue(claims jwt.MapClaims, name string) string {
+	if value, ok := claims[name]; ok && value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// validateHashKey checks that a hash key configuration is usable
+func validateHashKey(cfg config.HashKeyConfig) error {
+	switch cfg.Source {
+	case "", "ip":
+		return nil
+	case "header", "query", "cookie", "claim":
+		if cfg.Name == "" {
+			return fmt.Errorf("hash key source %q requires a name", cfg.Source)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown hash key source %q", cfg.Source)
 	}
+}
 
-	// TODO: Implement more sophisticated load balancing and service discovery
-	// For now, just use the first target
-	return svc.Targets[0], nil
+// outcomeOf classifies the result of a proxied HTTP request for outlier detection
+func outcomeOf(c *fiber.Ctx, err error) health.Outcome {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		switch fiberErr.Code {
+		case fiber.StatusBadGateway, fiber.StatusGatewayTimeout:
+			return health.OutcomeGatewayError
+		}
+		if fiberErr.Code >= fiber.StatusInternalServerError {
+			return health.OutcomeServerError
+		}
+		return health.OutcomeSuccess
+	}
+	if err != nil || c.Response().StatusCode() >= fiber.StatusInternalServerError {
+		return health.OutcomeServerError
+	}
+	return health.OutcomeSuccess
 }
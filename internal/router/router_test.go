@@ -0,0 +1,199 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/proxy"
+	"api-gateway/pkg/logging"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRegisterPipelines(t *testing.T) {
+	setKey := config.MiddlewareConfig{Name: "headers", RequestHeaders: map[string]string{"X-API-Key": "key-1"}}
+	checkKey := config.MiddlewareConfig{Name: "api_key", Keys: []string{"key-1"}}
+	cors := config.MiddlewareConfig{Name: "cors", AllowOrigins: []string{"https://example.com"}}
+
+	tests := []struct {
+		name        string
+		middlewares []config.MiddlewareConfig
+		match       config.RouteMatchConfig
+		// request changes the GET request to /api
+		request func(req *http.Request)
+		want    int
+	}{
+		{
+			name: "no middlewares",
+			want: http.StatusOK,
+		},
+		{
+			name:        "middlewares run in order",
+			middlewares: []config.MiddlewareConfig{setKey, checkKey},
+			want:        http.StatusOK,
+		},
+		{
+			name:        "later middlewares do not run first",
+			middlewares: []config.MiddlewareConfig{checkKey, setKey},
+			want:        http.StatusUnauthorized,
+		},
+		{
+			name: "every middleware",
+			middlewares: []config.MiddlewareConfig{
+				cors, setKey, {Name: "security_headers"}, {Name: "rate_limit", Max: 10}, checkKey, {Name: "cache"},
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:        "preflight of a method the route takes",
+			middlewares: []config.MiddlewareConfig{cors, checkKey},
+			match:       config.RouteMatchConfig{Methods: []string{"GET"}},
+			request: func(req *http.Request) {
+				req.Method = http.MethodOptions
+				req.Header.Set("Origin", "https://example.com")
+				req.Header.Set("Access-Control-Request-Method", "GET")
+			},
+			want: http.StatusNoContent,
+		},
+		{
+			name:        "preflight of another method",
+			middlewares: []config.MiddlewareConfig{cors},
+			match:       config.RouteMatchConfig{Methods: []string{"GET"}},
+			request: func(req *http.Request) {
+				req.Method = http.MethodOptions
+				req.Header.Set("Origin", "https://example.com")
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			},
+			want: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, err := logging.NewLogger()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Services: []config.ServiceConfig{{
+					Name:       "api",
+					BasePath:   "/api",
+					Match:      tt.match,
+					Middleware: tt.middlewares,
+					Response:   config.DirectResponseConfig{Status: http.StatusOK, Body: "ok"},
+				}},
+			}
+			r, err := New(cfg, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			app := fiber.New()
+			r.Register(app)
+
+			req := httptest.NewRequest(http.MethodGet, "/api", nil)
+			if tt.request != nil {
+				tt.request(req)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffersAny(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "nothing offered", value: ""},
+		{name: "newer protocol", value: "graphql-transport-ws", want: true},
+		{name: "one of several", value: "chat, graphql-ws", want: true},
+		{name: "other protocols", value: "chat, graphql"},
+		{name: "case sensitive", value: "GraphQL-WS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := offersAny(tt.value, proxy.GraphQLSubprotocols); got != tt.want {
+				t.Errorf("offersAny(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.HashKeyConfig
+		claims jwt.MapClaims
+		want   string
+	}{
+		{name: "header", cfg: config.HashKeyConfig{Source: "header", Name: "X-User-ID"}, want: "user-1"},
+		{name: "query", cfg: config.HashKeyConfig{Source: "query", Name: "table"}, want: "42"},
+		{name: "cookie", cfg: config.HashKeyConfig{Source: "cookie", Name: "session"}, want: "s-1"},
+		{name: "string claim", cfg: config.HashKeyConfig{Source: "claim", Name: "sub"}, claims: jwt.MapClaims{"sub": "user-2"}, want: "user-2"},
+		{name: "numeric claim", cfg: config.HashKeyConfig{Source: "claim", Name: "tenant"}, claims: jwt.MapClaims{"tenant": float64(7)}, want: "7"},
+		{name: "missing claim", cfg: config.HashKeyConfig{Source: "claim", Name: "tenant"}, claims: jwt.MapClaims{"sub": "user-2"}},
+		{name: "no claims", cfg: config.HashKeyConfig{Source: "claim", Name: "sub"}},
+		{name: "ip", cfg: config.HashKeyConfig{Source: "ip"}, want: "0.0.0.0"},
+		{name: "missing header", cfg: config.HashKeyConfig{Source: "header", Name: "X-Tenant"}},
+		{name: "no source"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			app := fiber.New()
+			app.Get("/*", func(c *fiber.Ctx) error {
+				if tt.claims != nil {
+					c.Locals("user", tt.claims)
+				}
+				got = hashKey(c, tt.cfg)
+				return nil
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/games?table=42", nil)
+			req.Header.Set("X-User-ID", "user-1")
+			req.AddCookie(&http.Cookie{Name: "session", Value: "s-1"})
+			if _, err := app.Test(req); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("hash key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateHashKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.HashKeyConfig
+		wantErr bool
+	}{
+		{name: "not configured"},
+		{name: "ip", cfg: config.HashKeyConfig{Source: "ip"}},
+		{name: "header", cfg: config.HashKeyConfig{Source: "header", Name: "X-User-ID"}},
+		{name: "claim without name", cfg: config.HashKeyConfig{Source: "claim"}, wantErr: true},
+		{name: "unknown source", cfg: config.HashKeyConfig{Source: "body", Name: "id"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateHashKey(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package router
+
+import (
+	"fmt"
+	"reflect"
+
+	"api-gateway/internal/balancer"
+	"api-gateway/internal/config"
+	"api-gateway/internal/middleware"
+	"api-gateway/internal/proxy"
+	"api-gateway/internal/resilience"
+	"api-gateway/pkg/logging"
+)
+
+// routing is an immutable snapshot of the configuration and everything built
+// from it. Requests keep the snapshot they were matched with, so they finish
+// on it while a newer one is swapped in.
+type routing struct {
+	config      *config.Config
+	breaker     *resilience.CircuitBreaker
+	retrier     *resilience.Retrier
+	services    []config.ServiceConfig
+	hosts       *hostMatcher
+	table       *routeTable
+	grpc        *grpcTable
+	pools       map[string]*balancer.Pool
+	affinities  map[string]*affinity
+	rewriters   map[string]*proxy.Rewriter
+	responses   map[string]*directResponse
+	redirects   map[string]*redirect
+	maintenance map[string]*maintenance
+}
+
+// buildRouting validates the services and builds a routing snapshot. Services
+// unchanged since the previous snapshot keep their target pool, session
+// affinity and maintenance mode, and routes keep their middleware pipeline
+// while its settings are unchanged. Maintenance switched at runtime is kept
+// for changed services too.
+func buildRouting(cfg *config.Config, services []config.ServiceConfig, previous *routing, logger *logging.Logger) (*routing, error) {
+	breaker, retrier, err := buildResilience(cfg, previous, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the virtual hosts of all services
+	hosts, err := newHostMatcher(services, cfg.Server.DefaultHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service hosts: %w", err)
+	}
+
+	rs := &routing{
+		config:      cfg,
+		breaker:     breaker,
+		retrier:     retrier,
+		services:    services,
+		hosts:       hosts,
+		pools:       make(map[string]*balancer.Pool, len(services)),
+		affinities:  make(map[string]*affinity),
+		rewriters:   make(map[string]*proxy.Rewriter, len(services)),
+		responses:   make(map[string]*directResponse),
+		redirects:   make(map[string]*redirect),
+		maintenance: make(map[string]*maintenance, len(services)),
+	}
+
+	matchers := make(map[string]*routeMatcher, len(services))
+	chains := make(map[string][]config.MiddlewareConfig, len(services))
+	var grpcRoutes []*grpcRoute
+	defaults := middleware.Defaults(cfg)
+	for _, svc := range services {
+		rewriter, err := proxy.NewRewriter(svc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite for service %s: %w", svc.Name, err)
+		}
+		rs.rewriters[svc.Name] = rewriter
+
+		response, err := newDirectResponse(svc.Response)
+		if err != nil {
+			return nil, fmt.Errorf("invalid direct response for service %s: %w", svc.Name, err)
+		}
+		redir, err := newRedirect(svc.Redirect, rewriter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect for service %s: %w", svc.Name, err)
+		}
+		if response != nil && redir != nil {
+			return nil, fmt.Errorf("service %s configures both a direct response and a redirect", svc.Name)
+		}
+		if (response != nil || redir != nil) && len(svc.Targets) > 0 {
+			return nil, fmt.Errorf("service %s answers requests itself and takes no targets", svc.Name)
+		}
+		if response != nil {
+			rs.responses[svc.Name] = response
+		}
+		if redir != nil {
+			rs.redirects[svc.Name] = redir
+		}
+
+		chain, err := middleware.Resolve(defaults, svc.Middleware)
+		if err != nil {
+			return nil, fmt.Errorf("invalid middleware for service %s: %w", svc.Name, err)
+		}
+		chains[svc.Name] = chain
+
+		// gRPC routes check the credentials of the pipeline themselves
+		if isGRPC(svc) {
+			if err := validateGRPCRoute(svc); err != nil {
+				return nil, fmt.Errorf("invalid gRPC route for service %s: %w", svc.Name, err)
+			}
+			auth, err := middleware.NewAuthenticator(chain, cfg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid middleware for service %s: %w", svc.Name, err)
+			}
+			grpcRoutes = append(grpcRoutes, &grpcRoute{service: svc, auth: auth})
+		}
+
+		matcher, err := newRouteMatcher(svc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route predicates for service %s: %w", svc.Name, err)
+		}
+		matchers[svc.Name] = matcher
+
+		if err := proxy.ValidateWebSocket(svc.WebSocket); err != nil {
+			return nil, fmt.Errorf("invalid websocket settings for service %s: %w", svc.Name, err)
+		}
+
+		if err := validateHashKey(svc.HashKey); err != nil {
+			return nil, fmt.Errorf("invalid hash key for service %s: %w", svc.Name, err)
+		}
+
+		// Discovery targets are resolved into URLs later
+		for _, target := range svc.Targets {
+			if balancer.IsDiscoveryTarget(target) {
+				continue
+			}
+			if _, err := proxy.ParseUpstream(target); err != nil {
+				return nil, fmt.Errorf("invalid target for service %s: %w", svc.Name, err)
+			}
+		}
+
+		if previous.unchanged(svc) {
+			rs.pools[svc.Name] = previous.pools[svc.Name]
+			rs.maintenance[svc.Name] = previous.maintenance[svc.Name]
+			if aff, ok := previous.affinities[svc.Name]; ok {
+				rs.affinities[svc.Name] = aff
+			}
+			continue
+		}
+
+		maint, err := newMaintenance(svc.Maintenance)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maintenance for service %s: %w", svc.Name, err)
+		}
+		// Maintenance switched at runtime outlives changes to the service
+		if previous != nil {
+			if current, ok := previous.maintenance[svc.Name]; ok {
+				maint.setMode(current.currentMode())
+			}
+		}
+		rs.maintenance[svc.Name] = maint
+
+		pool, err := balancer.NewPool(svc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create target pool: %w", err)
+		}
+		rs.pools[svc.Name] = pool
+
+		if svc.EnableStickySession {
+			aff, err := newAffinity(svc, logger)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure sticky sessions for service %s: %w", svc.Name, err)
+			}
+			rs.affinities[svc.Name] = aff
+		}
+	}
+
+	// Order the routes and reject ambiguous ones before starting background work
+	rs.table, err = newRouteTable(services, matchers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid routes: %w", err)
+	}
+	rs.grpc, err = newGRPCTable(grpcRoutes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC routes: %w", err)
+	}
+
+	// Create the middleware pipelines of the routes
+	for _, rt := range rs.table.routes {
+		rt.middlewares = chains[rt.service.Name]
+		if pipeline := previous.pipeline(rt, cfg); pipeline != nil {
+			rt.pipeline = pipeline
+			continue
+		}
+		rt.pipeline, err = middleware.NewPipeline(rt.middlewares, cfg)
+		if err != nil {
+			rs.close(previous)
+			return nil, fmt.Errorf("invalid middleware for service %s: %w", rt.service.Name, err)
+		}
+	}
+
+	return rs, nil
+}
+
+// buildResilience creates the circuit breaker and retrier, keeping those of
+// the previous snapshot while their settings are unchanged
+func buildResilience(cfg *config.Config, previous *routing, logger *logging.Logger) (*resilience.CircuitBreaker, *resilience.Retrier, error) {
+	if previous != nil && reflect.DeepEqual(previous.config.Resilience, cfg.Resilience) {
+		return previous.breaker, previous.retrier, nil
+	}
+
+	// Create circuit breaker if enabled
+	var breaker *resilience.CircuitBreaker
+	var err error
+	if cfg.Resilience.EnableCircuitBreaker {
+		breaker, err = resilience.NewCircuitBreaker(cfg, logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create circuit breaker: %w", err)
+		}
+	}
+
+	// Create retrier if enabled
+	var retrier *resilience.Retrier
+	if cfg.Resilience.EnableRetry {
+		retrier, err = resilience.NewRetrier(cfg, logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create retrier: %w", err)
+		}
+	}
+
+	return breaker, retrier, nil
+}
+
+// pipeline returns the pipeline of a route of the snapshot when the route
+// resolves to the same middlewares with the same security settings
+func (rs *routing) pipeline(rt *route, cfg *config.Config) *middleware.Pipeline {
+	if rs == nil || !reflect.DeepEqual(rs.config.Security, cfg.Security) {
+		return nil
+	}
+	current := rs.table.lookup(rt.service.Name)
+	if current == nil || !reflect.DeepEqual(current.middlewares, rt.middlewares) {
+		return nil
+	}
+	return current.pipeline
+}
+
+// unchanged reports whether the snapshot has a service with the same configuration
+func (rs *routing) unchanged(svc config.ServiceConfig) bool {
+	if rs == nil {
+		return false
+	}
+	current, ok := rs.service(svc.Name)
+	return ok && reflect.DeepEqual(current, svc)
+}
+
+// service returns the configuration of a service
+func (rs *routing) service(name string) (config.ServiceConfig, bool) {
+	for _, svc := range rs.services {
+		if svc.Name == name {
+			return svc, true
+		}
+	}
+	return config.ServiceConfig{}, false
+}
+
+// close stops background work of the snapshot that the next snapshot does
+// not take over
+func (rs *routing) close(next *routing) {
+	kept := make(map[*middleware.Pipeline]struct{})
+	if next != nil {
+		for _, rt := range next.table.routes {
+			kept[rt.pipeline] = struct{}{}
+		}
+	}
+
+	for _, rt := range rs.table.routes {
+		if rt.pipeline == nil {
+			continue
+		}
+		if _, ok := kept[rt.pipeline]; !ok {
+			rt.pipeline.Close()
+		}
+	}
+}
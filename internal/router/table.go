@@ -0,0 +1,161 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"api-gateway/internal/config"
+	"api-gateway/internal/middleware"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// route is a single entry of the route table
+type route struct {
+	service  config.ServiceConfig
+	basePath string
+	hosts    map[string]struct{}
+	matcher  *routeMatcher
+	// middlewares is the resolved pipeline configuration
+	middlewares []config.MiddlewareConfig
+	pipeline    *middleware.Pipeline
+}
+
+// routeTable holds the service routes in precedence order
+type routeTable struct {
+	routes []*route
+}
+
+// newRouteTable builds the route table and rejects duplicate or ambiguous routes.
+// Routes are ordered by explicit priority, then host-bound routes before routes
+// for every host, then longest base path, then predicate specificity.
+func newRouteTable(services []config.ServiceConfig, matchers map[string]*routeMatcher) (*routeTable, error) {
+	t := &routeTable{}
+
+	names := make(map[string]struct{}, len(services))
+	for _, svc := range services {
+		if _, ok := names[svc.Name]; ok {
+			return nil, fmt.Errorf("duplicate service name %s", svc.Name)
+		}
+		names[svc.Name] = struct{}{}
+
+		// gRPC routes are matched by method in a table of their own
+		if isGRPC(svc) {
+			continue
+		}
+
+		rt := &route{
+			service:  svc,
+			basePath: strings.TrimSuffix("/"+strings.Trim(svc.BasePath, "/"), "/"),
+			matcher:  matchers[svc.Name],
+		}
+		if len(svc.Hosts) > 0 {
+			rt.hosts = make(map[string]struct{}, len(svc.Hosts))
+			for _, host := range svc.Hosts {
+				pattern, err := normalizeHostPattern(host)
+				if err != nil {
+					return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+				}
+				rt.hosts[pattern] = struct{}{}
+			}
+		}
+		t.routes = append(t.routes, rt)
+	}
+
+	sort.SliceStable(t.routes, func(i, j int) bool {
+		a, b := t.routes[i], t.routes[j]
+		if a.service.Priority != b.service.Priority {
+			return a.service.Priority > b.service.Priority
+		}
+		if hostBound := a.hosts != nil; hostBound != (b.hosts != nil) {
+			return hostBound
+		}
+		if len(a.basePath) != len(b.basePath) {
+			return len(a.basePath) > len(b.basePath)
+		}
+		return a.matcher.score() > b.matcher.score()
+	})
+
+	// Routes that tie on every precedence rule could both take a request
+	for i, a := range t.routes {
+		for _, b := range t.routes[i+1:] {
+			if a.ambiguous(b) {
+				path := a.basePath
+				if path == "" {
+					path = "/"
+				}
+				return nil, fmt.Errorf("services %s and %s have ambiguous routes for %s; "+
+					"use distinct hosts, base paths or predicates, or set a priority",
+					a.service.Name, b.service.Name, path)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// match returns the first route that takes the request, or nil
+func (t *routeTable) match(c *fiber.Ctx, host string) *route {
+	path := c.Path()
+	for _, rt := range t.routes {
+		if rt.serves(host) && rt.covers(path) && rt.matcher.matches(c) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// lookup returns the route of a service, or nil
+func (t *routeTable) lookup(service string) *route {
+	for _, rt := range t.routes {
+		if rt.service.Name == service {
+			return rt
+		}
+	}
+	return nil
+}
+
+// serves reports whether the route handles the resolved host pattern.
+// Routes without hosts handle every host.
+func (rt *route) serves(host string) bool {
+	if rt.hosts == nil {
+		return true
+	}
+	_, ok := rt.hosts[host]
+	return ok
+}
+
+// covers reports whether the path is the base path or below it
+func (rt *route) covers(path string) bool {
+	rest, ok := strings.CutPrefix(path, rt.basePath)
+	return ok && (rest == "" || strings.HasPrefix(rest, "/"))
+}
+
+// ambiguous reports whether both routes could take the same request
+// without any precedence rule deciding between them
+func (rt *route) ambiguous(other *route) bool {
+	if rt.service.Priority != other.service.Priority || rt.basePath != other.basePath {
+		return false
+	}
+	if !hostsOverlap(rt.hosts, other.hosts) {
+		return false
+	}
+	if rt.matcher.score() != other.matcher.score() {
+		return false
+	}
+	return !rt.matcher.disjoint(other.matcher)
+}
+
+// hostsOverlap reports whether two routes share a virtual host
+func hostsOverlap(a, b map[string]struct{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	for host := range a {
+		if _, ok := b[host]; ok {
+			return true
+		}
+	}
+	return false
+}
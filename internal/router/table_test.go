@@ -0,0 +1,250 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// buildTable compiles the predicates of the services and builds their route table
+func buildTable(services []config.ServiceConfig) (*routeTable, error) {
+	matchers := make(map[string]*routeMatcher, len(services))
+	for _, svc := range services {
+		m, err := newRouteMatcher(svc.Match)
+		if err != nil {
+			return nil, err
+		}
+		matchers[svc.Name] = m
+	}
+	return newRouteTable(services, matchers)
+}
+
+// header returns predicates that require a header value
+func header(name, value string) config.RouteMatchConfig {
+	return config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: name, Value: value}}}
+}
+
+func TestRouteTableOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []config.ServiceConfig
+		want     []string
+	}{
+		{
+			name: "priority first",
+			services: []config.ServiceConfig{
+				{Name: "users", BasePath: "/api/users", Hosts: []string{"api.example.com"}},
+				{Name: "api", BasePath: "/api", Priority: 10},
+			},
+			want: []string{"api", "users"},
+		},
+		{
+			name: "host-bound routes before routes for every host",
+			services: []config.ServiceConfig{
+				{Name: "users", BasePath: "/api/users"},
+				{Name: "api", BasePath: "/api", Hosts: []string{"api.example.com"}},
+			},
+			want: []string{"api", "users"},
+		},
+		{
+			name: "longest base path",
+			services: []config.ServiceConfig{
+				{Name: "root", BasePath: "/"},
+				{Name: "api", BasePath: "/api"},
+				{Name: "users", BasePath: "/api/users/"},
+			},
+			want: []string{"users", "api", "root"},
+		},
+		{
+			name: "predicate specificity",
+			services: []config.ServiceConfig{
+				{Name: "api", BasePath: "/api"},
+				{Name: "beta", BasePath: "/api", Match: config.RouteMatchConfig{Headers: []config.MatchRuleConfig{{Name: "X-Beta"}}}},
+				{Name: "v2", BasePath: "/api", Match: header("X-Version", "2")},
+			},
+			want: []string{"v2", "beta", "api"},
+		},
+		{
+			name: "configuration order between disjoint routes",
+			services: []config.ServiceConfig{
+				{Name: "write", BasePath: "/api", Match: config.RouteMatchConfig{Methods: []string{"POST"}}},
+				{Name: "read", BasePath: "/api", Match: config.RouteMatchConfig{Methods: []string{"GET"}}},
+			},
+			want: []string{"write", "read"},
+		},
+		{
+			name: "gRPC routes left out",
+			services: []config.ServiceConfig{
+				{Name: "api", BasePath: "/api"},
+				{Name: "wallet", GRPC: config.GRPCRouteConfig{Services: []string{"wallet.v1.Wallet"}}},
+			},
+			want: []string{"api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := buildTable(tt.services)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rt := range table.routes {
+				got = append(got, rt.service.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteTableAmbiguity(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    config.ServiceConfig
+		wantErr bool
+	}{
+		{
+			name:    "same base path",
+			a:       config.ServiceConfig{Name: "a", BasePath: "/api"},
+			b:       config.ServiceConfig{Name: "b", BasePath: "/api/"},
+			wantErr: true,
+		},
+		{
+			name:    "same root path",
+			a:       config.ServiceConfig{Name: "a"},
+			b:       config.ServiceConfig{Name: "b", BasePath: "/"},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate service name",
+			a:       config.ServiceConfig{Name: "a", BasePath: "/api"},
+			b:       config.ServiceConfig{Name: "a", BasePath: "/web"},
+			wantErr: true,
+		},
+		{
+			name: "different base paths",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api"},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api/users"},
+		},
+		{
+			name: "different priorities",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api"},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api", Priority: 1},
+		},
+		{
+			name: "different hosts",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api", Hosts: []string{"a.example.com"}},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api", Hosts: []string{"b.example.com", "*.example.com"}},
+		},
+		{
+			name:    "shared host",
+			a:       config.ServiceConfig{Name: "a", BasePath: "/api", Hosts: []string{"a.example.com", "API.example.com"}},
+			b:       config.ServiceConfig{Name: "b", BasePath: "/api", Hosts: []string{"api.example.com."}},
+			wantErr: true,
+		},
+		{
+			name: "host-bound and unbound",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api", Hosts: []string{"a.example.com"}},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api"},
+		},
+		{
+			name: "different specificity",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api", Match: header("X-Version", "2")},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api"},
+		},
+		{
+			name: "different exact values",
+			a:    config.ServiceConfig{Name: "a", BasePath: "/api", Match: header("X-Version", "1")},
+			b:    config.ServiceConfig{Name: "b", BasePath: "/api", Match: header("x-version", "2")},
+		},
+		{
+			name:    "exact values of different headers",
+			a:       config.ServiceConfig{Name: "a", BasePath: "/api", Match: header("X-Version", "1")},
+			b:       config.ServiceConfig{Name: "b", BasePath: "/api", Match: header("X-Region", "eu")},
+			wantErr: true,
+		},
+		{
+			name:    "shared method",
+			a:       config.ServiceConfig{Name: "a", BasePath: "/api", Match: config.RouteMatchConfig{Methods: []string{"GET", "POST"}}},
+			b:       config.ServiceConfig{Name: "b", BasePath: "/api", Match: config.RouteMatchConfig{Methods: []string{"post"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildTable([]config.ServiceConfig{tt.a, tt.b})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteTableMatch(t *testing.T) {
+	services := []config.ServiceConfig{
+		{Name: "root", BasePath: "/"},
+		{Name: "api", BasePath: "/api"},
+		{Name: "api-v2", BasePath: "/api", Match: header("X-Version", "2")},
+		{Name: "users", BasePath: "/api/users"},
+		{Name: "admin", BasePath: "/api", Hosts: []string{"admin.example.com"}},
+		{Name: "tenants", BasePath: "/", Hosts: []string{"*.example.com"}},
+	}
+	table, err := buildTable(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := newHostMatcher(services, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		path    string
+		version string
+		want    string
+	}{
+		{name: "base path", path: "/api", want: "api"},
+		{name: "longest base path", path: "/api/users/1", want: "users"},
+		{name: "whole segments only", path: "/api/usersettings", want: "api"},
+		{name: "predicates", path: "/api/users", version: "2", want: "users"},
+		{name: "predicates on the same path", path: "/api/games", version: "2", want: "api-v2"},
+		{name: "unmatched predicates", path: "/api/games", version: "3", want: "api"},
+		{name: "root", path: "/games", want: "root"},
+		{name: "exact host before shorter paths", host: "admin.example.com", path: "/api/users", want: "admin"},
+		{name: "exact host before wildcard", host: "admin.example.com", path: "/", want: "root"},
+		{name: "wildcard host", host: "eu.example.com", path: "/api/users", want: "tenants"},
+		{name: "unknown host", host: "example.org", path: "/api/users", want: "users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.version != "" {
+				req.Header.Set("X-Version", tt.version)
+			}
+
+			got := evaluate(t, req, func(c *fiber.Ctx) string {
+				if rt := table.match(c, hosts.resolve(c)); rt != nil {
+					return rt.service.Name
+				}
+				return ""
+			})
+			if got != tt.want {
+				t.Errorf("route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// hostMatcher resolves the virtual host of a request out of the host
+// patterns configured on the services
+type hostMatcher struct {
+	exact map[string]struct{}
+	// wildcards holds the suffixes of *.domain patterns, longest first
+	wildcards []string
+	// fallback is the pattern used for hosts that match no service
+	fallback string
+}
+
+// newHostMatcher collects the host patterns of all services
+func newHostMatcher(services []config.ServiceConfig, defaultHost string) (*hostMatcher, error) {
+	m := &hostMatcher{
+		exact: make(map[string]struct{}),
+	}
+
+	seen := make(map[string]struct{})
+	for _, svc := range services {
+		for _, host := range svc.Hosts {
+			pattern, err := normalizeHostPattern(host)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %w", svc.Name, err)
+			}
+			if _, ok := seen[pattern]; ok {
+				continue
+			}
+			seen[pattern] = struct{}{}
+
+			if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+				m.wildcards = append(m.wildcards, suffix)
+			} else {
+				m.exact[pattern] = struct{}{}
+			}
+		}
+	}
+	sort.Slice(m.wildcards, func(i, j int) bool {
+		return len(m.wildcards[i]) > len(m.wildcards[j])
+	})
+
+	if defaultHost != "" {
+		m.fallback = m.match(normalizeHost(defaultHost))
+		if m.fallback == "" {
+			return nil, fmt.Errorf("default host %q matches no service hosts", defaultHost)
+		}
+	}
+
+	return m, nil
+}
+
+// match returns the most specific pattern for the host, or an empty string
+func (m *hostMatcher) match(host string) string {
+	if _, ok := m.exact[host]; ok {
+		return host
+	}
+	for _, suffix := range m.wildcards {
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return "*" + suffix
+		}
+	}
+	return ""
+}
+
+// resolve returns the host pattern a request is routed by
+func (m *hostMatcher) resolve(c *fiber.Ctx) string {
+	if pattern := m.match(requestHost(c)); pattern != "" {
+		return pattern
+	}
+	return m.fallback
+}
+
+// requestHost returns the host a request was sent to, using the TLS server
+// name when the request carries no Host header
+func requestHost(c *fiber.Ctx) string {
+	host := c.Hostname()
+	if host == "" {
+		if state := c.Context().TLSConnectionState(); state != nil {
+			host = state.ServerName
+		}
+	}
+	return normalizeHost(host)
+}
+
+// normalizeHost lowercases a host and strips the port and trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// normalizeHostPattern validates and normalizes a configured host or *.domain wildcard
+func normalizeHostPattern(pattern string) (string, error) {
+	host := normalizeHost(pattern)
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		if rest == "" || strings.Contains(rest, "*") {
+			return "", fmt.Errorf("invalid wildcard host %q", pattern)
+		}
+		return host, nil
+	}
+	if strings.Contains(host, "*") {
+		return "", fmt.Errorf("wildcard host %q must have the form *.domain", pattern)
+	}
+	return host, nil
+}
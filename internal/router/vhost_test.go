@@ -0,0 +1,88 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api-gateway/internal/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestHostMatcherResolve(t *testing.T) {
+	services := []config.ServiceConfig{
+		{Name: "admin", Hosts: []string{"Admin.Example.com."}},
+		{Name: "tenants", Hosts: []string{"*.example.com"}},
+		{Name: "eu", Hosts: []string{"*.eu.example.com", "*.example.com"}},
+	}
+
+	tests := []struct {
+		name        string
+		defaultHost string
+		host        string
+		want        string
+	}{
+		{name: "exact host", host: "admin.example.com", want: "admin.example.com"},
+		{name: "case and port ignored", host: "ADMIN.example.com:8443", want: "admin.example.com"},
+		{name: "wildcard host", host: "games.example.com", want: "*.example.com"},
+		{name: "longest wildcard", host: "games.eu.example.com", want: "*.eu.example.com"},
+		{name: "wildcard needs a subdomain", host: "example.com"},
+		{name: "unknown host", host: "example.org"},
+		{name: "default host", defaultHost: "admin.example.com", host: "example.org", want: "admin.example.com"},
+		{name: "default wildcard host", defaultHost: "www.example.com", host: "example.org", want: "*.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newHostMatcher(services, tt.defaultHost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendString(m.resolve(c))
+			})
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("host = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHostMatcherErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		hosts       []string
+		defaultHost string
+	}{
+		{name: "empty host", hosts: []string{""}},
+		{name: "wildcard without domain", hosts: []string{"*."}},
+		{name: "wildcard inside the host", hosts: []string{"api.*.example.com"}},
+		{name: "two wildcards", hosts: []string{"*.*.example.com"}},
+		{name: "default host matching no service", hosts: []string{"api.example.com"}, defaultHost: "example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []config.ServiceConfig{{Name: "api", Hosts: tt.hosts}}
+			if _, err := newHostMatcher(services, tt.defaultHost); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"time"
+
+	"api-gateway/internal/config"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// configDebounce groups the burst of events produced by a single config write
+const configDebounce = 100 * time.Millisecond
+
+// Reload triggers used as metric labels
+const (
+	reloadSignal = "signal"
+	reloadFile   = "file"
+)
+
+// Reload results used as metric labels
+const (
+	reloadSuccess = "success"
+	reloadFailure = "failure"
+)
+
+// WatchConfig reloads the configuration file on SIGHUP and whenever the file changes
+func (s *Server) WatchConfig(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Watch the directory so files replaced by rename, such as mounted
+	// Kubernetes ConfigMaps, keep being picked up
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer watcher.Close()
+		defer signal.Stop(hangup)
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				debounce = time.After(configDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				s.logger.Warn("Config watcher error",
+					zap.String("path", path),
+					zap.Error(err))
+			case <-debounce:
+				debounce = nil
+				// Writes to other files of the directory do not reload
+				current, err := os.ReadFile(path)
+				if err != nil || bytes.Equal(current, content) {
+					continue
+				}
+				content = current
+				s.reload(path, reloadFile)
+			case <-hangup:
+				s.reload(path, reloadSignal)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+
+	s.logger.Info("Watching configuration for changes", zap.String("path", path))
+	return nil
+}
+
+// reload loads and validates the configuration file and swaps it in. An
+// invalid file leaves the current configuration in place.
+func (s *Server) reload(path, trigger string) {
+	cfg, err := config.Load(path)
+	var replaced []string
+	if err == nil {
+		replaced = changedServices(s.applied.Services, s.router.Services())
+		err = s.router.Reload(cfg)
+	}
+	if err != nil {
+		s.reloads.WithLabelValues(trigger, reloadFailure).Inc()
+		s.logger.Error("Failed to reload configuration, keeping the current one",
+			zap.String("path", path),
+			zap.String("trigger", trigger),
+			zap.Error(err))
+		return
+	}
+
+	s.reloads.WithLabelValues(trigger, reloadSuccess).Inc()
+	s.lastReload.SetToCurrentTime()
+	s.logger.Info("Configuration reloaded",
+		zap.String("path", path),
+		zap.String("trigger", trigger),
+		zap.Int("services", len(cfg.Services)))
+
+	if len(replaced) > 0 {
+		s.logger.Warn("Configuration reload replaced services changed through the admin API",
+			zap.Strings("services", replaced))
+	}
+	if sections := restartSections(s.applied, cfg); len(sections) > 0 {
+		s.logger.Warn("Configuration changes that require a restart were not applied",
+			zap.Strings("sections", sections))
+	}
+	s.applied = appliedConfig(s.applied, cfg)
+}
+
+// restartSections lists the changed configuration sections that are only
+// read at startup. The default host is applied with the routes.
+func restartSections(current, next *config.Config) []string {
+	currentServer, nextServer := current.Server, next.Server
+	currentServer.DefaultHost, nextServer.DefaultHost = "", ""
+
+	var sections []string
+	for name, changed := range map[string]bool{
+		"server":    !reflect.DeepEqual(currentServer, nextServer),
+		"proxy":     !reflect.DeepEqual(current.Proxy, next.Proxy),
+		"logging":   !reflect.DeepEqual(current.Logging, next.Logging),
+		"metrics":   !reflect.DeepEqual(current.Metrics, next.Metrics),
+		"tracing":   !reflect.DeepEqual(current.Tracing, next.Tracing),
+		"discovery": !reflect.DeepEqual(current.Discovery, next.Discovery),
+		"admin":     !reflect.DeepEqual(current.Admin, next.Admin),
+	} {
+		if changed {
+			sections = append(sections, name)
+		}
+	}
+	sort.Strings(sections)
+	return sections
+}
+
+// appliedConfig returns the configuration in effect after a reload. The
+// sections only read at startup keep their current values, so changes to
+// them are reported until the gateway restarts.
+func appliedConfig(current, next *config.Config) *config.Config {
+	applied := *next
+	applied.Server = current.Server
+	applied.Server.DefaultHost = next.Server.DefaultHost
+	applied.Proxy = current.Proxy
+	applied.Logging = current.Logging
+	applied.Metrics = current.Metrics
+	applied.Tracing = current.Tracing
+	applied.Discovery = current.Discovery
+	applied.Admin = current.Admin
+	return &applied
+}
+
+// changedServices lists the services that differ between the applied
+// configuration and the running routes, such as services changed through the
+// admin API
+func changedServices(applied, running []config.ServiceConfig) []string {
+	configured := make(map[string]config.ServiceConfig, len(applied))
+	for _, svc := range applied {
+		configured[svc.Name] = svc
+	}
+
+	var changed []string
+	seen := make(map[string]struct{}, len(running))
+	for _, svc := range running {
+		seen[svc.Name] = struct{}{}
+		if current, ok := configured[svc.Name]; !ok || !reflect.DeepEqual(current, svc) {
+			changed = append(changed, svc.Name)
+		}
+	}
+	for _, svc := range applied {
+		if _, ok := seen[svc.Name]; !ok {
+			changed = append(changed, svc.Name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
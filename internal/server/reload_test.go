@@ -0,0 +1,97 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"api-gateway/internal/config"
+)
+
+func TestRestartSections(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(cfg *config.Config)
+		want   []string
+	}{
+		{name: "unchanged", change: func(cfg *config.Config) {}},
+		{name: "services", change: func(cfg *config.Config) { cfg.Services = nil }},
+		{name: "default host", change: func(cfg *config.Config) { cfg.Server.DefaultHost = "other.example.com" }},
+		{name: "port", change: func(cfg *config.Config) { cfg.Server.Port = 9090 }, want: []string{"server"}},
+		{
+			name: "several sections",
+			change: func(cfg *config.Config) {
+				cfg.Proxy.Timeout = 60
+				cfg.Admin.Enable = true
+			},
+			want: []string{"admin", "proxy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := testConfig()
+			next := testConfig()
+			tt.change(next)
+			if got := restartSections(current, next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restartSections = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppliedConfig(t *testing.T) {
+	current := testConfig()
+	next := testConfig()
+	next.Server.Port = 9090
+	next.Server.DefaultHost = "other.example.com"
+	next.Proxy.Timeout = 60
+	next.Services = nil
+
+	applied := appliedConfig(current, next)
+	if applied.Server.Port != current.Server.Port || applied.Proxy.Timeout != current.Proxy.Timeout {
+		t.Errorf("sections read at startup were replaced: %+v", applied)
+	}
+	if applied.Server.DefaultHost != next.Server.DefaultHost || applied.Services != nil {
+		t.Errorf("reloaded settings were not applied: %+v", applied)
+	}
+
+	// Changes that still need a restart are reported again on the next reload
+	if got := restartSections(applied, next); !reflect.DeepEqual(got, []string{"proxy", "server"}) {
+		t.Errorf("restartSections after reload = %v", got)
+	}
+}
+
+func TestChangedServices(t *testing.T) {
+	api := config.ServiceConfig{Name: "api", BasePath: "/api"}
+	apiV2 := config.ServiceConfig{Name: "api", BasePath: "/api/v2"}
+	ws := config.ServiceConfig{Name: "ws", BasePath: "/ws"}
+
+	tests := []struct {
+		name    string
+		applied []config.ServiceConfig
+		running []config.ServiceConfig
+		want    []string
+	}{
+		{name: "unchanged", applied: []config.ServiceConfig{api, ws}, running: []config.ServiceConfig{api, ws}},
+		{name: "changed", applied: []config.ServiceConfig{api, ws}, running: []config.ServiceConfig{apiV2, ws}, want: []string{"api"}},
+		{name: "created", applied: []config.ServiceConfig{api}, running: []config.ServiceConfig{api, ws}, want: []string{"ws"}},
+		{name: "deleted", applied: []config.ServiceConfig{api, ws}, running: []config.ServiceConfig{ws}, want: []string{"api"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := changedServices(tt.applied, tt.running); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedServices = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testConfig returns a configuration with a service and a default host
+func testConfig() *config.Config {
+	return &config.Config{
+		Server:   config.ServerConfig{Port: 8080, DefaultHost: "api.example.com"},
+		Proxy:    config.ProxyConfig{Timeout: 30},
+		Services: []config.ServiceConfig{{Name: "api", BasePath: "/api"}},
+	}
+}
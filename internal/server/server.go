@@ -3,11 +3,13 @@ package server
 import (
 	"context"
 	"fmt"
-	"strings"
+	"net"
+	"sync"
 	"time"
 
 	"api-gateway/internal/config"
 	"api-gateway/internal/middleware"
+	"api-gateway/internal/proxy"
 	"api-gateway/internal/router"
 	"api-gateway/pkg/logging"
 	"api-gateway/pkg/metrics"
@@ -18,7 +20,6 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/compress"
-	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/google/uuid"
@@ -26,6 +27,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"go.opentelemetry.io/otel/propagation"
 )
@@ -36,7 +39,15 @@ type Server struct {
 	config        *config.Config
 	logger        *logging.Logger
 	router        *router.Router
+	grpc          *grpc.Server
 	tracerCleanup func(context.Context) error
+	// Configuration reloads
+	reloads    *prometheus.CounterVec
+	lastReload prometheus.Gauge
+	// applied is the configuration in effect, only used by the config watcher
+	applied *config.Config
+	stop    chan struct{}
+	wg      sync.WaitGroup
 }
 
 // New creates a new server instance
@@ -91,25 +102,17 @@ func New(cfg *config.Config, logger *logging.Logger) (*Server, error) {
 	// Add custom middleware
 	app.Use(middleware.Logger(logger))
 
-	// Add CORS middleware if enabled
-	if cfg.Security.EnableCORS {
-		app.Use(cors.New(cors.Config{
-			AllowOrigins: strings.Join(cfg.Security.CORSAllowOrigins, ","),
-			AllowMethods: "GET,POST,PUT,DELETE,OPTIONS,PATCH",
-			AllowHeaders: "Origin,Content-Type,Accept,Authorization,Connection,Upgrade,Sec-WebSocket-Key,Sec-WebSocket-Version,Sec-WebSocket-Extensions,Sec-WebSocket-Protocol,X-Request-ID",
-			AllowCredentials: false, // TODO: Change to true if we want to allow credentials
-			ExposeHeaders: "Upgrade,Connection,Sec-WebSocket-Accept,Sec-WebSocket-Protocol,X-Request-ID",
-		}))
-	}
+	// CORS and authentication run in the middleware pipelines of the service
+	// routes, so they do not apply to the health and metrics endpoints
 
-	// Add security middleware if enabled
-	if cfg.Security.EnableJWT {
-		app.Use(middleware.JWT(cfg.Security.JWTSecret))
+	// Create router
+	r, err := router.New(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create router: %w", err)
 	}
 
-	if cfg.Security.EnableAPIKey {
-		app.Use(middleware.APIKey(cfg.Security.APIKeys))
-	}
+	reloads := metrics.NewConfigReloads()
+	lastReload := metrics.NewConfigLastReloadSuccess()
 
 	if cfg.Metrics.Enable {
 		// Create Prometheus registry
@@ -124,6 +127,8 @@ func New(cfg *config.Config, logger *logging.Logger) (*Server, error) {
 		// Register metrics collectors
 		promRegistry.MustRegister(httpRequestsTotal)
 		promRegistry.MustRegister(httpRequestDuration)
+		promRegistry.MustRegister(r.Collectors()...)
+		promRegistry.MustRegister(reloads, lastReload)
 
 		// HTTP requests monitoring middleware
 		app.Use(middleware.NewPrometheusMiddleware(httpRequestsTotal, httpRequestDuration))
@@ -138,10 +143,22 @@ func New(cfg *config.Config, logger *logging.Logger) (*Server, error) {
 		)))
 	}
 
-	// Create router
-	r, err := router.New(cfg, logger)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create router: %w", err)
+	// Every gRPC call is relayed as raw messages to the route of its method
+	var grpcServer *grpc.Server
+	if cfg.Server.GRPCPort > 0 {
+		opts := []grpc.ServerOption{
+			grpc.ForceServerCodec(proxy.GRPCCodec{}),
+			grpc.UnknownServiceHandler(r.HandleGRPC),
+		}
+		if cfg.Security.EnableTLS {
+			creds, err := credentials.NewServerTLSFromFile(cfg.Security.TLSCertFile, cfg.Security.TLSKeyFile)
+			if err != nil {
+				r.Close()
+				return nil, fmt.Errorf("failed to load gRPC TLS certificate: %w", err)
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		grpcServer = grpc.NewServer(opts...)
 	}
 
 	// Create server
@@ -150,7 +167,12 @@ func New(cfg *config.Config, logger *logging.Logger) (*Server, error) {
 		config:        cfg,
 		logger:        logger,
 		router:        r,
+		grpc:          grpcServer,
 		tracerCleanup: tracerCleanup,
+		reloads:       reloads,
+		lastReload:    lastReload,
+		applied:       cfg,
+		stop:          make(chan struct{}),
 	}
 
 	// Register routes
@@ -161,8 +183,21 @@ func New(cfg *config.Config, logger *logging.Logger) (*Server, error) {
 	return server, nil
 }
 
-// Start starts the server
+// Start starts the server, and the gRPC listener when it is enabled
 func (s *Server) Start() error {
+	if s.grpc != nil {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.config.Server.GRPCPort))
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		go func() {
+			if err := s.grpc.Serve(lis); err != nil {
+				s.logger.Error("gRPC server stopped", zap.Error(err))
+			}
+		}()
+		s.logger.Info("gRPC listener started", zap.Int("port", s.config.Server.GRPCPort))
+	}
+
 	addr := fmt.Sprintf(":%d", s.config.Server.Port)
 	if s.config.Security.EnableTLS {
 		return s.app.ListenTLS(
@@ -187,7 +222,31 @@ func (s *Server) Shutdown() error {
 		}
 	}
 
-	return s.app.ShutdownWithContext(ctx)
+	// Stop watching the configuration
+	close(s.stop)
+	s.wg.Wait()
+
+	// Let gRPC calls finish until the timeout, then cancel them
+	if s.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			s.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.grpc.Stop()
+		}
+	}
+
+	// Let HTTP requests finish before stopping the work they depend on
+	err := s.app.ShutdownWithContext(ctx)
+
+	// Stop background health checks
+	s.router.Close()
+
+	return err
 }
 
 // registerRoutes registers all routes with the router
@@ -195,14 +254,14 @@ func (s *Server) registerRoutes() error {
 	// Register health check endpoint
 	s.app.Get("/health", s.handleHealthCheck)
 
-	// Register service routes
-	for _, svc := range s.config.Services {
-		if err := s.router.RegisterService(s.app, svc); err != nil {
-			return fmt.Errorf("failed to register service %s: %w", svc.Name, err)
-		}
-		s.logger.Info("Registered service", zap.String("service", svc.Name))
+	// Register the admin API before the catch-all service routes
+	if s.config.Admin.Enable {
+		s.router.RegisterAdmin(s.app)
 	}
 
+	// Register service routes
+	s.router.Register(s.app)
+
 	return nil
 }
 
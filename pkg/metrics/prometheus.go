@@ -37,3 +37,170 @@ func NewHttpRequestDuration() *prometheus.HistogramVec {
 	)
 }
 
+// NewUpstreamHealthy creates a new gauge vector for upstream target health
+func NewUpstreamHealthy() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "upstream_healthy",
+			Help:      "Whether an upstream target is healthy (1) or not (0)",
+		},
+		[]string{"service", "target"},
+	)
+}
+
+// NewUpstreamHealthTransitions creates a new counter vector for upstream health state changes
+func NewUpstreamHealthTransitions() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_health_transitions_total",
+			Help:      "Total number of upstream target health state changes",
+		},
+		[]string{"service", "target", "state"},
+	)
+}
+
+// NewUpstreamEjections creates a new counter vector for outlier ejections
+func NewUpstreamEjections() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_ejections_total",
+			Help:      "Total number of upstream targets ejected by outlier detection",
+		},
+		[]string{"service", "target"},
+	)
+}
+
+// NewUpstreamEffectiveWeightDesc describes the effective weight of upstream targets during slow start
+func NewUpstreamEffectiveWeightDesc() *prometheus.Desc {
+	return prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "upstream_effective_weight"),
+		"Effective load balancing weight of upstream targets, reduced while they warm up",
+		[]string{"service", "target"},
+		nil,
+	)
+}
+
+// NewUpstreamGroupRequests creates a new counter vector for requests per target group
+func NewUpstreamGroupRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "upstream_group_requests_total",
+			Help:      "Total number of proxied requests per service target group and outcome",
+		},
+		[]string{"service", "group", "outcome"},
+	)
+}
+
+// NewUpstreamGroupRequestDuration creates a new histogram vector for request latency per target group
+func NewUpstreamGroupRequestDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "upstream_group_request_duration_seconds",
+			Help:      "Upstream request duration in seconds per service target group",
+			Buckets:   defaultBuckets,
+		},
+		[]string{"service", "group"},
+	)
+}
+
+// NewCanaryDecisions creates a new counter vector for canary analysis decisions
+func NewCanaryDecisions() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "canary_decisions_total",
+			Help:      "Total number of canary analysis decisions per service and action",
+		},
+		[]string{"service", "action"},
+	)
+}
+
+// NewCanaryWeight creates a new gauge vector for the traffic share of canary groups
+func NewCanaryWeight() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "canary_weight_percent",
+			Help:      "Share of traffic in percent sent to the canary target group",
+		},
+		[]string{"service"},
+	)
+}
+
+// NewMirrorRequests creates a new counter vector for mirrored shadow requests
+func NewMirrorRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mirror_requests_total",
+			Help:      "Total number of requests mirrored to shadow services per service and outcome",
+		},
+		[]string{"service", "outcome"},
+	)
+}
+
+// NewMirrorRequestDuration creates a new histogram vector for shadow request latency
+func NewMirrorRequestDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "mirror_request_duration_seconds",
+			Help:      "Shadow request duration in seconds per service and outcome",
+			Buckets:   defaultBuckets,
+		},
+		[]string{"service", "outcome"},
+	)
+}
+
+// NewConfigReloads creates a new counter vector for configuration reloads
+func NewConfigReloads() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "config_reloads_total",
+			Help:      "Total number of configuration reloads per trigger and result",
+		},
+		[]string{"trigger", "result"},
+	)
+}
+
+// NewConfigLastReloadSuccess creates a new gauge for the time of the last successful configuration reload
+func NewConfigLastReloadSuccess() prometheus.Gauge {
+	return prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Unix time of the last successful configuration reload",
+		},
+	)
+}
+
+// NewGRPCRequests creates a new counter vector for proxied gRPC calls
+func NewGRPCRequests() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "Total number of proxied gRPC calls per service, method and status code",
+		},
+		[]string{"service", "method", "code"},
+	)
+}
+
+// NewGRPCRequestDuration creates a new histogram vector for proxied gRPC call latency
+func NewGRPCRequestDuration() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Proxied gRPC call duration in seconds per service and method",
+			Buckets:   defaultBuckets,
+		},
+		[]string{"service", "method"},
+	)
+}